@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"github.com/newm4n/mihp/central/server/utils"
+	"github.com/newm4n/mihp/internal/probing"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// acknowledgements keeps the latest acknowledgement of each probe until the minions fetch it.
+	acknowledgements      = make(map[string]*probing.Acknowledgement)
+	acknowledgementsMutex sync.RWMutex
+)
+
+// HandleListAcknowledgements lists the latest acknowledgement of each probe.
+func HandleListAcknowledgements(w http.ResponseWriter, r *http.Request) {
+	acknowledgementsMutex.RLock()
+	list := make([]*probing.Acknowledgement, 0, len(acknowledgements))
+	for _, ack := range acknowledgements {
+		list = append(list, ack)
+	}
+	acknowledgementsMutex.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ProbeID < list[j].ProbeID
+	})
+	utils.SuccessResponseWithData(w, http.StatusOK, "acknowledgements", list)
+}
+
+// HandleAcknowledge acknowledges the current outage of the probe of the path, on behalf of the token's subject.
+func HandleAcknowledge(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		utils.ErrorResponse(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ack := &probing.Acknowledgement{
+		ProbeID: r.Header.Get("probeid"),
+		By:      r.Context().Value("AUTH-SPEC").(*JWTSpec).Subject,
+		At:      time.Now(),
+	}
+	acknowledgementsMutex.Lock()
+	acknowledgements[ack.ProbeID] = ack
+	acknowledgementsMutex.Unlock()
+	utils.SuccessResponseWithData(w, http.StatusCreated, "outage acknowledged", ack)
+}
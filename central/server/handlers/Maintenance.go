@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/newm4n/mihp/central/server/utils"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/internal/probing"
+	"io/ioutil"
+	"net/http"
+)
+
+// authorized checks the request carries a valid access token.
+func authorized(r *http.Request) bool {
+	spec, ok := r.Context().Value("AUTH-SPEC").(*JWTSpec)
+	if !ok || spec == nil {
+		return false
+	}
+	typ, ok := spec.Additional["Typ"]
+	return ok && typ == "ACCESS"
+}
+
+// HandleListMaintenance lists the maintenance windows.
+func HandleListMaintenance(w http.ResponseWriter, r *http.Request) {
+	utils.SuccessResponseWithData(w, http.StatusOK, "maintenance windows", probing.Maintenance.List())
+}
+
+// HandleCreateMaintenance adds an ad hoc maintenance window, given an ID if the body has none.
+func HandleCreateMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		utils.ErrorResponse(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		utils.ErrorResponse(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	window := &internal.MaintenanceWindow{}
+	if err := json.Unmarshal(bodyBytes, window); err != nil {
+		utils.ErrorResponse(w, "Error while parsing json body. got "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := probing.Maintenance.Add(window); err != nil {
+		utils.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utils.SuccessResponseWithData(w, http.StatusCreated, "maintenance window added", window)
+}
+
+// HandleDeleteMaintenance removes the maintenance window of the path.
+func HandleDeleteMaintenance(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		utils.ErrorResponse(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !probing.Maintenance.Remove(r.Header.Get("windowid")) {
+		utils.ErrorResponse(w, "maintenance window not found", http.StatusNotFound)
+		return
+	}
+	utils.SuccessResponse(w, http.StatusOK, "maintenance window removed")
+}
@@ -12,6 +12,13 @@ func Routing(mux *mux.HyperMux) {
 
 	mux.AddRoute(PrefixPath+"/login", "POST", HandleLogin)
 	mux.AddRoute(PrefixPath+"/refresh", "POST", HandleRefresh)
+
+	mux.AddRoute(PrefixPath+"/maintenance", "GET", HandleListMaintenance)
+	mux.AddRoute(PrefixPath+"/maintenance", "POST", HandleCreateMaintenance)
+	mux.AddRoute(PrefixPath+"/maintenance/{windowid}", "DELETE", HandleDeleteMaintenance)
+
+	mux.AddRoute(PrefixPath+"/acknowledgements", "GET", HandleListAcknowledgements)
+	mux.AddRoute(PrefixPath+"/probe/{probeid}/ack", "POST", HandleAcknowledge)
 	//
 	//mux.AddRoute(PrefixPath+"/probe", "POST", HandleProbeRegister)
 	//mux.AddRoute(PrefixPath+"/probe/{probeid}", "GET", HandleProbePing)
@@ -36,6 +36,8 @@ func main() {
 	runOncePtr := flag.String("once", "", "Probe name to run once when minion is started. Use in conjunction with -minion. Probe result will displayed directly in the console")
 	setupPtr := flag.Bool("setup", false, "Create/Modify a configuration file interactively")
 	configFilePtr := flag.String("config", "", "Configuration file to use.")
+	timeoutPtr := flag.Int("timeout", probing.DefaultTimeoutSecond, "Probe timeout in seconds. Use in conjunction with -once.")
+	validatePtr := flag.Bool("validate", false, "Validate the configuration file without running any probe. Exit with non-zero code if errors are found.")
 	helpPtr := flag.Bool("help", false, "Show this help.")
 
 	flag.Parse()
@@ -45,20 +47,24 @@ func main() {
 	startCentral := *centralPtr
 	runOnce := *runOncePtr
 	setup := *setupPtr
+	validate := *validatePtr
 	help := *helpPtr
+	timeout := *timeoutPtr
 
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage : %s (-central|-minion|-config|-once <probe>|-setup) -config <config-file>\n  Arguments:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage : %s (-central|-minion|-config|-once <probe>|-setup|-validate) -config <config-file>\n  Arguments:\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprintf(flag.CommandLine.Output(), "Visit https://github.com/newm4n/mihp/documentation.md to know how to use MIHP\n")
 	}
 
 	if help {
 		flag.Usage()
+	} else if validate {
+		os.Exit(Validate(configFile))
 	} else if setup {
 		Setup(configFile)
 	} else if len(runOnce) > 0 {
-		ProbeOnce(runOnce, configFile)
+		ProbeOnce(runOnce, configFile, timeout)
 	} else if startMinion {
 		StartMinion(configFile)
 	} else if startCentral {
@@ -202,7 +208,28 @@ func StartMinion(config string) {
 	}
 }
 
-func ProbeOnce(probeName, config string) {
+// Validate checks all probes in the configuration file and prints the issues found.
+// Returns the process exit code, 1 if the configuration has any error.
+func Validate(config string) int {
+	yamlBytes, err := ioutil.ReadFile(config)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Got error while reading %s got %s\n", config, err.Error())
+		return 2
+	}
+	cfg, err := internal.YAMLToMIHPConfig(yamlBytes)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s is not a valid configuration. got %s\n", config, err.Error())
+		return 2
+	}
+	report := probing.ValidateConfig(config, cfg)
+	fmt.Print(report.String())
+	if report.HasError() {
+		return 1
+	}
+	return 0
+}
+
+func ProbeOnce(probeName, config string, timeout int) {
 	fmt.Println("Bye.")
 	file, err := os.Open(config)
 	if err != nil {
@@ -227,12 +254,14 @@ func ProbeOnce(probeName, config string) {
 		fmt.Printf("Configuration file %s contains no probe", config)
 		return
 	}
+	if cfg.Minion != nil {
+		probing.DefaultProxy = cfg.Minion.Proxy
+	}
 	for _, probe := range cfg.ProbePool {
 		if probe.Name == probeName {
-			timeout := 10
 			pCtx := internal.NewProbeContext()
 			fmt.Printf("Probing once. time-out %d seconds\n", timeout)
-			err := probing.ExecuteProbe(context.Background(), probe, pCtx, timeout, true, true)
+			err := probing.ExecuteProbe(context.Background(), probe, pCtx, timeout, false, true)
 			if err != nil {
 				fmt.Printf("Error while runing probe %s. Got %s.\n", probe.Name, err.Error())
 				fmt.Printf("If the error is about I/O, check for some firewall or VPN.\n")
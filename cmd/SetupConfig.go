@@ -13,8 +13,8 @@ import (
 	"github.com/newm4n/mihp/pkg/helper/cron"
 	"github.com/olekukonko/tablewriter"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
 	"net"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
@@ -46,10 +46,11 @@ func showMainMenu(config *internal.MIHPConfig, configFile string) (err error) {
 		} else {
 			table.Append([]string{"Minion", "Configured"})
 		}
+		table.Append([]string{"Maintenance Windows", fmt.Sprintf("%d configured", len(config.Maintenance))})
 		table.SetAlignment(tablewriter.ALIGN_LEFT)
 		table.Render()
 
-		selected := interact.Select("What to do ?", []string{"Manage probes", "Configure Central", "Configure Minion", "Save and Finish"}, 1, 4, false)
+		selected := interact.Select("What to do ?", []string{"Manage probes", "Configure Central", "Configure Minion", "Manage Maintenance Windows", "Save and Finish"}, 1, 5, false)
 
 		switch selected {
 		case 1:
@@ -68,6 +69,8 @@ func showMainMenu(config *internal.MIHPConfig, configFile string) (err error) {
 				return err
 			}
 		case 4:
+			config.Maintenance = manageMaintenance("GLOBAL", config.Maintenance, true)
+		case 5:
 			err = saveAndExist(config, configFile)
 			if err != nil {
 				return err
@@ -222,6 +225,38 @@ func editProbe(probe *internal.Probe) {
 		} else {
 			table.Append([]string{"DownThreshold", "Not Set"})
 		}
+		table.Append([]string{"Flap Detection", fmt.Sprintf("%d changes in %d results",
+			intDefault(probe.FlapThreshold, probing.DefaultFlapThreshold), intDefault(probe.FlapWindow, probing.DefaultFlapWindow))})
+		if probe.FreshConnection {
+			table.Append([]string{"Connection", "Fresh connection for each request"})
+		} else {
+			table.Append([]string{"Connection", "Keep-alive between requests"})
+		}
+		if probe.Deadline > 0 {
+			table.Append([]string{"Deadline", probe.Deadline.String()})
+		} else {
+			table.Append([]string{"Deadline", "None"})
+		}
+		if probe.LatencyBudget > 0 {
+			table.Append([]string{"Latency Budget", probe.LatencyBudget.String()})
+		} else {
+			table.Append([]string{"Latency Budget", "None"})
+		}
+		if probe.Proxy != nil {
+			table.Append([]string{"Proxy", probe.Proxy.String()})
+		} else {
+			table.Append([]string{"Proxy", "Minion default"})
+		}
+		if probe.Auth != nil {
+			table.Append([]string{"Authentication", probe.Auth.Type})
+		} else {
+			table.Append([]string{"Authentication", "None"})
+		}
+		if probe.TLS != nil {
+			table.Append([]string{"TLS", "Configured"})
+		} else {
+			table.Append([]string{"TLS", "Default"})
+		}
 		if probe.SMTPNotification != nil {
 			table.Append([]string{"SMTP Notification", "Configured"})
 		} else {
@@ -232,6 +267,12 @@ func editProbe(probe *internal.Probe) {
 		} else {
 			table.Append([]string{"Callback Notification", "Not Configured"})
 		}
+		table.Append([]string{"Maintenance Windows", fmt.Sprintf("%d configured", len(probe.Maintenance))})
+		if probe.Escalation != nil {
+			table.Append([]string{"Escalation", fmt.Sprintf("re-notify every %s, escalate after %s", probe.Escalation.RenotifyEvery, probe.Escalation.EscalateAfter)})
+		} else {
+			table.Append([]string{"Escalation", "None"})
+		}
 
 		table.SetAlignment(tablewriter.ALIGN_LEFT)
 		table.Render()
@@ -239,8 +280,9 @@ func editProbe(probe *internal.Probe) {
 		selected := interact.Select("What to do ?", []string{
 			"Set Probe Name", "Set Probe ID", "Manage Probe Requests",
 			"Set Probe Base URL", "Set Probe CRON",
-			"Set Up Threshold", "Set DownThreshold", "Configure SMTP Notification",
-			"Configure Callback Notification", "Test Probe", "Finish"}, 1, 11, false)
+			"Set Up Threshold", "Set DownThreshold", "Set Flap Detection", "Configure SMTP Notification",
+			"Configure Callback Notification", "Set Connection Mode", "Set Probe Deadline", "Set Latency Budget", "Configure TLS", "Configure Proxy", "Configure Authentication",
+			"Manage Maintenance Windows", "Configure Escalation", "Test Probe", "Finish"}, 1, 20, false)
 
 		switch selected {
 		case 1:
@@ -278,15 +320,38 @@ func editProbe(probe *internal.Probe) {
 		case 7:
 			probe.DownThreshold = interact.AskNumber("New Down Threshold", 2, 10, intDefault(probe.DownThreshold, 3), false)
 		case 8:
-			configureSNMPNotification(probe)
+			probe.FlapWindow = interact.AskNumber("How many of the latest results to check for flapping?", 2, probing.HistorySize, intDefault(probe.FlapWindow, probing.DefaultFlapWindow), false)
+			flapThreshold := intDefault(probe.FlapThreshold, probing.DefaultFlapThreshold)
+			if flapThreshold >= probe.FlapWindow {
+				flapThreshold = probe.FlapWindow - 1
+			}
+			probe.FlapThreshold = interact.AskNumber("How many status changes among them make the probe flapping?", 1, probe.FlapWindow-1, flapThreshold, false)
 		case 9:
-			configureCallbackNotification(probe)
+			configureSNMPNotification(probe)
 		case 10:
-			timeout := interact.AskNumber("Probe timeout in seconds?", 3, 3600, 10, false)
+			configureCallbackNotification(probe)
+		case 11:
+			probe.FreshConnection = interact.Confirm("Use a fresh connection for each request instead of keep-alive ?", probe.FreshConnection)
+		case 12:
+			probe.Deadline = askDuration("Deadline for the whole probe request chain? (0s for no deadline)", probe.Deadline)
+		case 13:
+			probe.LatencyBudget = askDuration("Latency budget for the whole probe request chain, slower runs are DEGRADED? (0s for no budget)", probe.LatencyBudget)
+		case 14:
+			configureProbeTLS(probe)
+		case 15:
+			probe.Proxy = configureProxy(probe.Proxy)
+		case 16:
+			probe.Auth = configureAuth(probe.Auth, false)
+		case 17:
+			probe.Maintenance = manageMaintenance(probe.Name, probe.Maintenance, false)
+		case 18:
+			probe.Escalation = configureEscalation(probe.Escalation)
+		case 19:
+			timeout := interact.AskNumber("Probe timeout in seconds?", 3, 3600, probing.DefaultTimeoutSecond, false)
 			fmt.Printf("Please wait while we test the probe ... timeout in %d second\n", timeout)
 
 			pCtx := internal.NewProbeContext()
-			err := probing.ExecuteProbe(context.Background(), probe, pCtx, timeout, true, true)
+			err := probing.ExecuteProbe(context.Background(), probe, pCtx, timeout, false, true)
 			if err != nil {
 				fmt.Printf("Error while runing probe %s. Got %s. Context follows.\n%s\n", probe.Name, err.Error(), pCtx.ToString(true))
 				fmt.Printf("If the error is about I/O, check for some firewall or VPN.\n")
@@ -311,7 +376,7 @@ func editProbe(probe *internal.Probe) {
 			file.WriteString(pCtx.ToString(false))
 			fmt.Printf("Context written to %s\n", path)
 			return
-		case 11:
+		case 20:
 			return
 		}
 	}
@@ -461,6 +526,31 @@ func editProbeRequest(probe *internal.Probe, idx int) {
 		} else {
 			table.Append([]string{"Certificate Check Expression", "Not Set"})
 		}
+		if len(probeRequest.ResponseSchema) > 0 {
+			table.Append([]string{"Response Schema", probeRequest.ResponseSchema})
+		} else {
+			table.Append([]string{"Response Schema", "Not Set"})
+		}
+		if probeRequest.Auth != nil {
+			table.Append([]string{"Authentication", probeRequest.Auth.Type})
+		} else {
+			table.Append([]string{"Authentication", "Probe default"})
+		}
+		if probeRequest.LatencyBudget > 0 {
+			table.Append([]string{"Latency Budget", probeRequest.LatencyBudget.String()})
+		} else {
+			table.Append([]string{"Latency Budget", "None"})
+		}
+		if len(probeRequest.DegradedIfExpr) > 0 {
+			table.Append([]string{"Degraded Criteria Expression", probeRequest.DegradedIfExpr})
+		} else {
+			table.Append([]string{"Degraded Criteria Expression", "Not Set"})
+		}
+		if probeRequest.ShouldFollowRedirects() {
+			table.Append([]string{"Redirects", fmt.Sprintf("Follow up to %d hops", probeRequest.RedirectLimit())})
+		} else {
+			table.Append([]string{"Redirects", "Do not follow"})
+		}
 
 		table.SetAlignment(tablewriter.ALIGN_LEFT)
 		table.Render()
@@ -475,8 +565,14 @@ func editProbeRequest(probe *internal.Probe, idx int) {
 			"Set Start Request Criteria Expression",
 			"Set Success Criteria Expression",
 			"Set Fail Criteria Expression",
+			"Set Redirect Policy",
+			"Set Timeout & Retry Policy",
+			"Manage Extracted Variables",
+			"Set Response Schema",
+			"Set Authentication",
+			"Set Latency Budget & Degraded Criteria",
 			"Finish",
-		}, 1, 10, false)
+		}, 1, 16, false)
 
 		switch selected {
 		case 1:
@@ -519,7 +615,7 @@ func editProbeRequest(probe *internal.Probe, idx int) {
 			bodyExp := interact.Ask("New Body Expression? \n Must return string", stringDefault(probeRequest.BodyExpr, fmt.Sprintf("\"FooBar\"")), true)
 			probeRequest.BodyExpr = bodyExp
 		case 6:
-			expr := interact.Ask("New Certificate Check Expression? \n Must return boolean", stringDefault(probeRequest.CertificateCheckExpr, fmt.Sprintf("\"FooBar\"")), true)
+			expr := interact.Ask("New Certificate Check Expression? \n Must return boolean", stringDefault(probeRequest.CertificateCheckExpr, "true"), true)
 			probeRequest.CertificateCheckExpr = expr
 		case 7:
 			expr := interact.Ask("New Start Request Criteria Expression? \n Must return bool7ean", stringDefault(probeRequest.StartRequestIfExpr, fmt.Sprintf("\"FooBar\"")), true)
@@ -531,11 +627,59 @@ func editProbeRequest(probe *internal.Probe, idx int) {
 			expr := interact.Ask("New Fail Criteria Expression? \n Must return boolean", stringDefault(probeRequest.FailIfExpr, fmt.Sprintf("\"FooBar\"")), true)
 			probeRequest.FailIfExpr = expr
 		case 10:
+			follow := interact.Confirm("Follow redirect responses ?", probeRequest.ShouldFollowRedirects())
+			probeRequest.FollowRedirects = &follow
+			if follow {
+				probeRequest.MaxRedirects = interact.AskNumber("Maximum redirect hops to follow ?", 1, 100, probeRequest.RedirectLimit(), false)
+			}
+		case 11:
+			configureRetry(probeRequest)
+		case 12:
+			manageRequestExtract(probe.Name, probeRequest)
+		case 13:
+			configureResponseSchema(probeRequest)
+		case 14:
+			probeRequest.Auth = configureAuth(probeRequest.Auth, true)
+		case 15:
+			probeRequest.LatencyBudget = askDuration("Latency budget for this request, slower responses are DEGRADED? (0s for no budget)", probeRequest.LatencyBudget)
+			expr := interact.Ask("New Degraded Criteria Expression? \n Must return boolean", stringDefault(probeRequest.DegradedIfExpr, "this.latency.exceeded"), true)
+			probeRequest.DegradedIfExpr = expr
+		case 16:
 			return
 		}
 	}
 }
 
+func askDuration(question string, defa time.Duration) time.Duration {
+	for {
+		answer := interact.Ask(question, defa.String(), false)
+		duration, err := time.ParseDuration(answer)
+		if err != nil || duration < 0 {
+			fmt.Printf("%s is not a valid duration, eg. 500ms, 5s or 1m\n", answer)
+			continue
+		}
+		return duration
+	}
+}
+
+func configureRetry(probeRequest *internal.ProbeRequest) {
+	probeRequest.Timeout = askDuration("Timeout of each attempt? (0s to use the probe timeout)", probeRequest.Timeout)
+	probeRequest.Retries = interact.AskNumber("How many times to retry a failed attempt ?", 0, 10, probeRequest.Retries, false)
+	if probeRequest.Retries == 0 {
+		return
+	}
+	retryOn := interact.Ask("Retry on? (comma separated error classes connection,timeout,dns,tls,error or status codes like 503,5xx)",
+		strings.Join(stringsDefault(probeRequest.RetryOn, internal.DefaultRetryOn), ","), false)
+	probeRequest.RetryOn = make([]string, 0)
+	for _, on := range strings.Split(retryOn, ",") {
+		if on = strings.TrimSpace(on); len(on) > 0 {
+			probeRequest.RetryOn = append(probeRequest.RetryOn, on)
+		}
+	}
+	probeRequest.RetryBackoff = askDuration("Delay before the first retry?", durationDefault(probeRequest.RetryBackoff, internal.DefaultRetryBackoff))
+	probeRequest.RetryMaxBackoff = askDuration("Maximum delay between retries?", durationDefault(probeRequest.RetryMaxBackoff, internal.DefaultRetryMaxBackoff))
+}
+
 func manageRequestHeaders(pr *internal.ProbeRequest, path, method string) {
 	for {
 		fmt.Printf("\n---[ REQUEST HEADERS ]--(%s)-(%s)------------------\n", path, method)
@@ -595,6 +739,73 @@ func manageRequestHeaders(pr *internal.ProbeRequest, path, method string) {
 	}
 }
 
+func configureResponseSchema(probeRequest *internal.ProbeRequest) {
+	for {
+		schema := interact.Ask("JSON Schema file path or inline schema ? (empty to remove)", probeRequest.ResponseSchema, false)
+		if len(strings.TrimSpace(schema)) == 0 {
+			probeRequest.ResponseSchema = ""
+			return
+		}
+		if _, err := probing.LoadSchema(schema); err != nil {
+			fmt.Printf("Invalid schema. got %s\n", err.Error())
+			continue
+		}
+		probeRequest.ResponseSchema = schema
+		return
+	}
+}
+
+func manageRequestExtract(probeName string, pr *internal.ProbeRequest) {
+	if pr.Extract == nil {
+		pr.Extract = make(map[string]string)
+	}
+	for {
+		fmt.Printf("\n---[ EXTRACTED VARIABLES ]--(%s)------------------\n", pr.Name)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"VARIABLE", "EXPRESSION"})
+
+		for _, name := range pr.ExtractNames() {
+			table.Append([]string{fmt.Sprintf("vars.%s", name), pr.Extract[name]})
+		}
+
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.Render()
+
+		selected := interact.Select("What to do ?", []string{
+			"Add/Modify Variable",
+			"Remove Variable",
+			"Finish",
+		}, 1, 3, false)
+		switch selected {
+		case 1:
+			options := append(pr.ExtractNames(), "New Variable", "Cancel")
+			selected := interact.Select("Choose variable to modify or select New Variable or Cancel", options, 0, len(options)-1, false)
+			switch selected {
+			case len(options) - 1:
+				// do nothing
+			case len(options) - 2:
+				name := askNoSpaceNotEmpty("Variable Name ?", "Variable Name", "token", true)
+				if !internal.IsValidVariableName(name) {
+					fmt.Println("Variable name must start with a letter or underscore, followed by letters, digits or underscores")
+					continue
+				}
+				pr.Extract[name] = interact.Ask("Variable Expression ?", fmt.Sprintf("GetJsonStringValue(probe.%s.req.%s.resp.body, \"data.token\")", probeName, pr.Name), true)
+			default:
+				name := options[selected]
+				pr.Extract[name] = interact.Ask("Variable Expression ?", pr.Extract[name], true)
+			}
+		case 2:
+			options := append(pr.ExtractNames(), "Cancel")
+			selected := interact.Select("Choose variable to remove or Cancel", options, 0, len(options)-1, false)
+			if selected != len(options)-1 {
+				delete(pr.Extract, options[selected])
+			}
+		case 3:
+			return
+		}
+	}
+}
+
 func configureSNMPNotification(probe *internal.Probe) {
 	smtpConfig := probe.SMTPNotification
 	if smtpConfig == nil {
@@ -626,7 +837,7 @@ func configureSNMPNotification(probe *internal.Probe) {
 			table.Append([]string{"From", "Not Set"})
 		}
 		if len(smtpConfig.Password) > 0 {
-			table.Append([]string{"Password", smtpConfig.Password})
+			table.Append([]string{"Password", internal.Mask})
 		} else {
 			table.Append([]string{"Password", "Not Set"})
 		}
@@ -771,6 +982,16 @@ func configureCallbackNotification(p *internal.Probe) {
 		} else {
 			table.Append([]string{"DOWN Callback URL", "not specified"})
 		}
+		if len(p.CallbackNotification.DegradedCall) > 0 {
+			table.Append([]string{"DEGRADED Callback URL", p.CallbackNotification.DegradedCall})
+		} else {
+			table.Append([]string{"DEGRADED Callback URL", "not specified"})
+		}
+		if len(p.CallbackNotification.FlappingCall) > 0 {
+			table.Append([]string{"FLAPPING Callback URL", p.CallbackNotification.FlappingCall})
+		} else {
+			table.Append([]string{"FLAPPING Callback URL", "not specified"})
+		}
 
 		table.SetAlignment(tablewriter.ALIGN_LEFT)
 		table.Render()
@@ -778,20 +999,337 @@ func configureCallbackNotification(p *internal.Probe) {
 		options := []string{
 			"Set UP Callback URL",
 			"Set DOWN Callback URL",
+			"Set DEGRADED Callback URL",
+			"Set FLAPPING Callback URL",
 			"Finish",
 		}
 
-		switch interact.Select("What to do ?", options, 1, 3, false) {
+		switch interact.Select("What to do ?", options, 1, 5, false) {
 		case 1:
 			p.CallbackNotification.UpCall = interact.Ask("UP Callback URL?", stringDefault(p.CallbackNotification.UpCall, "http://localhost"), true)
 		case 2:
 			p.CallbackNotification.DownCall = interact.Ask("DOWN Callback URL?", stringDefault(p.CallbackNotification.DownCall, "http://localhost"), true)
 		case 3:
+			p.CallbackNotification.DegradedCall = interact.Ask("DEGRADED Callback URL?", stringDefault(p.CallbackNotification.DegradedCall, "http://localhost"), true)
+		case 4:
+			p.CallbackNotification.FlappingCall = interact.Ask("FLAPPING Callback URL?", stringDefault(p.CallbackNotification.FlappingCall, "http://localhost"), true)
+		case 5:
 			return
 		}
 	}
 }
 
+func configureProbeTLS(p *internal.Probe) {
+	if p.TLS == nil {
+		p.TLS = &internal.ProbeTLSConfig{}
+	}
+	for {
+		fmt.Printf("\n---[ PROBE TLS CONFIGURATION ]-----------------------\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ITEM", "VALUE"})
+		table.Append([]string{"Client Certificate File", stringDefault(p.TLS.ClientCertFile, "not specified")})
+		table.Append([]string{"Client Key File", stringDefault(p.TLS.ClientKeyFile, "not specified")})
+		table.Append([]string{"PKCS#12 File", stringDefault(p.TLS.PKCS12File, "not specified")})
+		table.Append([]string{"Root CA File", stringDefault(p.TLS.RootCAFile, "system roots")})
+		table.Append([]string{"Server Name (SNI)", stringDefault(p.TLS.ServerName, "from URL")})
+		table.Append([]string{"Min Version", stringDefault(p.TLS.MinVersion, "default")})
+		table.Append([]string{"Max Version", stringDefault(p.TLS.MaxVersion, "default")})
+		table.Append([]string{"Skip Verification", fmt.Sprintf("%v", p.TLS.InsecureSkipVerify)})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.Render()
+
+		options := []string{
+			"Set Client Certificate and Key PEM Files",
+			"Set Client PKCS#12 File",
+			"Set Root CA File",
+			"Set Server Name",
+			"Set TLS Versions",
+			"Toggle Skip Verification",
+			"Remove TLS Configuration",
+			"Finish",
+		}
+
+		switch interact.Select("What to do ?", options, 1, 8, false) {
+		case 1:
+			p.TLS.ClientCertFile = interact.Ask("Client certificate PEM file?", p.TLS.ClientCertFile, false)
+			p.TLS.ClientKeyFile = interact.Ask("Client private key PEM file?", p.TLS.ClientKeyFile, false)
+		case 2:
+			p.TLS.PKCS12File = interact.Ask("Client PKCS#12 file?", p.TLS.PKCS12File, false)
+			p.TLS.PKCS12Password = interact.Ask("PKCS#12 password?", p.TLS.PKCS12Password, false)
+		case 3:
+			p.TLS.RootCAFile = interact.Ask("Root CA PEM bundle file?", p.TLS.RootCAFile, false)
+		case 4:
+			p.TLS.ServerName = interact.Ask("Server name for SNI and verification?", p.TLS.ServerName, false)
+		case 5:
+			for {
+				p.TLS.MinVersion = interact.Ask("Minimum TLS version? (1.0, 1.1, 1.2, 1.3)", p.TLS.MinVersion, false)
+				p.TLS.MaxVersion = interact.Ask("Maximum TLS version? (1.0, 1.1, 1.2, 1.3)", p.TLS.MaxVersion, false)
+				if _, err := probing.NewTLSConfig(&internal.ProbeTLSConfig{MinVersion: p.TLS.MinVersion, MaxVersion: p.TLS.MaxVersion}, false); err != nil {
+					fmt.Println(err.Error())
+					continue
+				}
+				break
+			}
+		case 6:
+			p.TLS.InsecureSkipVerify = !p.TLS.InsecureSkipVerify
+		case 7:
+			if interact.Confirm("Remove TLS configuration and use the default ?", false) {
+				p.TLS = nil
+				return
+			}
+		case 8:
+			return
+		}
+	}
+}
+
+func manageMaintenance(owner string, windows []*internal.MaintenanceWindow, global bool) []*internal.MaintenanceWindow {
+	for {
+		fmt.Printf("\n---[ %s MAINTENANCE WINDOWS ]-----------------------\n", owner)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"NO", "ID", "WHEN", "PROBES", "DESCRIPTION"})
+		for idx, window := range windows {
+			when := fmt.Sprintf("%s to %s", window.Start.Format(time.RFC3339), window.End.Format(time.RFC3339))
+			if len(window.Cron) > 0 {
+				when = fmt.Sprintf("%s for %s", window.Cron, window.Duration)
+			}
+			table.Append([]string{fmt.Sprintf("%d", idx), window.ID, when, strings.Join(window.Probes, ","), window.Description})
+		}
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.Render()
+
+		selected := interact.Select("What to do ?", []string{"Add Recurring Window", "Add One-off Window", "Remove Window", "Finish"}, 1, 4, false)
+		switch selected {
+		case 1, 2:
+			window := &internal.MaintenanceWindow{ID: uuid.New().String()}
+			window.Description = interact.Ask("Window description?", "Planned deploy", false)
+			if selected == 1 {
+				window.Cron = interact.Ask("Window starts on cron?", "0 0 2 * 0 * *", true)
+				window.Duration = askDuration("How long does the window last?", time.Hour)
+			} else {
+				window.Start = askTime("Window start? (RFC3339)", time.Now())
+				window.End = askTime("Window end? (RFC3339)", window.Start.Add(time.Hour))
+			}
+			if global {
+				probes := interact.Ask("Probe names or IDs the window applies to? (comma separated, empty for every probe)", "", false)
+				for _, p := range strings.Split(probes, ",") {
+					if p = strings.TrimSpace(p); len(p) > 0 {
+						window.Probes = append(window.Probes, p)
+					}
+				}
+			}
+			if err := probing.CheckMaintenanceWindow(window); err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
+			windows = append(windows, window)
+		case 3:
+			if len(windows) == 0 {
+				continue
+			}
+			idx := interact.AskNumber("Window number to remove?", 0, len(windows)-1, 0, false)
+			windows = append(windows[:idx], windows[idx+1:]...)
+		case 4:
+			return windows
+		}
+	}
+}
+
+func configureEscalation(policy *internal.EscalationPolicy) *internal.EscalationPolicy {
+	if policy == nil {
+		policy = &internal.EscalationPolicy{}
+	}
+	for {
+		fmt.Printf("\n---[ ESCALATION POLICY ]-----------------------\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ITEM", "VALUE"})
+		table.Append([]string{"Re-notify Every", policy.RenotifyEvery.String()})
+		table.Append([]string{"Escalate After", policy.EscalateAfter.String()})
+		escalateTo := make([]string, 0, len(policy.EscalateTo))
+		for _, mb := range policy.EscalateTo {
+			escalateTo = append(escalateTo, mb.String())
+		}
+		table.Append([]string{"Escalate To", strings.Join(escalateTo, ",")})
+		table.Append([]string{"Escalate Callback URL", stringDefault(policy.EscalateCall, "not specified")})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.Render()
+
+		switch interact.Select("What to do ?", []string{
+			"Set Re-notify Interval", "Set Escalation Delay", "Set Escalation Recipients", "Set Escalation Callback URL", "Remove Escalation", "Finish",
+		}, 1, 6, false) {
+		case 1:
+			policy.RenotifyEvery = askDuration("Re-notify every? (0s to notify once)", durationDefault(policy.RenotifyEvery, 30*time.Minute))
+		case 2:
+			policy.EscalateAfter = askDuration("Escalate after the probe is down for? (0s to never escalate)", durationDefault(policy.EscalateAfter, time.Hour))
+		case 3:
+			recipients := interact.Ask("Escalation recipients? (comma separated, 'mailbox@domain' or 'Display<mailbox@domain>')", strings.Join(escalateTo, ","), false)
+			mailboxes := make([]*internal.Mailbox, 0)
+			for _, recipient := range strings.Split(recipients, ",") {
+				if recipient = strings.TrimSpace(recipient); len(recipient) == 0 {
+					continue
+				}
+				mb, err := internal.NewMailbox(recipient)
+				if err != nil {
+					fmt.Printf("Invalid mail box %s, skipped\n", recipient)
+					continue
+				}
+				mailboxes = append(mailboxes, mb)
+			}
+			policy.EscalateTo = mailboxes
+		case 4:
+			policy.EscalateCall = interact.Ask("Escalation Callback URL?", stringDefault(policy.EscalateCall, "http://localhost/escalate"), false)
+		case 5:
+			return nil
+		case 6:
+			if policy.RenotifyEvery == 0 && policy.EscalateAfter == 0 {
+				return nil
+			}
+			return policy
+		}
+	}
+}
+
+func askTime(question string, defa time.Time) time.Time {
+	for {
+		answer := interact.Ask(question, defa.Format(time.RFC3339), false)
+		t, err := time.Parse(time.RFC3339, answer)
+		if err != nil {
+			fmt.Printf("%s is not a valid time, eg. %s\n", answer, time.Now().Format(time.RFC3339))
+			continue
+		}
+		return t
+	}
+}
+
+func configureProxy(proxy *internal.ProxyConfig) *internal.ProxyConfig {
+	if proxy == nil {
+		proxy = &internal.ProxyConfig{}
+	}
+	for {
+		fmt.Printf("\n---[ PROXY CONFIGURATION ]-----------------------\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ITEM", "VALUE"})
+		table.Append([]string{"Proxy URL", stringDefault(proxy.String(), "not specified")})
+		table.Append([]string{"Username", stringDefault(proxy.Username, "not specified")})
+		table.Append([]string{"No Proxy", strings.Join(proxy.NoProxy, ",")})
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.Render()
+
+		switch interact.Select("What to do ?", []string{
+			"Set Proxy URL", "Set Credentials", "Set No Proxy List", "Remove Proxy", "Finish",
+		}, 1, 5, false) {
+		case 1:
+			for {
+				proxy.URL = interact.Ask("Proxy URL? (http://host:port or socks5://host:port)", stringDefault(proxy.URL, "http://localhost:3128"), true)
+				if _, err := proxy.ProxyURL(); err != nil {
+					fmt.Println(err.Error())
+					continue
+				}
+				break
+			}
+		case 2:
+			proxy.Username = interact.Ask("Proxy username?", proxy.Username, false)
+			proxy.Password = interact.Ask("Proxy password?", proxy.Password, false)
+		case 3:
+			noProxy := interact.Ask("Hosts to call directly? (comma separated)", strings.Join(proxy.NoProxy, ","), false)
+			proxy.NoProxy = make([]string, 0)
+			for _, host := range strings.Split(noProxy, ",") {
+				if host = strings.TrimSpace(host); len(host) > 0 {
+					proxy.NoProxy = append(proxy.NoProxy, host)
+				}
+			}
+		case 4:
+			return nil
+		case 5:
+			if len(proxy.URL) == 0 {
+				return nil
+			}
+			return proxy
+		}
+	}
+}
+
+func configureAuth(auth *internal.AuthConfig, forRequest bool) *internal.AuthConfig {
+	if auth == nil {
+		auth = &internal.AuthConfig{}
+	}
+	for {
+		fmt.Printf("\n---[ AUTHENTICATION CONFIGURATION ]-----------------------\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ITEM", "VALUE"})
+		table.Append([]string{"Type", stringDefault(auth.Type, "not specified")})
+		switch auth.Type {
+		case internal.AuthBasic:
+			table.Append([]string{"Username", auth.Username})
+		case internal.AuthBearer:
+			table.Append([]string{"Token Expression", auth.TokenExpr})
+		case internal.AuthOAuth2:
+			table.Append([]string{"Token URL", auth.TokenURL})
+			table.Append([]string{"Client ID", auth.ClientID})
+			table.Append([]string{"Grant", auth.GrantType()})
+			table.Append([]string{"Scopes", strings.Join(auth.Scopes, " ")})
+		case internal.AuthHMAC:
+			header, prefix := auth.SignatureHeader()
+			table.Append([]string{"Key ID", auth.KeyID})
+			table.Append([]string{"Algorithm", stringDefault(auth.Algorithm, "sha256")})
+			table.Append([]string{"Canonical Expression", stringDefault(auth.CanonicalExpr, "method, request uri, date and body hash")})
+			table.Append([]string{"Header", fmt.Sprintf("%s: %s", header, prefix)})
+		}
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.Render()
+
+		switch interact.Select("What to do ?", []string{
+			"Use Basic Auth", "Use Bearer Token", "Use OAuth2", "Use HMAC Signing", "Disable Authentication", "Remove Authentication", "Finish",
+		}, 1, 7, false) {
+		case 1:
+			auth = &internal.AuthConfig{Type: internal.AuthBasic}
+			auth.Username = interact.Ask("Username?", "", true)
+			auth.Password = interact.Ask("Password? (can be ${env:NAME} or ${file:/path})", "", false)
+		case 2:
+			auth = &internal.AuthConfig{Type: internal.AuthBearer}
+			auth.TokenExpr = interact.Ask("Token Expression? \n Must return a string", "vars.token", true)
+		case 3:
+			auth = &internal.AuthConfig{Type: internal.AuthOAuth2}
+			for {
+				auth.TokenURL = interact.Ask("Token URL?", "https://auth.example.com/oauth/token", true)
+				if _, err := url.ParseRequestURI(auth.TokenURL); err != nil {
+					fmt.Printf("Invalid URL. got %s\n", err.Error())
+					continue
+				}
+				break
+			}
+			auth.ClientID = interact.Ask("Client ID?", "", true)
+			auth.ClientSecret = interact.Ask("Client Secret? (can be ${env:NAME} or ${file:/path})", "", false)
+			if interact.Select("Grant ?", []string{internal.GrantClientCredentials, internal.GrantPassword}, 1, 1, false) == 2 {
+				auth.Grant = internal.GrantPassword
+				auth.Username = interact.Ask("Username?", "", true)
+				auth.Password = interact.Ask("Password? (can be ${env:NAME} or ${file:/path})", "", false)
+			}
+			auth.Scopes = strings.Fields(interact.Ask("Scopes? (space separated)", "", false))
+		case 4:
+			auth = &internal.AuthConfig{Type: internal.AuthHMAC}
+			auth.KeyID = interact.Ask("Key ID?", "", false)
+			auth.Secret = interact.Ask("Secret? (can be ${env:NAME} or ${file:/path})", "", true)
+			auth.Algorithm = []string{"sha256", "sha1", "sha512"}[interact.Select("Algorithm ?", []string{"sha256", "sha1", "sha512"}, 1, 1, false)-1]
+			auth.CanonicalExpr = interact.Ask("Canonical String Expression? (empty for method, request uri, date and body hash)", "", false)
+			auth.Header = interact.Ask("Signature Header?", "Authorization", false)
+			auth.Prefix = interact.Ask("Signature Prefix?", "HMAC", false)
+		case 5:
+			if !forRequest {
+				return nil
+			}
+			return &internal.AuthConfig{Type: internal.AuthNone}
+		case 6:
+			return nil
+		case 7:
+			if len(auth.Type) == 0 {
+				return nil
+			}
+			return auth
+		}
+	}
+}
+
 func configureCentral(config *internal.MIHPConfig) (err error) {
 	central := config.Central
 	if central == nil {
@@ -840,7 +1378,7 @@ func configureCentral(config *internal.MIHPConfig) (err error) {
 		if len(central.AdminPassword) == 0 {
 			table.Append([]string{"Admin Password", "Not Configured"})
 		} else {
-			table.Append([]string{"Admin Password", central.AdminPassword})
+			table.Append([]string{"Admin Password", internal.Mask})
 		}
 		if len(central.JWTIssuer) == 0 {
 			table.Append([]string{"JWT Issuer", "Not Configured"})
@@ -970,6 +1508,20 @@ func intDefault(tocheck, alternative int) int {
 	return tocheck
 }
 
+func durationDefault(tocheck, alternative time.Duration) time.Duration {
+	if tocheck == 0 {
+		return alternative
+	}
+	return tocheck
+}
+
+func stringsDefault(tocheck, alternative []string) []string {
+	if len(tocheck) == 0 {
+		return alternative
+	}
+	return tocheck
+}
+
 func configureDatabase(dbName string, cfg *internal.DBConfig) *internal.DBConfig {
 	if cfg == nil {
 		cfg = &internal.DBConfig{}
@@ -998,7 +1550,7 @@ func configureDatabase(dbName string, cfg *internal.DBConfig) *internal.DBConfig
 		if len(cfg.Password) == 0 {
 			table.Append([]string{"DB Password", "Not Configured"})
 		} else {
-			table.Append([]string{"DB Password", cfg.Password})
+			table.Append([]string{"DB Password", internal.Mask})
 		}
 		if len(cfg.Database) == 0 {
 			table.Append([]string{"DB schema", "Not Configured"})
@@ -1093,6 +1645,16 @@ func configureMinion(config *internal.MIHPConfig) (err error) {
 		} else {
 			table.Append([]string{"Network Mask", minion.MinionNetwork})
 		}
+		if minion.Proxy == nil {
+			table.Append([]string{"Proxy", "Not Configured"})
+		} else {
+			table.Append([]string{"Proxy", minion.Proxy.String()})
+		}
+		if len(minion.StateDir) == 0 {
+			table.Append([]string{"State Directory", "Not Configured, state is lost on restart"})
+		} else {
+			table.Append([]string{"State Directory", minion.StateDir})
+		}
 
 		table.SetAlignment(tablewriter.ALIGN_LEFT)
 		table.Render()
@@ -1100,8 +1662,8 @@ func configureMinion(config *internal.MIHPConfig) (err error) {
 		switch interact.Select("What to do ?", []string{
 			"Set Name", "Set UID", "Set Country", "Set Datacenter",
 			"Set Central Base URL", "Set Reporting Cron", "Set Bind IP", "Set Network Mask",
-			"Finish",
-		}, 1, 9, false) {
+			"Configure Proxy", "Set State Directory", "Finish",
+		}, 1, 11, false) {
 		case 1:
 			minion.Name = interact.Ask("New Name ?", stringDefault(minion.Name, helper.RandomName()), true)
 		case 2:
@@ -1167,6 +1729,10 @@ func configureMinion(config *internal.MIHPConfig) (err error) {
 				break
 			}
 		case 9:
+			minion.Proxy = configureProxy(minion.Proxy)
+		case 10:
+			minion.StateDir = interact.Ask("Directory to keep the probe state across restarts ?", stringDefault(minion.StateDir, "./state"), true)
+		case 11:
 			if config.Minion == nil {
 				config.Minion = minion
 			}
@@ -1199,7 +1765,7 @@ func saveAndExist(config *internal.MIHPConfig, configFile string) (err error) {
 		}
 	}
 	defer file.Close()
-	yamlBytes, err := yaml.Marshal(config)
+	yamlBytes, err := internal.MIHPConfigToYAML(config)
 	defer func() {
 		fmt.Printf("In case you dont get it. YAML as follow :\n--BEGIN YAML--\n%s\n--END YAML--\n", string(yamlBytes))
 	}()
@@ -4,13 +4,20 @@ go 1.17
 
 require (
 	github.com/SermoDigital/jose v0.0.0-20180104203859-803625baeddc
+	github.com/andybalholm/brotli v1.0.4
+	github.com/andybalholm/cascadia v1.3.1
+	github.com/antchfx/xmlquery v1.3.9
+	github.com/antchfx/xpath v1.2.4
 	github.com/google/cel-go v0.9.0
 	github.com/google/uuid v1.1.2
 	github.com/hyperjumptech/hyper-interactive v1.0.2
 	github.com/hyperjumptech/hyper-mux v1.1.0
 	github.com/olekukonko/tablewriter v0.0.5
+	github.com/santhosh-tekuri/jsonschema/v5 v5.0.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/net v0.0.0-20210916014120-12bc252f5db8
 	google.golang.org/genproto v0.0.0-20211021150943-2b146023228c
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -23,6 +30,7 @@ require (
 require (
 	github.com/antlr/antlr4/runtime/Go/antlr v0.0.0-20210826220005-b48c857c3a0e // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stoewer/go-strcase v1.2.0 // indirect
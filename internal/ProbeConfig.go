@@ -1,15 +1,42 @@
 package internal
 
 import (
+	"encoding/json"
 	"fmt"
-	yaml "gopkg.in/yaml.v3"
+	"github.com/newm4n/mihp/pkg/errors"
 	"net"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
 	Version = "1.0.0"
+
+	DefaultMaxRedirects = 10
+
+	DefaultCaptureMaxSize = 1024 * 1024
+
+	DefaultRetryBackoff    = 500 * time.Millisecond
+	DefaultRetryMaxBackoff = 10 * time.Second
+)
+
+var (
+	DefaultCaptureMediaTypes = []string{
+		"text/*",
+		"application/json",
+		"application/*+json",
+		"application/xml",
+		"application/*+xml",
+		"application/javascript",
+		"application/x-www-form-urlencoded",
+	}
+
+	DefaultRetryOn = []string{"connection", "timeout"}
+
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 )
 
 type MIHPConfig struct {
@@ -17,11 +44,13 @@ type MIHPConfig struct {
 	ProbePool ProbePool      `yaml:"probe_pool"`
 	Central   *CentralConfig `yaml:"central"`
 	Minion    *MinionConfig  `yaml:"minion"`
+	// Maintenance windows apply to every probe, or to the probes they list.
+	Maintenance []*MaintenanceWindow `yaml:"maintenance,omitempty"`
 }
 
 func YAMLToMIHPConfig(yamlBytes []byte) (probePool *MIHPConfig, err error) {
 	config := &MIHPConfig{}
-	err = yaml.Unmarshal(yamlBytes, config)
+	err = unmarshalYAML(yamlBytes, config)
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +58,7 @@ func YAMLToMIHPConfig(yamlBytes []byte) (probePool *MIHPConfig, err error) {
 }
 
 func MIHPConfigToYAML(config *MIHPConfig) (yamlBytes []byte, err error) {
-	return yaml.Marshal(config)
+	return marshalYAML(config)
 }
 
 type CentralConfig struct {
@@ -69,6 +98,80 @@ type MinionConfig struct {
 	CountryISO     string `json:"country_iso" yaml:"country_iso"`
 	Datacenter     string `json:"datacenter" yaml:"datacenter"`
 	MinionUID      string `json:"minion_uid" yaml:"minion_uid"`
+	// Proxy is used by every probe this minion runs, unless the probe specify its own.
+	Proxy *ProxyConfig `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	// StateDir keeps the probe event tracker state across restarts. Empty keeps it in memory only.
+	StateDir string `json:"state_dir,omitempty" yaml:"state_dir,omitempty"`
+}
+
+// ProxyConfig specifies the proxy to route probe traffic through.
+type ProxyConfig struct {
+	// URL of the proxy, scheme can be http, https (HTTP CONNECT proxy) or socks5
+	URL      string `json:"url" yaml:"url"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	// NoProxy list hosts that are called directly. Entry can be a host name, a domain suffix (".example.com"
+	// or "*.example.com"), an IP address, a CIDR (10.0.0.0/8) or "*" to bypass the proxy entirely.
+	NoProxy []string `json:"no_proxy,omitempty" yaml:"no_proxy,omitempty"`
+}
+
+// ProxyURL parse the proxy URL and attach the credentials.
+func (pc *ProxyConfig) ProxyURL() (*url.URL, error) {
+	proxyURL, err := url.Parse(pc.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %s. got %w", pc.URL, err)
+	}
+	switch strings.ToLower(proxyURL.Scheme) {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy url %s. scheme must be http, https or socks5", pc.URL)
+	}
+	if len(proxyURL.Host) == 0 {
+		return nil, fmt.Errorf("invalid proxy url %s. missing host", pc.URL)
+	}
+	if len(pc.Username) > 0 {
+		proxyURL.User = url.UserPassword(pc.Username, pc.Password)
+	}
+	return proxyURL, nil
+}
+
+// String returns the proxy URL without its credentials.
+func (pc *ProxyConfig) String() string {
+	proxyURL, err := url.Parse(pc.URL)
+	if err != nil {
+		return pc.URL
+	}
+	proxyURL.User = nil
+	return proxyURL.String()
+}
+
+// Bypass checks whether the host (with or without port) is listed in NoProxy and should be called directly.
+func (pc *ProxyConfig) Bypass(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for _, entry := range pc.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if len(entry) == 0 {
+			continue
+		}
+		if entry == "*" || entry == host {
+			return true
+		}
+		if ip != nil {
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		suffix := strings.TrimPrefix(entry, "*")
+		if strings.HasPrefix(suffix, ".") && (strings.HasSuffix(host, suffix) || host == suffix[1:]) {
+			return true
+		}
+	}
+	return false
 }
 
 type ProbePool []*Probe
@@ -81,8 +184,112 @@ type Probe struct {
 	Cron                 string                      `json:"cron" yaml:"cron"`
 	UpThreshold          int                         `json:"up_threshold" yaml:"up_threshold"`
 	DownThreshold        int                         `json:"down_threshold" yaml:"down_threshold"`
+	FreshConnection      bool                        `json:"fresh_connection" yaml:"fresh_connection"`
+	Deadline             time.Duration               `json:"deadline,omitempty" yaml:"deadline,omitempty"`
+	TLS                  *ProbeTLSConfig             `json:"tls,omitempty" yaml:"tls,omitempty"`
 	SMTPNotification     *SMTPNotificationTarget     `json:"smtp_notification" yaml:"SMTP_notification"`
 	CallbackNotification *CallbackNotificationTarget `json:"callback_notification" yaml:"callback_notification"`
+	Proxy                *ProxyConfig                `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	// Auth authenticates every request of the probe, unless the request specify its own.
+	Auth *AuthConfig `json:"auth,omitempty" yaml:"auth,omitempty"`
+	// LatencyBudget is the longest the whole request chain may take before the probe is DEGRADED. Zero means no budget.
+	LatencyBudget time.Duration `json:"latency_budget,omitempty" yaml:"latency_budget,omitempty"`
+	// FlapWindow is how many of the latest results are checked for flapping, FlapThreshold is how many status changes
+	// among them make the probe FLAPPING. Zero uses the defaults.
+	FlapWindow    int `json:"flap_window,omitempty" yaml:"flap_window,omitempty"`
+	FlapThreshold int `json:"flap_threshold,omitempty" yaml:"flap_threshold,omitempty"`
+	// Maintenance windows of this probe, in addition to the global ones.
+	Maintenance []*MaintenanceWindow `json:"maintenance,omitempty" yaml:"maintenance,omitempty"`
+	// Escalation keeps notifying while the probe stays DOWN. Nil notifies once per status change.
+	Escalation *EscalationPolicy `json:"escalation,omitempty" yaml:"escalation,omitempty"`
+}
+
+// EscalationPolicy re-notifies a DOWN probe until it recovers or the outage is acknowledged.
+type EscalationPolicy struct {
+	// RenotifyEvery repeats the DOWN notification at this interval. Zero never repeats.
+	RenotifyEvery time.Duration `json:"renotify_every,omitempty" yaml:"renotify_every,omitempty"`
+	// EscalateAfter is how long the probe stays DOWN before EscalateTo and EscalateCall are notified too. Zero never escalates.
+	EscalateAfter time.Duration `json:"escalate_after,omitempty" yaml:"escalate_after,omitempty"`
+	EscalateTo    []*Mailbox    `json:"escalate_to,omitempty" yaml:"escalate_to,omitempty"`
+	EscalateCall  string        `json:"escalate_call,omitempty" yaml:"escalate_call,omitempty"`
+}
+
+// MaintenanceWindow is a period where probe failures do not notify nor count as downtime. The window either
+// recurs, starting whenever Cron matches and lasting Duration, or runs once from Start to End. A recurring
+// window may still be bounded by Start and End.
+type MaintenanceWindow struct {
+	ID          string        `json:"id" yaml:"id"`
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Cron        string        `json:"cron,omitempty" yaml:"cron,omitempty"`
+	Duration    time.Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Start       time.Time     `json:"start,omitempty" yaml:"start,omitempty"`
+	End         time.Time     `json:"end,omitempty" yaml:"end,omitempty"`
+	// Probes are the names or IDs of the probes a global window applies to, empty applies to every probe.
+	Probes []string `json:"probes,omitempty" yaml:"probes,omitempty"`
+}
+
+// maintenanceWindowAlias has the fields of MaintenanceWindow without its json methods.
+type maintenanceWindowAlias MaintenanceWindow
+
+// MarshalJSON writes the window with its duration as a duration string such as "1h30m0s".
+func (window *MaintenanceWindow) MarshalJSON() ([]byte, error) {
+	duration := ""
+	if window.Duration != 0 {
+		duration = window.Duration.String()
+	}
+	return json.Marshal(&struct {
+		*maintenanceWindowAlias
+		Duration string `json:"duration,omitempty"`
+	}{maintenanceWindowAlias: (*maintenanceWindowAlias)(window), Duration: duration})
+}
+
+// UnmarshalJSON reads the window with its duration either as a duration string such as "1h", or as nanoseconds.
+func (window *MaintenanceWindow) UnmarshalJSON(data []byte) error {
+	content := &struct {
+		*maintenanceWindowAlias
+		Duration json.RawMessage `json:"duration,omitempty"`
+	}{maintenanceWindowAlias: (*maintenanceWindowAlias)(window)}
+	if err := json.Unmarshal(data, content); err != nil {
+		return err
+	}
+	window.Duration = 0
+	if len(content.Duration) == 0 || string(content.Duration) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(content.Duration, &text); err == nil {
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%w : duration %s, got %s", errors.ErrInvalidMaintenance, text, err.Error())
+		}
+		window.Duration = duration
+		return nil
+	}
+	var nanos int64
+	if err := json.Unmarshal(content.Duration, &nanos); err != nil {
+		return fmt.Errorf("%w : duration %s is neither a duration string nor nanoseconds", errors.ErrInvalidMaintenance, string(content.Duration))
+	}
+	window.Duration = time.Duration(nanos)
+	return nil
+}
+
+// ProbeTLSConfig configures the TLS client used by a probe. Server certificate is always verified
+// unless InsecureSkipVerify is set.
+type ProbeTLSConfig struct {
+	// ClientCertFile and ClientKeyFile are PEM encoded client certificate and private key for mutual TLS
+	ClientCertFile string `json:"client_cert_file,omitempty" yaml:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty" yaml:"client_key_file,omitempty"`
+	// PKCS12File is a PKCS#12 bundle containing the client certificate and private key, used instead of the PEM files.
+	PKCS12File     string `json:"pkcs12_file,omitempty" yaml:"pkcs12_file,omitempty"`
+	PKCS12Password string `json:"pkcs12_password,omitempty" yaml:"pkcs12_password,omitempty"`
+	// RootCAFile is a PEM bundle of CA certificates trusted instead of the system roots.
+	RootCAFile string `json:"root_ca_file,omitempty" yaml:"root_ca_file,omitempty"`
+	// ServerName overrides the SNI server name and the name verified against the server certificate.
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	// MinVersion and MaxVersion are TLS version such as "1.2" or "1.3"
+	MinVersion         string `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	MaxVersion         string `json:"max_version,omitempty" yaml:"max_version,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
 }
 
 type SMTPNotificationTarget struct {
@@ -96,8 +303,10 @@ type SMTPNotificationTarget struct {
 }
 
 type CallbackNotificationTarget struct {
-	UpCall   string `yaml:"up_call"`
-	DownCall string `yaml:"down_call"`
+	UpCall       string `yaml:"up_call"`
+	DownCall     string `yaml:"down_call"`
+	DegradedCall string `yaml:"degraded_call,omitempty"`
+	FlappingCall string `yaml:"flapping_call,omitempty"`
 }
 
 type Mailbox struct {
@@ -165,11 +374,175 @@ type ProbeRequest struct {
 	StartRequestIfExpr   string              `json:"start_request_if_expr" yaml:"start_request_if_expr"`
 	SuccessIfExpr        string              `json:"success_if_expr" yaml:"success_if_expr"`
 	FailIfExpr           string              `json:"fail_if_expr" yaml:"fail_if_expr"`
+	FollowRedirects      *bool               `json:"follow_redirects,omitempty" yaml:"follow_redirects,omitempty"`
+	MaxRedirects         int                 `json:"max_redirects,omitempty" yaml:"max_redirects,omitempty"`
+	BodyCapture          *BodyCapture        `json:"body_capture,omitempty" yaml:"body_capture,omitempty"`
+	// Timeout of a single attempt of this request, including reading the body. Zero means the probe's timeout.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Retries is the number of additional attempts made when an attempt fails with one of RetryOn
+	Retries int `json:"retries,omitempty" yaml:"retries,omitempty"`
+	// RetryOn list the failure classes to retry on. Either "error" (any of the following), "connection", "timeout",
+	// "dns", "tls", a status code such as "503" or a status class such as "5xx". Default to DefaultRetryOn.
+	RetryOn []string `json:"retry_on,omitempty" yaml:"retry_on,omitempty"`
+	// RetryBackoff is the delay before the first retry, doubled on each following retry up to RetryMaxBackoff.
+	RetryBackoff    time.Duration `json:"retry_backoff,omitempty" yaml:"retry_backoff,omitempty"`
+	RetryMaxBackoff time.Duration `json:"retry_max_backoff,omitempty" yaml:"retry_max_backoff,omitempty"`
+	// Extract maps variable names to expressions evaluated after the response. Each result is stored as
+	// "vars.<name>" so the following requests can refer to it directly.
+	Extract map[string]string `json:"extract,omitempty" yaml:"extract,omitempty"`
+	// ResponseSchema is a JSON Schema, inline or as a file path, the response body is validated against.
+	// The result is stored as "resp.schema.valid" and the violations as "resp.schema.errors".
+	ResponseSchema string `json:"response_schema,omitempty" yaml:"response_schema,omitempty"`
+	// Auth authenticates this request instead of the probe's Auth. Type "none" disables the probe's Auth.
+	Auth *AuthConfig `json:"auth,omitempty" yaml:"auth,omitempty"`
+	// LatencyBudget is the longest this request may take, retries included, before the probe is DEGRADED. Zero means no budget.
+	LatencyBudget time.Duration `json:"latency_budget,omitempty" yaml:"latency_budget,omitempty"`
+	// DegradedIfExpr is evaluated once the request succeeded, the probe is DEGRADED if it returns true.
+	DegradedIfExpr string `json:"degraded_if_expr,omitempty" yaml:"degraded_if_expr,omitempty"`
+}
+
+const (
+	AuthNone   = "none"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+	AuthOAuth2 = "oauth2"
+	AuthHMAC   = "hmac"
+
+	GrantClientCredentials = "client_credentials"
+	GrantPassword          = "password"
+)
+
+// AuthConfig configures how a request authenticates. Type is one of "basic", "bearer", "oauth2", "hmac" or "none".
+type AuthConfig struct {
+	Type string `json:"type" yaml:"type"`
+	// Username and Password are used by basic auth and the OAuth2 password grant.
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	// TokenExpr is the expression of the bearer token, such as vars.token
+	TokenExpr string `json:"token_expr,omitempty" yaml:"token_expr,omitempty"`
+	// TokenURL, ClientID, ClientSecret, Scopes and Grant configure the OAuth2 token request. Grant is either
+	// "client_credentials" (default) or "password". The token is cached until it expires.
+	TokenURL     string   `json:"token_url,omitempty" yaml:"token_url,omitempty"`
+	ClientID     string   `json:"client_id,omitempty" yaml:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty" yaml:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	Grant        string   `json:"grant,omitempty" yaml:"grant,omitempty"`
+	// ClientAuthInBody sends the client credentials as form parameters instead of basic auth.
+	ClientAuthInBody bool `json:"client_auth_in_body,omitempty" yaml:"client_auth_in_body,omitempty"`
+	// KeyID and Secret sign the request with HMAC using Algorithm, "sha256" (default), "sha1" or "sha512".
+	KeyID     string `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	Secret    string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Algorithm string `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+	// CanonicalExpr is the expression of the string to sign. If empty, the method, request uri, date and
+	// hex SHA-256 of the body are joined with new lines.
+	CanonicalExpr string `json:"canonical_expr,omitempty" yaml:"canonical_expr,omitempty"`
+	// Header receives the signature as "<Prefix> <KeyID>:<base64 signature>". Default to Authorization and HMAC.
+	Header string `json:"header,omitempty" yaml:"header,omitempty"`
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+}
+
+// GrantType returns the OAuth2 grant, defaulting to client credentials.
+func (ac *AuthConfig) GrantType() string {
+	if len(ac.Grant) == 0 {
+		return GrantClientCredentials
+	}
+	return ac.Grant
+}
+
+// SignatureHeader returns the header receiving the HMAC signature and the prefix of its value.
+func (ac *AuthConfig) SignatureHeader() (string, string) {
+	header, prefix := ac.Header, ac.Prefix
+	if len(header) == 0 {
+		header = "Authorization"
+	}
+	if len(prefix) == 0 {
+		prefix = "HMAC"
+	}
+	return header, prefix
+}
+
+// BodyCapture configures how much of the response body get stored into the ProbeContext
+type BodyCapture struct {
+	// MediaTypes list the media types whose body are stored as string. Wildcard such as "text/*" or
+	// "application/*+json" are allowed. If empty, DefaultCaptureMediaTypes is used.
+	MediaTypes []string `json:"media_types,omitempty" yaml:"media_types,omitempty"`
+	// MaxSize is the maximum number of bytes stored. If zero, DefaultCaptureMaxSize is used.
+	MaxSize int `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+	// Base64Binary when true, body of other media types is stored as base64 string.
+	Base64Binary bool `json:"base64_binary,omitempty" yaml:"base64_binary,omitempty"`
+}
+
+// CaptureMediaTypes returns the configured capturable media types or the default ones.
+func (bc *BodyCapture) CaptureMediaTypes() []string {
+	if bc == nil || len(bc.MediaTypes) == 0 {
+		return DefaultCaptureMediaTypes
+	}
+	return bc.MediaTypes
+}
+
+// CaptureMaxSize returns the configured maximum stored body size or the default one.
+func (bc *BodyCapture) CaptureMaxSize() int {
+	if bc == nil || bc.MaxSize <= 0 {
+		return DefaultCaptureMaxSize
+	}
+	return bc.MaxSize
+}
+
+// CaptureBase64 tells whether binary body should be stored as base64 string.
+func (bc *BodyCapture) CaptureBase64() bool {
+	return bc != nil && bc.Base64Binary
+}
+
+// IsCapturable checks the media type (without parameter) against the list of capturable media type patterns.
+func (bc *BodyCapture) IsCapturable(mediaType string) bool {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range bc.CaptureMediaTypes() {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if strings.Contains(pattern, "*") {
+			star := strings.Index(pattern, "*")
+			prefix, suffix := pattern[:star], pattern[star+1:]
+			if len(mediaType) >= len(prefix)+len(suffix) && strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ShouldFollowRedirects tells whether redirect responses should be followed. Redirects are followed unless explicitly disabled.
+func (pr *ProbeRequest) ShouldFollowRedirects() bool {
+	return pr.FollowRedirects == nil || *pr.FollowRedirects
+}
+
+// RedirectLimit returns the maximum number of redirect hops to follow, defaulting to DefaultMaxRedirects.
+func (pr *ProbeRequest) RedirectLimit() int {
+	if pr.MaxRedirects <= 0 {
+		return DefaultMaxRedirects
+	}
+	return pr.MaxRedirects
+}
+
+// ExtractNames returns the names of the variables to extract, sorted so they are always evaluated in the same order.
+func (pr *ProbeRequest) ExtractNames() []string {
+	names := make([]string, 0, len(pr.Extract))
+	for name := range pr.Extract {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsValidVariableName checks if the name can be used as extracted variable, it must be a valid expression identifier.
+func IsValidVariableName(name string) bool {
+	return variableNamePattern.MatchString(name)
 }
 
 func YAMLToProbePool(yamlBytes []byte) (probePool ProbePool, err error) {
 	pool := make(ProbePool, 0)
-	err = yaml.Unmarshal(yamlBytes, &pool)
+	err = unmarshalYAML(yamlBytes, &pool)
 	if err != nil {
 		return nil, err
 	}
@@ -177,5 +550,5 @@ func YAMLToProbePool(yamlBytes []byte) (probePool ProbePool, err error) {
 }
 
 func ProbePoolToYAML(pool ProbePool) (yamlBytes []byte, err error) {
-	return yaml.Marshal(pool)
+	return marshalYAML(pool)
 }
@@ -3,10 +3,8 @@ package internal
 import (
 	"bytes"
 	"fmt"
-	"github.com/google/cel-go/checker/decls"
 	"github.com/newm4n/mihp/pkg/helper"
 	"github.com/olekukonko/tablewriter"
-	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 	"reflect"
 	"sort"
 	"strconv"
@@ -24,6 +22,7 @@ func (pctx ProbeContext) String() string {
 	return pctx.ToString(true)
 }
 
+// Serialize encodes the context with its secrets and sensitive headers masked.
 func (pctx ProbeContext) Serialize() ([]byte, error) {
 	buff := &bytes.Buffer{}
 
@@ -36,7 +35,7 @@ func (pctx ProbeContext) Serialize() ([]byte, error) {
 		if err != nil {
 			return nil, err
 		}
-		err = helper.Put(buff, v)
+		err = helper.Put(buff, RedactValue(k, v))
 		if err != nil {
 			return nil, err
 		}
@@ -110,6 +109,7 @@ func ToPrint(val reflect.Value) string {
 	}
 }
 
+// ToString renders the context as a table, with its secrets and sensitive headers masked.
 func (pctx ProbeContext) ToString(short bool) string {
 	var buff = &bytes.Buffer{}
 	buff.WriteString("\n")
@@ -123,156 +123,20 @@ func (pctx ProbeContext) ToString(short bool) string {
 	sort.Strings(keys)
 
 	for k, v := range keys {
-		if err, ok := pctx[v].(error); ok {
+		value := RedactValue(v, pctx[v])
+		if err, ok := value.(error); ok {
 			table.Append([]string{strconv.Itoa(k + 1), v, err.Error()})
 		} else {
-			toPrint := ToPrint(reflect.ValueOf(pctx[v]))
-			if reflect.TypeOf(pctx[v]).Kind() == reflect.String && len(toPrint) > 20 && short {
+			toPrint := ToPrint(reflect.ValueOf(value))
+			if reflect.TypeOf(value).Kind() == reflect.String && len(toPrint) > 20 && short {
 				if len(toPrint) > 20 {
 					toPrint = fmt.Sprintf("%s...(%d bytes more)", toPrint[:20], len(toPrint)-20)
 				}
 			}
-			table.Append([]string{strconv.Itoa(k + 1), v, toPrint, reflect.TypeOf(pctx[v]).String()})
+			table.Append([]string{strconv.Itoa(k + 1), v, toPrint, reflect.TypeOf(value).String()})
 		}
 	}
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.Render()
 	return buff.String()
 }
-
-func (pctx ProbeContext) Declarations() ([]*exprpb.Decl, error) {
-	declarations := make([]*exprpb.Decl, 0)
-	for k, v := range pctx {
-		vType := reflect.TypeOf(v)
-		var typ *exprpb.Type
-		switch helper.GetBaseKindOfType(vType) {
-		case helper.BaseKindFloat:
-			typ = decls.Double
-		case helper.BaseKindBool:
-			typ = decls.Bool
-		case helper.BaseKindUint:
-			typ = decls.Uint
-		case helper.BaseKindInt:
-			typ = decls.Int
-		case helper.BaseKindString:
-			typ = decls.String
-		case helper.BaseKindTime:
-			typ = decls.Timestamp
-		case helper.BaseKindDuration:
-			typ = decls.Duration
-		case helper.BaseKindArray:
-			var subTyp *exprpb.Type
-			switch helper.GetBaseKindOfType(vType.Elem()) {
-			case helper.BaseKindFloat:
-				subTyp = decls.Double
-			case helper.BaseKindBool:
-				subTyp = decls.Bool
-			case helper.BaseKindUint:
-				subTyp = decls.Uint
-			case helper.BaseKindInt:
-				subTyp = decls.Int
-			case helper.BaseKindString:
-				subTyp = decls.String
-			case helper.BaseKindTime:
-				subTyp = decls.Timestamp
-			default:
-				subTyp = decls.Any
-			}
-			typ = decls.NewListType(subTyp)
-		case helper.BaseKindMap:
-			typ = decls.NewMapType(decls.String, decls.String)
-		default:
-			typ = decls.Any
-		}
-		declarations = append(declarations, decls.NewVar(k, typ))
-	}
-	declarations = append(declarations, decls.NewFunction("IsDefined",
-		decls.NewOverload("KeyExist_string_boolean",
-			[]*exprpb.Type{decls.String},
-			decls.Bool)))
-
-	declarations = append(declarations, decls.NewFunction("GetString",
-		decls.NewOverload("GetString_string_string",
-			[]*exprpb.Type{decls.String},
-			decls.String)))
-	declarations = append(declarations, decls.NewFunction("GetInt",
-		decls.NewOverload("GetInt_string_int",
-			[]*exprpb.Type{decls.String},
-			decls.Int)))
-	declarations = append(declarations, decls.NewFunction("GetUint",
-		decls.NewOverload("GetUint_string_uint",
-			[]*exprpb.Type{decls.Uint},
-			decls.Int)))
-	declarations = append(declarations, decls.NewFunction("GetFloat",
-		decls.NewOverload("GetFloat_string_float",
-			[]*exprpb.Type{decls.String},
-			decls.Double)))
-	declarations = append(declarations, decls.NewFunction("GetBool",
-		decls.NewOverload("GetBool_string_bool",
-			[]*exprpb.Type{decls.String},
-			decls.Bool)))
-	declarations = append(declarations, decls.NewFunction("GetTime",
-		decls.NewOverload("GetTime_string_time",
-			[]*exprpb.Type{decls.String},
-			decls.Timestamp)))
-	declarations = append(declarations, decls.NewFunction("GetDuration",
-		decls.NewOverload("GetDuration_string_duration",
-			[]*exprpb.Type{decls.String},
-			decls.Duration)))
-
-	declarations = append(declarations, decls.NewFunction("GetLength",
-		decls.NewOverload("GetLength_string_int",
-			[]*exprpb.Type{decls.String},
-			decls.Int)))
-	declarations = append(declarations, decls.NewFunction("GetStringElem",
-		decls.NewOverload("GetStringElem_string_int_string",
-			[]*exprpb.Type{decls.String, decls.Int},
-			decls.String)))
-	declarations = append(declarations, decls.NewFunction("GetIntElem",
-		decls.NewOverload("GetIntElem_string_int_int",
-			[]*exprpb.Type{decls.String, decls.Int},
-			decls.Int)))
-	declarations = append(declarations, decls.NewFunction("GetUintElem",
-		decls.NewOverload("GetUintElem_string_int_uint",
-			[]*exprpb.Type{decls.String, decls.Int},
-			decls.Uint)))
-	declarations = append(declarations, decls.NewFunction("GetFloatElem",
-		decls.NewOverload("GetFloatElem_string_int_float",
-			[]*exprpb.Type{decls.String, decls.Int},
-			decls.Double)))
-	declarations = append(declarations, decls.NewFunction("GetBoolElem",
-		decls.NewOverload("GetBoolElem_string_int_bool",
-			[]*exprpb.Type{decls.String, decls.Int},
-			decls.Bool)))
-	declarations = append(declarations, decls.NewFunction("GetTimeElem",
-		decls.NewOverload("GetTimeElem_string_int_time",
-			[]*exprpb.Type{decls.String, decls.Int},
-			decls.Timestamp)))
-	declarations = append(declarations, decls.NewFunction("GetDurationElem",
-		decls.NewOverload("GetDurationElem_string_int_duration",
-			[]*exprpb.Type{decls.String, decls.Int},
-			decls.Duration)))
-
-	declarations = append(declarations, decls.NewFunction("GetJsonStringValue",
-		decls.NewOverload("GetJsonStringValue_string_string_string",
-			[]*exprpb.Type{decls.String, decls.String},
-			decls.String)))
-	declarations = append(declarations, decls.NewFunction("GetJsonIntValue",
-		decls.NewOverload("GetJsonIntValue_string_string_int",
-			[]*exprpb.Type{decls.String, decls.String},
-			decls.Int)))
-	declarations = append(declarations, decls.NewFunction("GetJsonUintValue",
-		decls.NewOverload("GetJsonUintValue_string_string_uint",
-			[]*exprpb.Type{decls.String, decls.String},
-			decls.Uint)))
-	declarations = append(declarations, decls.NewFunction("GetJsonFloatValue",
-		decls.NewOverload("GetJsonFloatValue_string_string_float",
-			[]*exprpb.Type{decls.String, decls.String},
-			decls.Double)))
-	declarations = append(declarations, decls.NewFunction("GetJsonBoolValue",
-		decls.NewOverload("GetJsonBoolValue_string_string_float",
-			[]*exprpb.Type{decls.String, decls.String},
-			decls.Bool)))
-
-	return declarations, nil
-}
@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"fmt"
+	"github.com/newm4n/mihp/pkg/errors"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Mask replaces secret values in context dumps, logs and notifications.
+	Mask = "******"
+)
+
+var (
+	// SensitiveHeaders are the http headers whose values are always masked.
+	SensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Auth-Token"}
+
+	secretReferencePattern = regexp.MustCompile(`\$\{(env|file):([^}]+)}`)
+
+	secretMutex sync.RWMutex
+	// secretValues are the values masked by Redact, longest first
+	secretValues = make([]string, 0)
+	// resolvedScalars maps the yaml path of each resolved scalar to the scalar with its references
+	resolvedScalars = make(map[string]resolvedScalar)
+)
+
+// resolvedScalar is a yaml scalar with secret references, and the value they resolved to.
+type resolvedScalar struct {
+	reference string
+	value     string
+}
+
+func init() {
+	logrus.AddHook(&RedactHook{})
+}
+
+// RegisterSecret marks the value as secret, so it is masked wherever Redact is applied.
+func RegisterSecret(value string) {
+	if len(value) == 0 {
+		return
+	}
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	for _, v := range secretValues {
+		if v == value {
+			return
+		}
+	}
+	secretValues = append(secretValues, value)
+	sort.SliceStable(secretValues, func(i, j int) bool {
+		return len(secretValues[i]) > len(secretValues[j])
+	})
+}
+
+// Redact masks every secret value found in the text.
+func Redact(text string) string {
+	secretMutex.RLock()
+	defer secretMutex.RUnlock()
+	for _, secret := range secretValues {
+		text = strings.ReplaceAll(text, secret, Mask)
+	}
+	return text
+}
+
+// IsSensitiveHeader checks if the header value should never be printed.
+func IsSensitiveHeader(name string) bool {
+	for _, header := range SensitiveHeaders {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSensitiveKey checks if the probe context key holds an auth token or a sensitive request or response header,
+// such as "probe.Login.req.Token.header.Authorization".
+func IsSensitiveKey(key string) bool {
+	if strings.HasSuffix(key, ".auth.token") {
+		return true
+	}
+	idx := strings.LastIndex(key, ".header.")
+	return idx >= 0 && IsSensitiveHeader(key[idx+len(".header."):])
+}
+
+// RedactValue returns the value to print for the probe context key. Strings of a sensitive key are masked entirely,
+// other strings have their secret values masked.
+func RedactValue(key string, value interface{}) interface{} {
+	sensitive := IsSensitiveKey(key)
+	switch v := value.(type) {
+	case string:
+		if sensitive {
+			return Mask
+		}
+		return Redact(v)
+	case []string:
+		redacted := make([]string, len(v))
+		for i, s := range v {
+			if sensitive {
+				redacted[i] = Mask
+			} else {
+				redacted[i] = Redact(s)
+			}
+		}
+		return redacted
+	case error:
+		return fmt.Errorf("%s", Redact(v.Error()))
+	}
+	return value
+}
+
+// ResolveSecret replaces the ${env:NAME} and ${file:/path} references in the text.
+// The resolved values are registered as secrets.
+func ResolveSecret(text string) (string, error) {
+	var resolveErr error
+	resolved := secretReferencePattern.ReplaceAllStringFunc(text, func(reference string) string {
+		match := secretReferencePattern.FindStringSubmatch(reference)
+		source := strings.TrimSpace(match[2])
+		var value string
+		switch match[1] {
+		case "env":
+			val, ok := os.LookupEnv(source)
+			if !ok {
+				resolveErr = fmt.Errorf("%w : %s, environment variable %s is not set", errors.ErrSecretNotResolved, reference, source)
+				return reference
+			}
+			value = val
+		case "file":
+			data, err := ioutil.ReadFile(source)
+			if err != nil {
+				resolveErr = fmt.Errorf("%w : %s, got %s", errors.ErrSecretNotResolved, reference, err.Error())
+				return reference
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		RegisterSecret(value)
+		return value
+	})
+	if resolveErr != nil {
+		return text, resolveErr
+	}
+	return resolved, nil
+}
+
+// resolveSecrets resolves the secret references of every scalar in the yaml tree at path. Plain values of
+// password and secret fields are registered as secrets as well.
+func resolveSecrets(node *yaml.Node, path string) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if !secretReferencePattern.MatchString(node.Value) {
+			return nil
+		}
+		resolved, err := ResolveSecret(node.Value)
+		if err != nil {
+			return err
+		}
+		secretMutex.Lock()
+		resolvedScalars[path] = resolvedScalar{reference: node.Value, value: resolved}
+		secretMutex.Unlock()
+		node.Value = resolved
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := resolveSecrets(node.Content[i+1], yamlPath(path, node.Content[i].Value)); err != nil {
+				return err
+			}
+			if isSecretField(node.Content[i].Value) && node.Content[i+1].Kind == yaml.ScalarNode {
+				RegisterSecret(node.Content[i+1].Value)
+			}
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			if err := resolveSecrets(child, yamlPath(path, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, child := range node.Content {
+			if err := resolveSecrets(child, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func yamlPath(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+// unmarshalYAML decodes the yaml into out after resolving its secret references.
+func unmarshalYAML(yamlBytes []byte, out interface{}) error {
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(yamlBytes, node); err != nil {
+		return err
+	}
+	if node.Kind == 0 {
+		return nil
+	}
+	if err := resolveSecrets(node, ""); err != nil {
+		return err
+	}
+	return node.Decode(out)
+}
+
+// marshalYAML encodes in as yaml, with the resolved secrets turned back to their references.
+func marshalYAML(in interface{}) ([]byte, error) {
+	yamlBytes, err := yaml.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	secretMutex.RLock()
+	resolved := len(resolvedScalars)
+	secretMutex.RUnlock()
+	if resolved == 0 {
+		return yamlBytes, nil
+	}
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(yamlBytes, node); err != nil {
+		return nil, err
+	}
+	restoreSecrets(node, "")
+	return yaml.Marshal(node)
+}
+
+// restoreSecrets puts back the secret references of the scalars at path that were resolved on load,
+// so a configuration written back to file never contains the resolved secrets.
+func restoreSecrets(node *yaml.Node, path string) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		secretMutex.RLock()
+		if original, ok := resolvedScalars[path]; ok && original.value == node.Value {
+			node.Value = original.reference
+		}
+		secretMutex.RUnlock()
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			restoreSecrets(node.Content[i+1], yamlPath(path, node.Content[i].Value))
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			restoreSecrets(child, yamlPath(path, strconv.Itoa(i)))
+		}
+	default:
+		for _, child := range node.Content {
+			restoreSecrets(child, path)
+		}
+	}
+}
+
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	return strings.Contains(name, "password") || strings.Contains(name, "secret") || name == "jwt_key"
+}
+
+// RedactHook masks secret values in the message and fields of every logrus entry.
+type RedactHook struct{}
+
+// Levels of the hook, all of them.
+func (hook *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts the entry before it is formatted.
+func (hook *RedactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	if len(entry.Data) > 0 {
+		data := make(logrus.Fields, len(entry.Data))
+		for k, v := range entry.Data {
+			switch {
+			case IsSensitiveHeader(k) || isSecretField(k):
+				data[k] = Mask
+			case k == logrus.ErrorKey:
+				if err, ok := v.(error); ok {
+					data[k] = Redact(err.Error())
+				} else {
+					data[k] = v
+				}
+			default:
+				if s, ok := v.(string); ok {
+					data[k] = Redact(s)
+				} else {
+					data[k] = v
+				}
+			}
+		}
+		entry.Data = data
+	}
+	return nil
+}
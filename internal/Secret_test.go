@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("MIHP_TEST_API_KEY", "k3y-from-env")
+	file := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(file, []byte("t0ken-from-file\n"), 0600))
+
+	resolved, err := ResolveSecret("Bearer ${file:" + file + "}")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer t0ken-from-file", resolved)
+
+	resolved, err = ResolveSecret("${env:MIHP_TEST_API_KEY}")
+	assert.NoError(t, err)
+	assert.Equal(t, "k3y-from-env", resolved)
+	assert.Equal(t, "key=******&token=******", Redact("key=k3y-from-env&token=t0ken-from-file"))
+
+	resolved, err = ResolveSecret("no reference")
+	assert.NoError(t, err)
+	assert.Equal(t, "no reference", resolved)
+
+	_, err = ResolveSecret("${env:MIHP_TEST_NOT_SET}")
+	assert.True(t, errors.Is(err, mihperrors.ErrSecretNotResolved))
+	_, err = ResolveSecret("${file:" + filepath.Join(t.TempDir(), "missing") + "}")
+	assert.True(t, errors.Is(err, mihperrors.ErrSecretNotResolved))
+}
+
+func TestYAMLToMIHPConfig_Secrets(t *testing.T) {
+	t.Setenv("MIHP_TEST_SMTP_PASSWORD", "smtp-pa55word")
+	yamlText := `version: 1.0.0
+probe_pool:
+  - name: Login
+    base_url: https://example.com
+    requests:
+      - name: Token
+        path_expr: '"/token"'
+        headers_expr:
+          X-Api-Key:
+            - '"${env:MIHP_TEST_SMTP_PASSWORD}"'
+    SMTP_notification:
+      smtp_host: smtp.example.com
+      smtp_port: 587
+      password: ${env:MIHP_TEST_SMTP_PASSWORD}
+central:
+  admin_user: admin
+  admin_password: plain-adm1n-password
+`
+	config, err := YAMLToMIHPConfig([]byte(yamlText))
+	assert.NoError(t, err)
+	assert.Equal(t, "smtp-pa55word", config.ProbePool[0].SMTPNotification.Password)
+	assert.Equal(t, `"smtp-pa55word"`, config.ProbePool[0].Requests[0].HeadersExpr["X-Api-Key"][0])
+	assert.Equal(t, "plain-adm1n-password", config.Central.AdminPassword)
+	assert.Equal(t, "******:******", Redact("plain-adm1n-password:smtp-pa55word"))
+
+	yamlBytes, err := MIHPConfigToYAML(config)
+	assert.NoError(t, err)
+	assert.Contains(t, string(yamlBytes), "password: ${env:MIHP_TEST_SMTP_PASSWORD}")
+	assert.Contains(t, string(yamlBytes), `'"${env:MIHP_TEST_SMTP_PASSWORD}"'`)
+	assert.NotContains(t, string(yamlBytes), "smtp-pa55word")
+
+	_, err = YAMLToMIHPConfig([]byte("version: 1.0.0\ncentral:\n  admin_password: ${env:MIHP_TEST_NOT_SET}\n"))
+	assert.True(t, errors.Is(err, mihperrors.ErrSecretNotResolved))
+}
+
+func TestMIHPConfigToYAML_SameValue(t *testing.T) {
+	t.Setenv("MIHP_TEST_SMTP_HOST", "admin")
+	yamlText := `version: 1.0.0
+probe_pool:
+  - name: Login
+    base_url: https://example.com
+    SMTP_notification:
+      smtp_host: ${env:MIHP_TEST_SMTP_HOST}
+central:
+  admin_user: admin
+`
+	config, err := YAMLToMIHPConfig([]byte(yamlText))
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", config.ProbePool[0].SMTPNotification.SMTPHost)
+
+	yamlBytes, err := MIHPConfigToYAML(config)
+	assert.NoError(t, err)
+	assert.Contains(t, string(yamlBytes), "smtp_host: ${env:MIHP_TEST_SMTP_HOST}")
+	assert.Contains(t, string(yamlBytes), "admin_user: admin")
+
+	config.ProbePool[0].SMTPNotification.SMTPHost = "smtp.example.com"
+	yamlBytes, err = MIHPConfigToYAML(config)
+	assert.NoError(t, err)
+	assert.Contains(t, string(yamlBytes), "smtp_host: smtp.example.com")
+}
+
+func TestProbeContext_Redaction(t *testing.T) {
+	RegisterSecret("s3cret-value")
+	pctx := NewProbeContext()
+	pctx["probe.Login.req.Token.header.Authorization"] = []string{"Bearer abc"}
+	pctx["probe.Login.req.Token.resp.header.Set-Cookie"] = []string{"session=xyz"}
+	pctx["probe.Login.req.Token.header.Accept"] = []string{"application/json"}
+	pctx["probe.Login.req.Token.url"] = "https://example.com/?key=s3cret-value"
+	pctx["probe.Login.req.Token.resp.code"] = 200
+
+	dump := pctx.ToString(false)
+	assert.NotContains(t, dump, "Bearer abc")
+	assert.NotContains(t, dump, "session=xyz")
+	assert.NotContains(t, dump, "s3cret-value")
+	assert.Contains(t, dump, "application/json")
+	assert.Contains(t, dump, "https://example.com/?key=******")
+
+	data, err := pctx.Serialize()
+	assert.NoError(t, err)
+	restored := NewProbeContext()
+	assert.NoError(t, restored.Deserialize(data))
+	assert.Equal(t, []string{Mask}, restored["probe.Login.req.Token.header.Authorization"])
+	assert.Equal(t, []string{"application/json"}, restored["probe.Login.req.Token.header.Accept"])
+	assert.Equal(t, "https://example.com/?key=******", restored["probe.Login.req.Token.url"])
+}
+
+func TestRedactHook(t *testing.T) {
+	RegisterSecret("h00k-secret")
+	buff := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(buff)
+	logger.AddHook(&RedactHook{})
+
+	logger.WithField("Authorization", "Bearer abc").WithField("url", "https://example.com/?k=h00k-secret").Infof("calling with h00k-secret")
+	assert.False(t, strings.Contains(buff.String(), "h00k-secret"))
+	assert.False(t, strings.Contains(buff.String(), "Bearer abc"))
+	assert.Contains(t, buff.String(), "calling with ******")
+}
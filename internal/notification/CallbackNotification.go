@@ -1,30 +1,83 @@
 package notification
 
 import (
+	"github.com/newm4n/mihp/internal"
 	"github.com/newm4n/mihp/internal/probing"
 	"net/http"
 	"time"
 )
 
 type CallbackNotification struct {
-	UpURL     string
-	DownURL   string
-	EventType EventType
+	UpURL       string
+	DownURL     string
+	DegradedURL string
+	FlappingURL string
+	// EscalationURL is called while the probe stays DOWN under an escalation policy.
+	EscalationURL string
+	EventType     EventType
 }
 
 func (notif *CallbackNotification) Notify() error {
 	client := probing.NewHttpClient(10, 10, true)
-	if notif.EventType == EventUp {
+	switch notif.EventType {
+	case EventUp:
+		if len(notif.UpURL) == 0 {
+			return nil
+		}
 		req, _ := http.NewRequest("GET", notif.UpURL, nil)
 		_, err := client.Do(req)
 		return err
-	} else {
+	case EventDegraded:
+		if len(notif.DegradedURL) == 0 {
+			return nil
+		}
+		req, _ := http.NewRequest("GET", notif.DegradedURL, nil)
+		_, err := client.Do(req)
+		return err
+	case EventFlapping:
+		if len(notif.FlappingURL) == 0 {
+			return nil
+		}
+		req, _ := http.NewRequest("GET", notif.FlappingURL, nil)
+		_, err := client.Do(req)
+		return err
+	case EventEscalation:
+		if len(notif.EscalationURL) == 0 {
+			return nil
+		}
+		req, _ := http.NewRequest("GET", notif.EscalationURL, nil)
+		_, err := client.Do(req)
+		return err
+	default:
+		if len(notif.DownURL) == 0 {
+			return nil
+		}
 		req, _ := http.NewRequest("GET", notif.DownURL, nil)
 		_, err := client.Do(req)
 		return err
 	}
 }
 
-func (notif *CallbackNotification) CallbackNotificationTrigger(probeName, probeId string, down bool, firstUp, lastUp, firstDown, lastDown time.Time) {
+// NewCallbackTrigger returns the trigger calling the target's url of the status a probe changes to.
+func NewCallbackTrigger(target *internal.CallbackNotificationTarget) probing.Trigger {
+	return func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *probing.LatencySummary) {
+		send(&CallbackNotification{
+			UpURL:       target.UpCall,
+			DownURL:     target.DownCall,
+			DegradedURL: target.DegradedCall,
+			FlappingURL: target.FlappingCall,
+			EventType:   eventTypeOf(status),
+		}, probeName)
+	}
+}
 
+// NewCallbackEscalationTrigger returns the escalation trigger calling the target's down url again while a probe
+// stays DOWN, and the policy's EscalateCall as well once the outage is escalated.
+func NewCallbackEscalationTrigger(target *internal.CallbackNotificationTarget, policy *internal.EscalationPolicy) probing.EscalationTrigger {
+	return func(probeName, probeId string, since time.Time, down time.Duration, count int, escalated bool) {
+		send(&CallbackNotification{DownURL: target.DownCall, EventType: EventDown}, probeName)
+		if escalated && policy != nil {
+			send(&CallbackNotification{EscalationURL: policy.EscalateCall, EventType: EventEscalation}, probeName)
+		}
+	}
 }
@@ -0,0 +1,57 @@
+package notification
+
+import (
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/internal/probing"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewCallbackTrigger(t *testing.T) {
+	called := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- r.URL.Path
+	}))
+	defer server.Close()
+
+	trigger := NewCallbackTrigger(&internal.CallbackNotificationTarget{
+		UpCall:       server.URL + "/up",
+		DownCall:     server.URL + "/down",
+		DegradedCall: server.URL + "/degraded",
+	})
+	now := time.Now()
+	for status, path := range map[string]string{probing.StatusDegraded: "/degraded", probing.StatusDown: "/down", probing.StatusUp: "/up"} {
+		trigger("Shop", "1", status, probing.StatusUp, now, now.Add(-time.Hour), nil)
+		select {
+		case got := <-called:
+			assert.Equal(t, path, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s callback was not called", status)
+		}
+	}
+}
+
+func TestNewCallbackEscalationTrigger(t *testing.T) {
+	called := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called <- r.URL.Path
+	}))
+	defer server.Close()
+
+	trigger := NewCallbackEscalationTrigger(&internal.CallbackNotificationTarget{DownCall: server.URL + "/down"},
+		&internal.EscalationPolicy{EscalateCall: server.URL + "/escalate"})
+	trigger("Shop", "1", time.Now(), time.Hour, 2, true)
+	paths := make([]string, 0, 2)
+	for len(paths) < 2 {
+		select {
+		case got := <-called:
+			paths = append(paths, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("callbacks were not called, got %v", paths)
+		}
+	}
+	assert.ElementsMatch(t, []string{"/down", "/escalate"}, paths)
+}
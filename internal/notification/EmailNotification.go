@@ -22,6 +22,14 @@ var (
 	downMailBodyTmpl *template.Template
 	upSubjectTmpl    *template.Template
 	downSubjectTmpl  *template.Template
+
+	degradedMailBodyTmpl *template.Template
+	degradedSubjectTmpl  *template.Template
+	flappingMailBodyTmpl *template.Template
+	flappingSubjectTmpl  *template.Template
+
+	escalationMailBodyTmpl *template.Template
+	escalationSubjectTmpl  *template.Template
 )
 
 func init() {
@@ -45,6 +53,36 @@ func init() {
 		log.Fatal(err)
 	}
 	downSubjectTmpl = tmpl
+	tmpl, err = template.ParseFS(staticFolder, "static/smtp_degraded_body.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	degradedMailBodyTmpl = tmpl
+	tmpl, err = template.ParseFS(staticFolder, "static/smtp_degraded_subject.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	degradedSubjectTmpl = tmpl
+	tmpl, err = template.ParseFS(staticFolder, "static/smtp_flapping_body.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	flappingMailBodyTmpl = tmpl
+	tmpl, err = template.ParseFS(staticFolder, "static/smtp_flapping_subject.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	flappingSubjectTmpl = tmpl
+	tmpl, err = template.ParseFS(staticFolder, "static/smtp_escalation_body.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	escalationMailBodyTmpl = tmpl
+	tmpl, err = template.ParseFS(staticFolder, "static/smtp_escalation_subject.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	escalationSubjectTmpl = tmpl
 }
 
 type EmailNotification struct {
@@ -115,6 +153,8 @@ func (notif *EmailNotification) SendNotification(subject, body string) error {
 
 	sendmailLog.Infof("Using PlainAuth u=%s p=******", notif.FromField)
 
+	subject, body = internal.Redact(subject), internal.Redact(body)
+
 	var bodyBuffer bytes.Buffer
 
 	bodyBuffer.WriteString(notif.Headers(subject))
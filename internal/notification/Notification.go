@@ -1,5 +1,11 @@
 package notification
 
+import (
+	"github.com/newm4n/mihp/internal/probing"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
 const (
 	NotifTypeEmailSMTP = "SMTP"
 	NotifTypeCallBack  = "CALLBACK"
@@ -9,6 +15,9 @@ const (
 
 	EventUp EventType = iota
 	EventDown
+	EventDegraded
+	EventFlapping
+	EventEscalation
 )
 
 type EventType int
@@ -24,3 +33,38 @@ var (
 func init() {
 	notificationChannel = make(chan Notification)
 }
+
+var (
+	notifyLog = logrus.WithField("module", "Notification")
+)
+
+// eventTypeOf returns the event of a probe turning to the status.
+func eventTypeOf(status string) EventType {
+	switch status {
+	case probing.StatusUp:
+		return EventUp
+	case probing.StatusDegraded:
+		return EventDegraded
+	case probing.StatusFlapping:
+		return EventFlapping
+	default:
+		return EventDown
+	}
+}
+
+// statusDuration tells how long the previous status lasted, unknown for a probe seen for the first time.
+func statusDuration(since, previousSince time.Time) string {
+	if since.Sub(previousSince) > 24*365*time.Hour {
+		return "an unknown time"
+	}
+	return since.Sub(previousSince).String()
+}
+
+// send notifies in the background, so the probe processing does not wait for mail servers or callbacks.
+func send(notif Notification, probeName string) {
+	go func() {
+		if err := notif.Notify(); err != nil {
+			notifyLog.Errorf("can not notify probe %s. got %s", probeName, err.Error())
+		}
+	}()
+}
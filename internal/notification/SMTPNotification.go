@@ -3,9 +3,13 @@ package notification
 import (
 	"bytes"
 	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/internal/probing"
 	"github.com/sirupsen/logrus"
+	"html/template"
 	"net/smtp"
 	"strings"
+	"time"
 )
 
 func NewSMTPDownNotification() *SMTPNotification {
@@ -22,6 +26,7 @@ func NewSMTPDownNotification() *SMTPNotification {
 		Cause:         "",
 		UpDuration:    "",
 		DownDuration:  "",
+		Latency:       "",
 		SMTPHost:      "",
 		SMTPPort:      0,
 	}
@@ -41,11 +46,134 @@ func NewSMTPUpNotification() *SMTPNotification {
 		Cause:         "",
 		UpDuration:    "",
 		DownDuration:  "",
+		Latency:       "",
 		SMTPHost:      "",
 		SMTPPort:      0,
 	}
 }
 
+func NewSMTPDegradedNotification() *SMTPNotification {
+	return &SMTPNotification{
+		EmailNotification: EmailNotification{
+			FromField: nil,
+			ToList:    nil,
+			CcList:    nil,
+			BccList:   nil,
+		},
+		EventType:     EventDegraded,
+		PasswordField: "",
+		ProbeName:     "",
+		Cause:         "",
+		UpDuration:    "",
+		DownDuration:  "",
+		Latency:       "",
+		SMTPHost:      "",
+		SMTPPort:      0,
+	}
+}
+
+func NewSMTPFlappingNotification() *SMTPNotification {
+	return &SMTPNotification{
+		EmailNotification: EmailNotification{
+			FromField: nil,
+			ToList:    nil,
+			CcList:    nil,
+			BccList:   nil,
+		},
+		EventType:     EventFlapping,
+		PasswordField: "",
+		ProbeName:     "",
+		Cause:         "",
+		UpDuration:    "",
+		DownDuration:  "",
+		Latency:       "",
+		Changes:       0,
+		SMTPHost:      "",
+		SMTPPort:      0,
+	}
+}
+
+func NewSMTPEscalationNotification() *SMTPNotification {
+	return &SMTPNotification{
+		EmailNotification: EmailNotification{
+			FromField: nil,
+			ToList:    nil,
+			CcList:    nil,
+			BccList:   nil,
+		},
+		EventType:     EventEscalation,
+		PasswordField: "",
+		ProbeName:     "",
+		Cause:         "",
+		UpDuration:    "",
+		DownDuration:  "",
+		Latency:       "",
+		Count:         0,
+		Escalated:     false,
+		SMTPHost:      "",
+		SMTPPort:      0,
+	}
+}
+
+// NewSMTPTrigger returns the trigger mailing the target when a probe of the processor changes status.
+// The processor's tracker tells how many times a FLAPPING probe changed.
+func NewSMTPTrigger(target *internal.SMTPNotificationTarget, proc *probing.ProbeEventProcessor) probing.Trigger {
+	return func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *probing.LatencySummary) {
+		send(newSMTPStatusNotification(target, proc, probeName, status, since, previousSince, latency), probeName)
+	}
+}
+
+// newSMTPStatusNotification creates the mail of a probe changing to the status.
+func newSMTPStatusNotification(target *internal.SMTPNotificationTarget, proc *probing.ProbeEventProcessor, probeName, status string,
+	since, previousSince time.Time, latency *probing.LatencySummary) *SMTPNotification {
+	notif := &SMTPNotification{
+		EmailNotification: EmailNotification{
+			FromField: target.From,
+			ToList:    target.To,
+			CcList:    target.Cc,
+			BccList:   target.Bcc,
+		},
+		EventType:     eventTypeOf(status),
+		PasswordField: target.Password,
+		ProbeName:     probeName,
+		SMTPHost:      target.SMTPHost,
+		SMTPPort:      target.SMTPPort,
+	}
+	if latency != nil && latency.Count > 0 {
+		notif.Latency = latency.String()
+	}
+	switch notif.EventType {
+	case EventUp:
+		notif.DownDuration = statusDuration(since, previousSince)
+	case EventDown:
+		notif.Cause = "failing requests"
+		notif.UpDuration = statusDuration(since, previousSince)
+	case EventDegraded:
+		notif.Cause = "slow or unexpected responses"
+		notif.UpDuration = statusDuration(since, previousSince)
+	case EventFlapping:
+		if t := proc.Tracker(probeName); t != nil {
+			notif.Changes = t.StatusChanges(t.FlapWindow)
+		}
+	}
+	return notif
+}
+
+// NewSMTPEscalationTrigger returns the escalation trigger mailing the target while a probe stays DOWN, and the
+// policy's EscalateTo mailboxes as well once the outage is escalated.
+func NewSMTPEscalationTrigger(target *internal.SMTPNotificationTarget, policy *internal.EscalationPolicy) probing.EscalationTrigger {
+	return func(probeName, probeId string, since time.Time, down time.Duration, count int, escalated bool) {
+		notif := NewSMTPEscalationNotification()
+		notif.FromField, notif.ToList, notif.CcList, notif.BccList = target.From, target.To, target.Cc, target.Bcc
+		notif.PasswordField, notif.SMTPHost, notif.SMTPPort = target.Password, target.SMTPHost, target.SMTPPort
+		notif.ProbeName, notif.DownDuration, notif.Count, notif.Escalated = probeName, down.String(), count, escalated
+		if escalated && policy != nil {
+			notif.ToList = append(append([]*internal.Mailbox{}, target.To...), policy.EscalateTo...)
+		}
+		send(notif, probeName)
+	}
+}
+
 type SMTPNotification struct {
 	EmailNotification
 
@@ -56,6 +184,12 @@ type SMTPNotification struct {
 	Cause        string
 	UpDuration   string
 	DownDuration string
+	Changes      int
+	// Count is how many times the outage was notified before, Escalated whether the escalation recipients are in the list.
+	Count     int
+	Escalated bool
+	// Latency summarizes the probe durations of the last hour, such as "p50=120ms p90=300ms p99=2s max=3s of 60 runs".
+	Latency string
 
 	SMTPHost string `json:"smtp_host"`
 	SMTPPort int    `json:"smtp_port"`
@@ -66,6 +200,8 @@ func (notif *SMTPNotification) SendNotification(subject, body string) error {
 
 	auth := smtp.PlainAuth("", notif.FromField.Email, notif.PasswordField, notif.SMTPHost)
 
+	subject, body = internal.Redact(subject), internal.Redact(body)
+
 	var bodyBuffer bytes.Buffer
 
 	bodyBuffer.WriteString(notif.Headers(subject))
@@ -88,50 +224,94 @@ func (notif *SMTPNotification) SendNotification(subject, body string) error {
 }
 
 func (notif *SMTPNotification) Notify() error {
-	if notif.EventType == EventUp {
-		return notif.NotifyUp(notif.ProbeName, notif.DownDuration)
-	} else {
-		return notif.NotifyDown(notif.ProbeName, notif.Cause, notif.UpDuration)
+	subject, body, err := notif.Render()
+	if err != nil {
+		return err
 	}
+	return notif.SendNotification(subject, body)
 }
 
-func (notif *SMTPNotification) NotifyDown(probeName, cause, upDuration string) error {
-	data := map[string]string{
-		"probe":      probeName,
-		"cause":      cause,
-		"upDuration": upDuration,
+// Render returns the subject and the body of the mail of the notification's event.
+func (notif *SMTPNotification) Render() (string, string, error) {
+	switch notif.EventType {
+	case EventUp:
+		return render(upSubjectTmpl, upMailBodyTmpl, map[string]interface{}{
+			"probe":        notif.ProbeName,
+			"downDuration": notif.DownDuration,
+			"latency":      notif.Latency,
+		})
+	case EventDegraded:
+		return render(degradedSubjectTmpl, degradedMailBodyTmpl, map[string]interface{}{
+			"probe":      notif.ProbeName,
+			"cause":      notif.Cause,
+			"upDuration": notif.UpDuration,
+			"latency":    notif.Latency,
+		})
+	case EventFlapping:
+		return render(flappingSubjectTmpl, flappingMailBodyTmpl, map[string]interface{}{
+			"probe":   notif.ProbeName,
+			"changes": fmt.Sprintf("%d", notif.Changes),
+			"latency": notif.Latency,
+		})
+	case EventEscalation:
+		return render(escalationSubjectTmpl, escalationMailBodyTmpl, map[string]interface{}{
+			"probe":        notif.ProbeName,
+			"downDuration": notif.DownDuration,
+			"count":        notif.Count + 1,
+			"escalated":    notif.Escalated,
+			"latency":      notif.Latency,
+		})
+	default:
+		return render(downSubjectTmpl, downMailBodyTmpl, map[string]interface{}{
+			"probe":      notif.ProbeName,
+			"cause":      notif.Cause,
+			"upDuration": notif.UpDuration,
+			"latency":    notif.Latency,
+		})
 	}
+}
+
+func render(subjectTmpl, bodyTmpl *template.Template, data map[string]interface{}) (string, string, error) {
 	subjectbuff := &bytes.Buffer{}
-	err := downSubjectTmpl.Execute(subjectbuff, data)
+	err := subjectTmpl.Execute(subjectbuff, data)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
 	bodybuff := &bytes.Buffer{}
-	err = downMailBodyTmpl.Execute(bodybuff, data)
+	err = bodyTmpl.Execute(bodybuff, data)
 	if err != nil {
-		return err
+		return "", "", err
 	}
+	return subjectbuff.String(), bodybuff.String(), nil
+}
 
-	return notif.SendNotification(subjectbuff.String(), bodybuff.String())
+func (notif *SMTPNotification) NotifyDown(probeName, cause, upDuration string) error {
+	down := *notif
+	down.EventType, down.ProbeName, down.Cause, down.UpDuration = EventDown, probeName, cause, upDuration
+	return down.Notify()
 }
 
 func (notif *SMTPNotification) NotifyUp(probeName, downDuration string) error {
-	data := map[string]string{
-		"probe":        probeName,
-		"downDuration": downDuration,
-	}
-	subjectbuff := &bytes.Buffer{}
-	err := upSubjectTmpl.Execute(subjectbuff, data)
-	if err != nil {
-		return err
-	}
+	up := *notif
+	up.EventType, up.ProbeName, up.DownDuration = EventUp, probeName, downDuration
+	return up.Notify()
+}
 
-	bodybuff := &bytes.Buffer{}
-	err = upMailBodyTmpl.Execute(bodybuff, data)
-	if err != nil {
-		return err
-	}
+func (notif *SMTPNotification) NotifyDegraded(probeName, cause, upDuration string) error {
+	degraded := *notif
+	degraded.EventType, degraded.ProbeName, degraded.Cause, degraded.UpDuration = EventDegraded, probeName, cause, upDuration
+	return degraded.Notify()
+}
+
+func (notif *SMTPNotification) NotifyFlapping(probeName string, changes int) error {
+	flapping := *notif
+	flapping.EventType, flapping.ProbeName, flapping.Changes = EventFlapping, probeName, changes
+	return flapping.Notify()
+}
 
-	return notif.SendNotification(subjectbuff.String(), bodybuff.String())
+func (notif *SMTPNotification) NotifyStillDown(probeName, downDuration string, count int) error {
+	stillDown := *notif
+	stillDown.EventType, stillDown.ProbeName, stillDown.DownDuration, stillDown.Count = EventEscalation, probeName, downDuration, count
+	return stillDown.Notify()
 }
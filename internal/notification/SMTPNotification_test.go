@@ -2,8 +2,10 @@ package notification
 
 import (
 	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/internal/probing"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestSMTPNotification_SendNotification(t *testing.T) {
@@ -31,3 +33,29 @@ func TestSMTPNotification_SendNotification(t *testing.T) {
 	err := notif.NotifyUp("dummyprobe", "100 minutes")
 	assert.NoError(t, err)
 }
+
+func TestSMTPNotification_RenderLatency(t *testing.T) {
+	target := &internal.SMTPNotificationTarget{
+		SMTPHost: "mail.smtpbucket.com",
+		SMTPPort: 8025,
+		From:     &internal.Mailbox{Name: "Dummy Sender", Email: "dummysender@mihp.com"},
+		To:       []*internal.Mailbox{{Name: "Dummy Target Notif", Email: "dummytargetnotif@mihp.com"}},
+	}
+	latency := &probing.LatencySummary{Period: probing.PeriodHour, Count: 60, P50: 120 * time.Millisecond, P90: 300 * time.Millisecond, P99: 2 * time.Second, Max: 3 * time.Second}
+	now := time.Now()
+
+	for _, status := range []string{probing.StatusDown, probing.StatusDegraded, probing.StatusUp, probing.StatusFlapping} {
+		notif := newSMTPStatusNotification(target, probing.NewProbeEventProcessor(nil), "dummyprobe", status, now, now.Add(-time.Hour), latency)
+		assert.Equal(t, latency.String(), notif.Latency)
+		subject, body, err := notif.Render()
+		assert.NoError(t, err)
+		assert.Contains(t, subject, "dummyprobe")
+		assert.Contains(t, body, "p50=120ms p90=300ms p99=2s max=3s of 60 runs", status)
+	}
+
+	notif := newSMTPStatusNotification(target, probing.NewProbeEventProcessor(nil), "dummyprobe", probing.StatusDown, now, now.Add(-time.Hour), nil)
+	_, body, err := notif.Render()
+	assert.NoError(t, err)
+	assert.Contains(t, body, "It's been up for 1h0m0s")
+	assert.NotContains(t, body, "latency")
+}
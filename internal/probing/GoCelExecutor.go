@@ -4,380 +4,619 @@ import (
 	"context"
 	"fmt"
 	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter"
 	"github.com/google/cel-go/interpreter/functions"
+	"github.com/google/cel-go/parser"
 	"github.com/newm4n/mihp/internal"
 	"github.com/newm4n/mihp/pkg/errors"
+	"github.com/newm4n/mihp/pkg/jsontool"
 	"github.com/sirupsen/logrus"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 	"reflect"
+	"sync"
 	"time"
 )
 
-func GoCelEvaluate(ctx context.Context, expression string, celContext internal.ProbeContext, expectReturnKind reflect.Kind) (output interface{}, err error) {
-	defer func() {
-		if err := recover(); err != nil {
-			err = fmt.Errorf("%w : panic occured and recoveredduring evaluating expression [%s] : got %v", errors.ErrEvalError, expression, err)
-			switch expectReturnKind {
-			case reflect.String:
-				output = ""
-			case reflect.Int:
-				output = int(0)
-			case reflect.Int8:
-				output = int8(0)
-			case reflect.Int16:
-				output = int16(0)
-			case reflect.Int32:
-				output = int32(0)
-			case reflect.Int64:
-				output = int64(0)
-			case reflect.Uint:
-				output = uint(0)
-			case reflect.Uint8:
-				output = uint8(0)
-			case reflect.Uint16:
-				output = uint16(0)
-			case reflect.Uint32:
-				output = uint32(0)
-			case reflect.Uint64:
-				output = uint64(0)
-			case reflect.Float32:
-				output = float32(0)
-			case reflect.Float64:
-				output = float64(0)
-			case reflect.Bool:
-				output = false
-			default:
-				output = nil
+const (
+	// contextVariable is the variable holding the whole probe context. The parser passes it implicitly
+	// as first argument of the context functions, so GetString("x") is evaluated as GetString(<context>, "x")
+	contextVariable = "mihp.context"
+
+	// MaxCachedPrograms is the number of compiled expressions kept in the program cache.
+	MaxCachedPrograms = 4096
+)
+
+var (
+	// contextFunctionArity is the number of arguments, as written in expressions, of the functions reading the probe context.
+	contextFunctionArity = map[string]int{
+		"IsDefined":       1,
+		"GetString":       1,
+		"GetInt":          1,
+		"GetUint":         1,
+		"GetFloat":        1,
+		"GetBool":         1,
+		"GetTime":         1,
+		"GetDuration":     1,
+		"GetLength":       1,
+		"GetStringElem":   2,
+		"GetIntElem":      2,
+		"GetUintElem":     2,
+		"GetFloatElem":    2,
+		"GetBoolElem":     2,
+		"GetTimeElem":     2,
+		"GetDurationElem": 2,
+	}
+
+	contextType = types.NewTypeValue("mihp.ProbeContext")
+
+	celEnvOnce sync.Once
+	celEnv     *cel.Env
+	celEnvErr  error
+
+	programCache      = make(map[string]cel.Program)
+	programCacheMutex sync.RWMutex
+)
+
+// contextValue wraps the probe context so it can be passed to the context functions as a CEL value.
+type contextValue struct {
+	pctx internal.ProbeContext
+}
+
+func (cv *contextValue) ConvertToNative(typeDesc reflect.Type) (interface{}, error) {
+	return nil, fmt.Errorf("probe context can not be converted to %s", typeDesc)
+}
+
+func (cv *contextValue) ConvertToType(typeVal ref.Type) ref.Val {
+	if typeVal == types.TypeType {
+		return contextType
+	}
+	return types.NewErr("probe context can not be converted to %s", typeVal)
+}
+
+func (cv *contextValue) Equal(other ref.Val) ref.Val {
+	return types.Bool(other == cv)
+}
+
+func (cv *contextValue) Type() ref.Type {
+	return contextType
+}
+
+func (cv *contextValue) Value() interface{} {
+	return cv.pctx
+}
+
+// contextActivation resolves expression variables directly from the probe context, without copying it.
+// Names starting with the this, prev and req aliases are resolved relative to the probe's requests.
+type contextActivation struct {
+	context *contextValue
+}
+
+func (ca *contextActivation) ResolveName(name string) (interface{}, bool) {
+	if name == contextVariable {
+		return ca.context, true
+	}
+	if val, ok := ca.context.pctx[name]; ok {
+		return val, true
+	}
+	return resolveScoped(ca.context.pctx, name)
+}
+
+func (ca *contextActivation) Parent() interpreter.Activation {
+	return nil
+}
+
+// CelEnvironment returns the environment shared by all expressions, it declares the functions and the probe context variable.
+// As the probe context keys are only known at evaluation time, the variables of an expression are declared by CheckExpression.
+func CelEnvironment() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		macros := make([]parser.Macro, 0, len(contextFunctionArity))
+		for function, arity := range contextFunctionArity {
+			macros = append(macros, parser.NewGlobalMacro(function, arity, expandContextFunction(function)))
+		}
+		celEnv, celEnvErr = cel.NewEnv(cel.Macros(macros...), cel.Declarations(celDeclarations()...))
+	})
+	return celEnv, celEnvErr
+}
+
+// celDeclarations declares every function usable in expressions, so calls to unknown functions, with the wrong number
+// of arguments or with arguments of the wrong type are reported when the expression is compiled.
+func celDeclarations() []*exprpb.Decl {
+	declarations := []*exprpb.Decl{decls.NewVar(contextVariable, decls.Dyn)}
+	function := func(name string, result *exprpb.Type, params ...[]*exprpb.Type) {
+		overloads := make([]*exprpb.Decl_FunctionDecl_Overload, 0, len(params))
+		for i, param := range params {
+			overloads = append(overloads, decls.NewOverload(fmt.Sprintf("%s_%d", name, i), param, result))
+		}
+		declarations = append(declarations, decls.NewFunction(name, overloads...))
+	}
+	args := func(types ...*exprpb.Type) []*exprpb.Type {
+		return types
+	}
+	textOrBytes := [][]*exprpb.Type{args(decls.String), args(decls.Bytes)}
+	stringList := decls.NewListType(decls.String)
+
+	// the context functions get the probe context as hidden first argument
+	contextResults := map[string]*exprpb.Type{
+		"IsDefined": decls.Bool, "GetString": decls.String, "GetInt": decls.Int, "GetUint": decls.Uint, "GetFloat": decls.Double,
+		"GetBool": decls.Bool, "GetTime": decls.Timestamp, "GetDuration": decls.Duration, "GetLength": decls.Int,
+		"GetStringElem": decls.String, "GetIntElem": decls.Int, "GetUintElem": decls.Uint, "GetFloatElem": decls.Double,
+		"GetBoolElem": decls.Bool, "GetTimeElem": decls.Timestamp, "GetDurationElem": decls.Duration,
+	}
+	for name, result := range contextResults {
+		if contextFunctionArity[name] == 2 {
+			function(name, result, args(decls.Dyn, decls.String, decls.Int))
+		} else {
+			function(name, result, args(decls.Dyn, decls.String))
+		}
+	}
+
+	function("GetJsonStringValue", decls.String, args(decls.String, decls.String))
+	function("GetJsonIntValue", decls.Int, args(decls.String, decls.String))
+	function("GetJsonUintValue", decls.Uint, args(decls.String, decls.String))
+	function("GetJsonFloatValue", decls.Double, args(decls.String, decls.String))
+	function("GetJsonBoolValue", decls.Bool, args(decls.String, decls.String))
+	function("GetJsonList", decls.NewListType(decls.Dyn), args(decls.String, decls.String))
+
+	function("RegexFind", decls.String, args(decls.String, decls.String))
+	function("RegexGroups", stringList, args(decls.String, decls.String))
+	for _, name := range []string{"Base64Encode", "Base64Decode", "Base64URLEncode", "Base64URLDecode", "URLEncode", "URLDecode", "HexEncode", "Sha1", "Sha256"} {
+		function(name, decls.String, textOrBytes...)
+	}
+	function("HexDecode", decls.Bytes, textOrBytes...)
+	for _, name := range []string{"HmacSha1", "HmacSha256"} {
+		function(name, decls.String, args(decls.String, decls.String), args(decls.String, decls.Bytes),
+			args(decls.Bytes, decls.String), args(decls.Bytes, decls.Bytes))
+	}
+	function("UUID", decls.String, args())
+	function("Now", decls.Timestamp, args())
+	function("FormatTime", decls.String, args(decls.Timestamp, decls.String))
+	function("ParseTime", decls.Timestamp, args(decls.String), args(decls.String, decls.String))
+	function("UnixTime", decls.Int, args(decls.Timestamp))
+	function("UnixMilli", decls.Int, args(decls.Timestamp))
+	function("FromUnixTime", decls.Timestamp, args(decls.Int))
+	function("FromUnixMilli", decls.Timestamp, args(decls.Int))
+	function("SchemaValid", decls.Bool, args(decls.String, decls.String))
+	function("SchemaErrors", stringList, args(decls.String, decls.String))
+	function("Env", decls.String, args(decls.String), args(decls.String, decls.String))
+
+	function("HtmlSelect", stringList, args(decls.String, decls.String), args(decls.String, decls.String, decls.String))
+	function("HtmlCount", decls.Int, args(decls.String, decls.String))
+	function("XPath", decls.Dyn, args(decls.String, decls.String), args(decls.String, decls.String, decls.NewMapType(decls.String, decls.String)))
+	return declarations
+}
+
+// CheckExpression type checks a parsed expression. The given variables are declared with their type,
+// the other identifiers it uses are declared dyn as their value is only known when evaluated.
+func CheckExpression(ast *cel.Ast, variables ...*exprpb.Decl) (*cel.Ast, error) {
+	env, err := CelEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	declared := map[string]bool{contextVariable: true}
+	declarations := make([]*exprpb.Decl, 0, len(variables))
+	for _, variable := range variables {
+		if !declared[variable.Name] {
+			declared[variable.Name] = true
+			declarations = append(declarations, variable)
+		}
+	}
+	for _, name := range expressionIdents(ast.Expr()) {
+		if !declared[name] {
+			declared[name] = true
+			declarations = append(declarations, decls.NewVar(name, decls.Dyn))
+		}
+	}
+	checkEnv, err := env.Extend(cel.Declarations(declarations...))
+	if err != nil {
+		return nil, err
+	}
+	checked, issues := checkEnv.Check(ast)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	return checked, nil
+}
+
+// expressionIdents returns the names of all identifiers used in an expression.
+func expressionIdents(expr *exprpb.Expr) []string {
+	idents := make([]string, 0)
+	var walk func(e *exprpb.Expr)
+	walk = func(e *exprpb.Expr) {
+		if e == nil {
+			return
+		}
+		switch kind := e.ExprKind.(type) {
+		case *exprpb.Expr_IdentExpr:
+			idents = append(idents, kind.IdentExpr.Name)
+		case *exprpb.Expr_SelectExpr:
+			walk(kind.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			walk(kind.CallExpr.Target)
+			for _, arg := range kind.CallExpr.Args {
+				walk(arg)
+			}
+		case *exprpb.Expr_ListExpr:
+			for _, elem := range kind.ListExpr.Elements {
+				walk(elem)
 			}
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range kind.StructExpr.Entries {
+				walk(entry.GetMapKey())
+				walk(entry.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			comp := kind.ComprehensionExpr
+			walk(comp.IterRange)
+			walk(comp.AccuInit)
+			walk(comp.LoopCondition)
+			walk(comp.LoopStep)
+			walk(comp.Result)
 		}
-	}()
+	}
+	walk(expr)
+	return idents
+}
+
+// expandContextFunction returns the macro that adds the probe context variable as the first argument of the function.
+func expandContextFunction(function string) parser.MacroExpander {
+	return func(eh parser.ExprHelper, target *exprpb.Expr, args []*exprpb.Expr) (*exprpb.Expr, *common.Error) {
+		return eh.GlobalCall(function, append([]*exprpb.Expr{eh.Ident(contextVariable)}, args...)...), nil
+	}
+}
+
+// CompileExpression returns the program of an expression, compiling it only if it is not in the program cache yet.
+func CompileExpression(expression string) (cel.Program, error) {
+	programCacheMutex.RLock()
+	prg, ok := programCache[expression]
+	programCacheMutex.RUnlock()
+	if ok {
+		return prg, nil
+	}
+
+	env, err := CelEnvironment()
+	if err != nil {
+		logrus.Errorf("error while creating go-cel environment got %s", err)
+		return nil, err
+	}
+	ast, issues := env.Parse(expression)
+	if issues != nil && issues.Err() != nil {
+		logrus.Errorf("error while compiling expression [%s] got %s", expression, issues.Err())
+		return nil, fmt.Errorf("%w : %s", issues.Err(), issues.String())
+	}
+	if _, err := CheckExpression(ast); err != nil {
+		logrus.Errorf("error while checking expression [%s] got %s", expression, err)
+		return nil, err
+	}
+	// the program is planned from the parsed expression, the checked one would resolve probe.x.req.y as
+	// field selections on the "probe" variable instead of the flat "probe.x.req.y" key of the probe context.
+	prg, err = env.Program(ast, celFunctions, celHelperFunctions, celMarkupFunctions)
+	if err != nil {
+		logrus.Errorf("error while creating program for expression [%s] got %s", expression, err)
+		return nil, err
+	}
 
+	programCacheMutex.Lock()
+	defer programCacheMutex.Unlock()
+	if len(programCache) >= MaxCachedPrograms {
+		programCache = make(map[string]cel.Program)
+	}
+	programCache[expression] = prg
+	return prg, nil
+}
+
+// GoCelEvaluate evaluates the expression against the probe context. The result must be of the expected kind,
+// unless reflect.Interface is expected, where any result convertible by NativeValue is returned.
+func GoCelEvaluate(ctx context.Context, expression string, celContext internal.ProbeContext, expectReturnKind reflect.Kind) (interface{}, error) {
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
 	if len(expression) == 0 {
 		return nil, nil
 	}
-	declarations, err := celContext.Declarations()
+	prg, err := CompileExpression(expression)
 	if err != nil {
-		logrus.Errorf("error while creating declaration got %s", err)
 		return nil, err
 	}
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
-	env, err := cel.NewEnv(
-		cel.Declarations(declarations...))
+	out, _, err := prg.Eval(&contextActivation{context: &contextValue{pctx: celContext}})
 	if err != nil {
-		logrus.Errorf("error while creating go-cel environment got %s", err)
+		logrus.Errorf("error while valuating program for expression [%s] got %s", expression, err)
 		return nil, err
 	}
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
-	ast, issues := env.Compile(expression)
-	if issues != nil && issues.Err() != nil {
-		logrus.Errorf("error while compiling expression [%s] got %s", expression, issues.Err())
-		return nil, fmt.Errorf("%w : %s", issues.Err(), issues.String())
+	if expectReturnKind == reflect.Interface {
+		return NativeValue(out)
 	}
-	if ctx.Err() != nil {
-		return nil, ctx.Err()
+	if reflect.TypeOf(out.Value()).Kind() != expectReturnKind {
+		return nil, fmt.Errorf("%w : expression \"%s\" expect returns %s but %s", errors.ErrEvalReturnInvalid, expression, expectReturnKind.String(), reflect.TypeOf(out.Value()).Kind().String())
 	}
+	return out.Value(), nil
+}
 
-	funcs := cel.Functions(
-		&functions.Overload{
-			Operator: "KeyExist_string_boolean",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to IsDefined", s1.Type())
-				}
-				if _, ok := celContext[s1.Value().(string)]; ok {
-					return types.Bool(true)
-				}
-				return types.Bool(false)
-			},
-		},
-		&functions.Overload{
-			Operator: "GetString_string_string",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetString", s1.Type())
-				}
-				if strItv, ok := celContext[s1.Value().(string)]; ok {
-					return types.String(strItv.(string))
-				}
-				return types.String("")
-			},
-		},
-		&functions.Overload{
-			Operator: "GetInt_string_int",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetInt", s1.Type())
-				}
-				if intItv, ok := celContext[s1.Value().(string)]; ok {
-					return types.Int(intItv.(int))
-				}
-				return types.Int(0)
-			},
-		},
-		&functions.Overload{
-			Operator: "GetUint_string_uint",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetUint", s1.Type())
-				}
-				if uintItv, ok := celContext[s1.Value().(string)]; ok {
-					return types.Uint(uintItv.(uint))
-				}
-				return types.Uint(0)
-			},
-		},
-		&functions.Overload{
-			Operator: "GetFloat_string_float",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetFloat", s1.Type())
-				}
-				if floatItv, ok := celContext[s1.Value().(string)]; ok {
-					return types.Double(floatItv.(float64))
-				}
-				return types.Double(0)
-			},
-		},
-		&functions.Overload{
-			Operator: "GetBool_string_bool",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetBool", s1.Type())
-				}
-				if boolItv, ok := celContext[s1.Value().(string)]; ok {
-					return types.Bool(boolItv.(bool))
-				}
-				return types.Bool(false)
-			},
-		},
-		&functions.Overload{
-			Operator: "GetTime_string_time",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetTime", s1.Type())
-				}
-				if timeItv, ok := celContext[s1.Value().(string)]; ok {
-					return types.Timestamp{timeItv.(time.Time)}
-				}
-				return types.Timestamp{time.Now()}
-			},
-		},
-		&functions.Overload{
-			Operator: "GetDuration_string_duration",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetDuration", s1.Type())
-				}
-				if durItv, ok := celContext[s1.Value().(string)]; ok {
-					return types.Duration{durItv.(time.Duration)}
-				}
-				return types.Duration{0}
-			},
-		},
-		&functions.Overload{
-			Operator: "GetLength_string_int",
-			Unary: func(value ref.Val) ref.Val {
-				s1, ok := value.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetLength", s1.Type())
-				}
-				if durItv, ok := celContext[s1.Value().(string)]; ok {
-					val := reflect.ValueOf(durItv)
-					if val.Type().Kind() == reflect.Slice || val.Type().Kind() == reflect.Array {
-						return types.Int(val.Len())
-					}
-				}
-				return types.Int(0)
-			},
+// contextValueOverload creates the overload of a function reading a single value of the probe context by its key.
+func contextValueOverload(function string, get func(pctx internal.ProbeContext, key string) ref.Val) *functions.Overload {
+	return &functions.Overload{
+		Operator: function,
+		Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+			cv, ok := lhs.(*contextValue)
+			if !ok {
+				return types.NewErr("probe context is not available to %s", function)
+			}
+			s1, ok := rhs.(types.String)
+			if !ok {
+				return types.ValOrErr(rhs, "unexpected type '%v' passed to %s", rhs.Type(), function)
+			}
+			return get(cv.pctx, string(s1))
 		},
-		&functions.Overload{
-			Operator: "GetStringElem_string_int_string",
-			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
-				s1, ok := lhs.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetStringElem 1st Argument", s1.Type())
-				}
-				s2, ok := rhs.(types.Int)
-				if !ok {
-					return types.ValOrErr(s2, "unexpected type '%v' passed to GetStringElem 2nd Argument", s2.Type())
-				}
-				if strArrItv, ok := celContext[s1.Value().(string)]; ok {
-					strArrTyp := reflect.TypeOf(strArrItv)
-					strArrVal := reflect.ValueOf(strArrItv)
-					if strArrTyp.Elem().Kind() == reflect.String {
-						return types.String(strArrVal.Index(int(s2.Value().(int64))).String())
-					}
+	}
+}
+
+// contextElemOverload creates the overload of a function reading an element of an array value of the probe context.
+// The element is converted if the array elements are accepted, otherwise zero is returned.
+func contextElemOverload(function string, accept func(elem reflect.Type) bool, convert func(elem reflect.Value) ref.Val, zero func() ref.Val) *functions.Overload {
+	return &functions.Overload{
+		Operator: function,
+		Function: func(values ...ref.Val) ref.Val {
+			if len(values) != 3 {
+				return types.NewErr("unexpected number of arguments passed to %s", function)
+			}
+			cv, ok := values[0].(*contextValue)
+			if !ok {
+				return types.NewErr("probe context is not available to %s", function)
+			}
+			s1, ok := values[1].(types.String)
+			if !ok {
+				return types.ValOrErr(values[1], "unexpected type '%v' passed to %s 1st Argument", values[1].Type(), function)
+			}
+			s2, ok := values[2].(types.Int)
+			if !ok {
+				return types.ValOrErr(values[2], "unexpected type '%v' passed to %s 2nd Argument", values[2].Type(), function)
+			}
+			if arrItv, ok := cv.pctx[string(s1)]; ok {
+				arrTyp := reflect.TypeOf(arrItv)
+				if (arrTyp.Kind() == reflect.Slice || arrTyp.Kind() == reflect.Array) && accept(arrTyp.Elem()) {
+					return convert(reflect.ValueOf(arrItv).Index(int(s2)))
 				}
-				return types.String("")
-			},
+			}
+			return zero()
 		},
+	}
+}
 
+func isKind(kinds ...reflect.Kind) func(elem reflect.Type) bool {
+	return func(elem reflect.Type) bool {
+		for _, kind := range kinds {
+			if elem.Kind() == kind {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+
+	celFunctions = cel.Functions(
+		contextValueOverload("IsDefined", func(pctx internal.ProbeContext, key string) ref.Val {
+			_, ok := pctx[key]
+			return types.Bool(ok)
+		}),
+		contextValueOverload("GetString", func(pctx internal.ProbeContext, key string) ref.Val {
+			if strItv, ok := pctx[key]; ok {
+				return types.String(strItv.(string))
+			}
+			return types.String("")
+		}),
+		contextValueOverload("GetInt", func(pctx internal.ProbeContext, key string) ref.Val {
+			if intItv, ok := pctx[key]; ok {
+				return types.Int(intItv.(int))
+			}
+			return types.Int(0)
+		}),
+		contextValueOverload("GetUint", func(pctx internal.ProbeContext, key string) ref.Val {
+			if uintItv, ok := pctx[key]; ok {
+				return types.Uint(uintItv.(uint))
+			}
+			return types.Uint(0)
+		}),
+		contextValueOverload("GetFloat", func(pctx internal.ProbeContext, key string) ref.Val {
+			if floatItv, ok := pctx[key]; ok {
+				return types.Double(floatItv.(float64))
+			}
+			return types.Double(0)
+		}),
+		contextValueOverload("GetBool", func(pctx internal.ProbeContext, key string) ref.Val {
+			if boolItv, ok := pctx[key]; ok {
+				return types.Bool(boolItv.(bool))
+			}
+			return types.Bool(false)
+		}),
+		contextValueOverload("GetTime", func(pctx internal.ProbeContext, key string) ref.Val {
+			if timeItv, ok := pctx[key]; ok {
+				return types.Timestamp{Time: timeItv.(time.Time)}
+			}
+			return types.Timestamp{Time: time.Now()}
+		}),
+		contextValueOverload("GetDuration", func(pctx internal.ProbeContext, key string) ref.Val {
+			if durItv, ok := pctx[key]; ok {
+				return types.Duration{Duration: durItv.(time.Duration)}
+			}
+			return types.Duration{}
+		}),
+		contextValueOverload("GetLength", func(pctx internal.ProbeContext, key string) ref.Val {
+			if arrItv, ok := pctx[key]; ok {
+				val := reflect.ValueOf(arrItv)
+				if val.Type().Kind() == reflect.Slice || val.Type().Kind() == reflect.Array {
+					return types.Int(val.Len())
+				}
+			}
+			return types.Int(0)
+		}),
+		contextElemOverload("GetStringElem", isKind(reflect.String),
+			func(elem reflect.Value) ref.Val { return types.String(elem.String()) },
+			func() ref.Val { return types.String("") }),
+		contextElemOverload("GetIntElem", isKind(reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64),
+			func(elem reflect.Value) ref.Val { return types.Int(elem.Int()) },
+			func() ref.Val { return types.Int(0) }),
+		contextElemOverload("GetUintElem", isKind(reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64),
+			func(elem reflect.Value) ref.Val { return types.Uint(elem.Uint()) },
+			func() ref.Val { return types.Uint(0) }),
+		contextElemOverload("GetFloatElem", isKind(reflect.Float32, reflect.Float64),
+			func(elem reflect.Value) ref.Val { return types.Double(elem.Float()) },
+			func() ref.Val { return types.Double(0) }),
+		contextElemOverload("GetBoolElem", isKind(reflect.Bool),
+			func(elem reflect.Value) ref.Val { return types.Bool(elem.Bool()) },
+			func() ref.Val { return types.Bool(false) }),
+		contextElemOverload("GetTimeElem", func(elem reflect.Type) bool { return elem == timeType },
+			func(elem reflect.Value) ref.Val { return types.Timestamp{Time: elem.Interface().(time.Time)} },
+			func() ref.Val { return types.Timestamp{Time: time.Now()} }),
+		contextElemOverload("GetDurationElem", func(elem reflect.Type) bool { return elem == durationType },
+			func(elem reflect.Value) ref.Val { return types.Duration{Duration: elem.Interface().(time.Duration)} },
+			func() ref.Val { return types.Duration{} }),
 		&functions.Overload{
-			Operator: "GetIntElem_string_int_int",
+			Operator: "GetJsonStringValue",
 			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
-				s1, ok := lhs.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetIntElem 1st Argument", s1.Type())
-				}
-				s2, ok := rhs.(types.Int)
-				if !ok {
-					return types.ValOrErr(s2, "unexpected type '%v' passed to GetIntElem 2nd Argument", s2.Type())
+				jsonData, path, errVal := jsonArguments("GetJsonStringValue", lhs, rhs)
+				if errVal != nil {
+					return errVal
 				}
-				if strArrItv, ok := celContext[s1.Value().(string)]; ok {
-					strArrTyp := reflect.TypeOf(strArrItv)
-					strArrVal := reflect.ValueOf(strArrItv)
-					switch strArrTyp.Elem().Kind() {
-					case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-						return types.Int(strArrVal.Index(int(s2.Value().(int64))).Int())
-					}
+				str, err := jsonData.GetString(path)
+				if err != nil {
+					return types.NewErr("GetJsonStringValue got %s", err.Error())
 				}
-				return types.Int(0)
+				return types.String(str)
 			},
 		},
 		&functions.Overload{
-			Operator: "GetUintElem_string_int_uint",
+			Operator: "GetJsonIntValue",
 			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
-				s1, ok := lhs.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetUintElem 1st Argument", s1.Type())
+				jsonData, path, errVal := jsonArguments("GetJsonIntValue", lhs, rhs)
+				if errVal != nil {
+					return errVal
 				}
-				s2, ok := rhs.(types.Int)
-				if !ok {
-					return types.ValOrErr(s2, "unexpected type '%v' passed to GetUintElem 2nd Argument", s2.Type())
+				i, err := jsonData.GetInt(path)
+				if err != nil {
+					return types.NewErr("GetJsonIntValue got %s", err.Error())
 				}
-				if strArrItv, ok := celContext[s1.Value().(string)]; ok {
-					strArrTyp := reflect.TypeOf(strArrItv)
-					strArrVal := reflect.ValueOf(strArrItv)
-					switch strArrTyp.Elem().Kind() {
-					case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-						return types.Uint(strArrVal.Index(int(s2.Value().(int64))).Uint())
-					}
-				}
-				return types.Uint(0)
+				return types.Int(i)
 			},
 		},
 		&functions.Overload{
-			Operator: "GetFloatElem_string_int_float",
+			Operator: "GetJsonUintValue",
 			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
-				s1, ok := lhs.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetFloatElem 1st Argument", s1.Type())
+				jsonData, path, errVal := jsonArguments("GetJsonUintValue", lhs, rhs)
+				if errVal != nil {
+					return errVal
 				}
-				s2, ok := rhs.(types.Int)
-				if !ok {
-					return types.ValOrErr(s2, "unexpected type '%v' passed to GetFloatElem 2nd Argument", s2.Type())
+				i, err := jsonData.GetInt(path)
+				if err != nil {
+					return types.NewErr("GetJsonUintValue got %s", err.Error())
 				}
-				if strArrItv, ok := celContext[s1.Value().(string)]; ok {
-					strArrTyp := reflect.TypeOf(strArrItv)
-					strArrVal := reflect.ValueOf(strArrItv)
-					switch strArrTyp.Elem().Kind() {
-					case reflect.Float32, reflect.Float64:
-						return types.Double(strArrVal.Index(int(s2.Value().(int64))).Float())
-					}
+				if i < 0 {
+					return types.NewErr("GetJsonUintValue got negative value %d at %s", i, path)
 				}
-				return types.Double(0)
+				return types.Uint(i)
 			},
 		},
 		&functions.Overload{
-			Operator: "GetBoolElem_string_int_bool",
+			Operator: "GetJsonFloatValue",
 			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
-				s1, ok := lhs.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetBoolElem 1st Argument", s1.Type())
-				}
-				s2, ok := rhs.(types.Int)
-				if !ok {
-					return types.ValOrErr(s2, "unexpected type '%v' passed to GetBoolElem 2nd Argument", s2.Type())
+				jsonData, path, errVal := jsonArguments("GetJsonFloatValue", lhs, rhs)
+				if errVal != nil {
+					return errVal
 				}
-				if strArrItv, ok := celContext[s1.Value().(string)]; ok {
-					strArrTyp := reflect.TypeOf(strArrItv)
-					strArrVal := reflect.ValueOf(strArrItv)
-					if strArrTyp.Elem().Kind() == reflect.Bool {
-						return types.Bool(strArrVal.Index(int(s2.Value().(int64))).Bool())
-					}
+				f, err := jsonData.GetFloat(path)
+				if err != nil {
+					return types.NewErr("GetJsonFloatValue got %s", err.Error())
 				}
-				return types.Bool(false)
+				return types.Double(f)
 			},
 		},
 		&functions.Overload{
-			Operator: "GetTimeElem_string_int_time",
+			Operator: "GetJsonBoolValue",
 			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
-				s1, ok := lhs.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetTimeElem 1st Argument", s1.Type())
+				jsonData, path, errVal := jsonArguments("GetJsonBoolValue", lhs, rhs)
+				if errVal != nil {
+					return errVal
 				}
-				s2, ok := rhs.(types.Int)
-				if !ok {
-					return types.ValOrErr(s2, "unexpected type '%v' passed to GetTimeElem 2nd Argument", s2.Type())
+				b, err := jsonData.GetBool(path)
+				if err != nil {
+					return types.NewErr("GetJsonBoolValue got %s", err.Error())
 				}
-				if strArrItv, ok := celContext[s1.Value().(string)]; ok {
-					strArrTyp := reflect.TypeOf(strArrItv)
-					strArrVal := reflect.ValueOf(strArrItv)
-					if strArrTyp.Elem().Kind() == reflect.Struct && strArrTyp.Elem().String() == "time.Time" {
-						return types.Timestamp{strArrVal.Index(int(s2.Value().(int64))).Interface().(time.Time)}
-					}
-				}
-				return types.Timestamp{time.Now()}
+				return types.Bool(b)
 			},
 		},
 		&functions.Overload{
-			Operator: "GetDurationElem_string_int_duration",
+			Operator: "GetJsonList",
 			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
-				s1, ok := lhs.(types.String)
-				if !ok {
-					return types.ValOrErr(s1, "unexpected type '%v' passed to GetDurationElem 1st Argument", s1.Type())
-				}
-				s2, ok := rhs.(types.Int)
-				if !ok {
-					return types.ValOrErr(s2, "unexpected type '%v' passed to GetDurationElem 2nd Argument", s2.Type())
+				jsonData, path, errVal := jsonArguments("GetJsonList", lhs, rhs)
+				if errVal != nil {
+					return errVal
 				}
-				if strArrItv, ok := celContext[s1.Value().(string)]; ok {
-					strArrTyp := reflect.TypeOf(strArrItv)
-					strArrVal := reflect.ValueOf(strArrItv)
-					if strArrTyp.Elem().Kind() == reflect.Struct && strArrTyp.Elem().String() == "time.Deadline" {
-						return types.Duration{strArrVal.Index(int(s2.Value().(int64))).Interface().(time.Duration)}
-					}
+				values, err := jsonData.Query(path)
+				if err != nil {
+					return types.NewErr("GetJsonList got %s", err.Error())
 				}
-				return types.Duration{time.Duration(0)}
+				return types.DefaultTypeAdapter.NativeToValue(values)
 			},
 		},
 	)
+)
 
-	prg, err := env.Program(ast, funcs)
-	if err != nil {
-		logrus.Errorf("error while creating program for expression [%s] got %s", expression, err)
-		return nil, issues.Err()
+var (
+	nativeListTypes = []reflect.Type{
+		reflect.TypeOf([]string{}),
+		reflect.TypeOf([]int{}),
+		reflect.TypeOf([]float64{}),
+		reflect.TypeOf([]bool{}),
 	}
-	toEval := make(map[string]interface{})
-	for k, v := range celContext {
-		toEval[k] = v
+)
+
+// NativeValue converts an expression result into the go type used in the probe context,
+// such as int instead of int64 and []string instead of a CEL list.
+func NativeValue(val ref.Val) (interface{}, error) {
+	switch val.Type() {
+	case types.IntType:
+		return int(val.(types.Int)), nil
+	case types.UintType:
+		return uint(val.(types.Uint)), nil
+	case types.DoubleType, types.StringType, types.BytesType, types.BoolType, types.TimestampType, types.DurationType:
+		return val.Value(), nil
+	case types.ListType:
+		for _, typ := range nativeListTypes {
+			if native, err := val.ConvertToNative(typ); err == nil {
+				return native, nil
+			}
+		}
+		return nil, fmt.Errorf("%w : list elements must all be string, int, double or bool", errors.ErrEvalReturnInvalid)
+	default:
+		return nil, fmt.Errorf("%w : unsupported result type %s", errors.ErrEvalReturnInvalid, val.Type().TypeName())
 	}
-	out, _, err := prg.Eval(toEval)
-	if err != nil {
-		logrus.Errorf("error while valuating program for expression [%s] got %s", expression, err)
-		return nil, issues.Err()
+}
+
+// jsonArguments parse the json document and path arguments of the GetJson*Value functions.
+func jsonArguments(function string, lhs ref.Val, rhs ref.Val) (*jsontool.JSONData, string, ref.Val) {
+	s1, ok := lhs.(types.String)
+	if !ok {
+		return nil, "", types.ValOrErr(lhs, "unexpected type '%v' passed to %s 1st Argument", lhs.Type(), function)
 	}
-	if ctx.Err() != nil {
-		return nil, ctx.Err()
+	s2, ok := rhs.(types.String)
+	if !ok {
+		return nil, "", types.ValOrErr(rhs, "unexpected type '%v' passed to %s 2nd Argument", rhs.Type(), function)
 	}
-	if reflect.TypeOf(out.Value()).Kind() != expectReturnKind {
-		return nil, fmt.Errorf("%w : expression \"%s\" expect returns %s but %s", errors.ErrEvalReturnInvalid, expression, expectReturnKind.String(), reflect.TypeOf(out.Value()).Kind().String())
+	jsonData, err := jsontool.NewJSONData([]byte(s1.Value().(string)))
+	if err != nil {
+		return nil, "", types.NewErr("%s can not parse json. got %s", function, err.Error())
 	}
-	return out.Value(), nil
+	return jsonData, s2.Value().(string), nil
 }
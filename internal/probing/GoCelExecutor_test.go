@@ -2,9 +2,11 @@ package probing
 
 import (
 	"context"
+	"fmt"
 	"github.com/newm4n/mihp/internal"
 	"github.com/stretchr/testify/assert"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -74,3 +76,175 @@ func TestGoCelEvaluateNonExistBool(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, out.(bool))
 }
+
+func TestGoCelEvaluateJson(t *testing.T) {
+	pc := internal.NewProbeContext()
+	pc["resp.body"] = `{"data":{"token":"abc","count":3,"ratio":0.5,"ok":true,"items":[{"id":7}]}}`
+
+	out, err := GoCelEvaluate(context.Background(), `GetJsonStringValue(GetString("resp.body"), "data.token")`, pc, reflect.String)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", out.(string))
+
+	out, err = GoCelEvaluate(context.Background(), `GetJsonIntValue(GetString("resp.body"), "data.items[0].id")`, pc, reflect.Int64)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), out.(int64))
+
+	out, err = GoCelEvaluate(context.Background(), `GetJsonUintValue(GetString("resp.body"), "data.count") == uint(3)`, pc, reflect.Bool)
+	assert.NoError(t, err)
+	assert.True(t, out.(bool))
+
+	out, err = GoCelEvaluate(context.Background(), `GetJsonFloatValue(GetString("resp.body"), "data.ratio") == 0.5 && GetJsonBoolValue(GetString("resp.body"), "data.ok")`, pc, reflect.Bool)
+	assert.NoError(t, err)
+	assert.True(t, out.(bool))
+
+	_, err = GoCelEvaluate(context.Background(), `GetJsonStringValue("not a json", "data.token") == ""`, pc, reflect.Bool)
+	assert.Error(t, err)
+
+	_, err = GoCelEvaluate(context.Background(), `GetJsonStringValue(GetString("resp.body"), "data.missing") == ""`, pc, reflect.Bool)
+	assert.Error(t, err)
+}
+
+func TestGoCelEvaluateJsonList(t *testing.T) {
+	pc := internal.NewProbeContext()
+	pc["resp.body"] = `{"items":[{"id":"a","price":1.5,"status":"ok"},{"id":"b","price":20,"status":"out"},{"id":"c","price":3,"status":"ok"}]}`
+
+	out, err := GoCelEvaluate(context.Background(), `GetJsonList(resp.body, "items[*].id")`, pc, reflect.Interface)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, out)
+
+	out, err = GoCelEvaluate(context.Background(), `GetJsonList(resp.body, "$.items[?(@.status=='ok')].price")`, pc, reflect.Interface)
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{1.5, 3}, out)
+
+	out, err = GoCelEvaluate(context.Background(), `GetJsonList(resp.body, "items[*].price").all(p, p > 0.0) && size(GetJsonList(resp.body, "items[?(@.price > 100)]")) == 0`, pc, reflect.Bool)
+	assert.NoError(t, err)
+	assert.True(t, out.(bool))
+
+	out, err = GoCelEvaluate(context.Background(), `"b" in GetJsonList(resp.body, "..id") && GetJsonIntValue(resp.body, "items.length()") == 3`, pc, reflect.Bool)
+	assert.NoError(t, err)
+	assert.True(t, out.(bool))
+
+	_, err = GoCelEvaluate(context.Background(), `GetJsonList(resp.body, "items[?(@.price >)]")`, pc, reflect.Interface)
+	assert.Error(t, err)
+}
+
+func TestGoCelEvaluateAnyKind(t *testing.T) {
+	pctx := internal.NewProbeContext()
+	pctx["probe.x.count"] = 2
+
+	out, err := GoCelEvaluate(context.Background(), `probe.x.count + 1`, pctx, reflect.Interface)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, out)
+
+	out, err = GoCelEvaluate(context.Background(), `["a", "b"]`, pctx, reflect.Interface)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, out)
+
+	out, err = GoCelEvaluate(context.Background(), `duration("5s")`, pctx, reflect.Interface)
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, out)
+
+	_, err = GoCelEvaluate(context.Background(), `{"a": 1}`, pctx, reflect.Interface)
+	assert.Error(t, err)
+}
+
+func TestCompileExpressionCache(t *testing.T) {
+	prg1, err := CompileExpression(`GetInt("probe.x.count") > 1`)
+	assert.NoError(t, err)
+	prg2, err := CompileExpression(`GetInt("probe.x.count") > 1`)
+	assert.NoError(t, err)
+	assert.True(t, prg1 == prg2)
+
+	_, err = CompileExpression(`GetInt("probe.x.count") >`)
+	assert.Error(t, err)
+	_, err = GoCelEvaluate(context.Background(), `NoSuchFunction("probe.x.count")`, internal.NewProbeContext(), reflect.Bool)
+	assert.Error(t, err)
+}
+
+func TestCompileExpressionTypeCheck(t *testing.T) {
+	for _, expression := range []string{
+		`GetStrng("probe.x.body") == "x"`,
+		`GetInt("probe.x.count", 3) == 200`,
+		`GetInt("probe.x.count") == "200"`,
+		`RegexFind(probe.x.body) == ""`,
+		`UnixTime("2021-01-01") > 0`,
+	} {
+		_, err := CompileExpression(expression)
+		assert.Error(t, err, expression)
+	}
+
+	for _, expression := range []string{
+		`probe.x.req.y.resp.code == 200 && this.resp.body.size > 0`,
+		`GetStringElem("probe.x.list", 0) == RegexFind(req["y"].resp.body, "[a-z]+")`,
+		`HmacSha256(HexDecode("00ff"), "message") != "" && [1, 2].exists(i, i > 1)`,
+	} {
+		_, err := CompileExpression(expression)
+		assert.NoError(t, err, expression)
+	}
+}
+
+func TestGoCelEvaluateConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pctx := internal.NewProbeContext()
+			pctx["probe.x.count"] = i
+			out, err := GoCelEvaluate(context.Background(), `GetInt("probe.x.count") + probe.x.count`, pctx, reflect.Int64)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(i*2), out)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func benchmarkContext() internal.ProbeContext {
+	pctx := internal.NewProbeContext()
+	for i := 0; i < 100; i++ {
+		pctx[fmt.Sprintf("probe.Bench.req.r%d.resp.code", i)] = 200
+		pctx[fmt.Sprintf("probe.Bench.req.r%d.resp.body", i)] = `{"data":{"token":"abc"}}`
+	}
+	return pctx
+}
+
+func BenchmarkGoCelEvaluate(b *testing.B) {
+	pctx := benchmarkContext()
+	expr := `probe.Bench.req.r10.resp.code == 200 && GetJsonStringValue(GetString("probe.Bench.req.r10.resp.body"), "data.token") == "abc"`
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GoCelEvaluate(context.Background(), expr, pctx, reflect.Bool); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGoCelEvaluateParallel(b *testing.B) {
+	pctx := benchmarkContext()
+	expr := `probe.Bench.req.r10.resp.code == 200 && IsDefined("probe.Bench.req.r99.resp.code")`
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := GoCelEvaluate(context.Background(), expr, pctx, reflect.Bool); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompileExpression(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		// a distinct expression on each iteration always misses the cache
+		if _, err := CompileExpression(fmt.Sprintf(`probe.Bench.req.r10.resp.code == %d`, i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGoCelEvaluatePanic(t *testing.T) {
+	pc := internal.NewProbeContext()
+	pc["probe.x.code"] = "200"
+	out, err := GoCelEvaluate(context.Background(), `GetInt("probe.x.code") == 200`, pc, reflect.Bool)
+	assert.Error(t, err)
+	assert.Nil(t, out)
+}
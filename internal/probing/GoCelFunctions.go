@@ -0,0 +1,373 @@
+package probing
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	"github.com/google/uuid"
+	"github.com/newm4n/mihp/internal"
+	"hash"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxCachedRegexes is the number of compiled patterns kept in the regex cache.
+	MaxCachedRegexes = 4096
+)
+
+var (
+	regexCache      = make(map[string]*regexp.Regexp)
+	regexCacheMutex sync.RWMutex
+
+	// timeLayouts are the layout names accepted by FormatTime and ParseTime beside Go layouts such as "2006-01-02".
+	timeLayouts = map[string]string{
+		"ANSIC":       time.ANSIC,
+		"RFC822":      time.RFC822,
+		"RFC822Z":     time.RFC822Z,
+		"RFC850":      time.RFC850,
+		"RFC1123":     time.RFC1123,
+		"RFC1123Z":    time.RFC1123Z,
+		"RFC3339":     time.RFC3339,
+		"RFC3339Nano": time.RFC3339Nano,
+		"HTTP":        "Mon, 02 Jan 2006 15:04:05 GMT",
+	}
+
+	// celHelperFunctions are the functions not reading the probe context: regex, encoding, hashing, time, json schema and environment.
+	celHelperFunctions = cel.Functions(
+		&functions.Overload{
+			Operator: "RegexFind",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				str, re, errVal := regexArguments("RegexFind", lhs, rhs)
+				if errVal != nil {
+					return errVal
+				}
+				return types.String(re.FindString(str))
+			},
+		},
+		&functions.Overload{
+			Operator: "RegexGroups",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				str, re, errVal := regexArguments("RegexGroups", lhs, rhs)
+				if errVal != nil {
+					return errVal
+				}
+				groups := make([]string, 0)
+				if match := re.FindStringSubmatch(str); match != nil {
+					groups = match[1:]
+				}
+				return types.NewStringList(types.DefaultTypeAdapter, groups)
+			},
+		},
+		bytesOverload("Base64Encode", func(data []byte) ref.Val {
+			return types.String(base64.StdEncoding.EncodeToString(data))
+		}),
+		bytesOverload("Base64Decode", func(data []byte) ref.Val {
+			decoded, err := base64.StdEncoding.DecodeString(string(data))
+			if err != nil {
+				return types.NewErr("Base64Decode got %s", err.Error())
+			}
+			return types.String(decoded)
+		}),
+		bytesOverload("Base64URLEncode", func(data []byte) ref.Val {
+			return types.String(base64.RawURLEncoding.EncodeToString(data))
+		}),
+		bytesOverload("Base64URLDecode", func(data []byte) ref.Val {
+			decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(string(data), "="))
+			if err != nil {
+				return types.NewErr("Base64URLDecode got %s", err.Error())
+			}
+			return types.String(decoded)
+		}),
+		bytesOverload("URLEncode", func(data []byte) ref.Val {
+			return types.String(url.QueryEscape(string(data)))
+		}),
+		bytesOverload("URLDecode", func(data []byte) ref.Val {
+			decoded, err := url.QueryUnescape(string(data))
+			if err != nil {
+				return types.NewErr("URLDecode got %s", err.Error())
+			}
+			return types.String(decoded)
+		}),
+		bytesOverload("HexEncode", func(data []byte) ref.Val {
+			return types.String(hex.EncodeToString(data))
+		}),
+		bytesOverload("HexDecode", func(data []byte) ref.Val {
+			decoded, err := hex.DecodeString(string(data))
+			if err != nil {
+				return types.NewErr("HexDecode got %s", err.Error())
+			}
+			return types.Bytes(decoded)
+		}),
+		bytesOverload("Sha1", func(data []byte) ref.Val {
+			sum := sha1.Sum(data)
+			return types.String(hex.EncodeToString(sum[:]))
+		}),
+		bytesOverload("Sha256", func(data []byte) ref.Val {
+			sum := sha256.Sum256(data)
+			return types.String(hex.EncodeToString(sum[:]))
+		}),
+		hmacOverload("HmacSha1", sha1.New),
+		hmacOverload("HmacSha256", sha256.New),
+		&functions.Overload{
+			Operator: "UUID",
+			Function: func(values ...ref.Val) ref.Val {
+				if len(values) != 0 {
+					return types.NewErr("unexpected number of arguments passed to UUID")
+				}
+				return types.String(uuid.New().String())
+			},
+		},
+		&functions.Overload{
+			Operator: "Now",
+			Function: func(values ...ref.Val) ref.Val {
+				if len(values) != 0 {
+					return types.NewErr("unexpected number of arguments passed to Now")
+				}
+				return types.Timestamp{Time: time.Now()}
+			},
+		},
+		&functions.Overload{
+			Operator: "FormatTime",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				t, ok := lhs.(types.Timestamp)
+				if !ok {
+					return types.ValOrErr(lhs, "unexpected type '%v' passed to FormatTime 1st Argument", lhs.Type())
+				}
+				layout, ok := rhs.(types.String)
+				if !ok {
+					return types.ValOrErr(rhs, "unexpected type '%v' passed to FormatTime 2nd Argument", rhs.Type())
+				}
+				if string(layout) == "HTTP" {
+					t.Time = t.Time.UTC()
+				}
+				return types.String(t.Time.Format(timeLayout(string(layout))))
+			},
+		},
+		&functions.Overload{
+			Operator: "ParseTime",
+			Unary: func(value ref.Val) ref.Val {
+				return parseTime(value, types.String("RFC3339"))
+			},
+			Binary: parseTime,
+		},
+		&functions.Overload{
+			Operator: "UnixTime",
+			Unary: func(value ref.Val) ref.Val {
+				t, ok := value.(types.Timestamp)
+				if !ok {
+					return types.ValOrErr(value, "unexpected type '%v' passed to UnixTime", value.Type())
+				}
+				return types.Int(t.Time.Unix())
+			},
+		},
+		&functions.Overload{
+			Operator: "UnixMilli",
+			Unary: func(value ref.Val) ref.Val {
+				t, ok := value.(types.Timestamp)
+				if !ok {
+					return types.ValOrErr(value, "unexpected type '%v' passed to UnixMilli", value.Type())
+				}
+				return types.Int(t.Time.UnixNano() / int64(time.Millisecond))
+			},
+		},
+		&functions.Overload{
+			Operator: "FromUnixTime",
+			Unary: func(value ref.Val) ref.Val {
+				sec, ok := value.(types.Int)
+				if !ok {
+					return types.ValOrErr(value, "unexpected type '%v' passed to FromUnixTime", value.Type())
+				}
+				return types.Timestamp{Time: time.Unix(int64(sec), 0).UTC()}
+			},
+		},
+		&functions.Overload{
+			Operator: "FromUnixMilli",
+			Unary: func(value ref.Val) ref.Val {
+				milli, ok := value.(types.Int)
+				if !ok {
+					return types.ValOrErr(value, "unexpected type '%v' passed to FromUnixMilli", value.Type())
+				}
+				return types.Timestamp{Time: time.Unix(0, int64(milli)*int64(time.Millisecond)).UTC()}
+			},
+		},
+		&functions.Overload{
+			Operator: "SchemaValid",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				violations, errVal := schemaViolations("SchemaValid", lhs, rhs)
+				if errVal != nil {
+					return errVal
+				}
+				return types.Bool(len(violations) == 0)
+			},
+		},
+		&functions.Overload{
+			Operator: "SchemaErrors",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				violations, errVal := schemaViolations("SchemaErrors", lhs, rhs)
+				if errVal != nil {
+					return errVal
+				}
+				return types.NewStringList(types.DefaultTypeAdapter, violations)
+			},
+		},
+		&functions.Overload{
+			Operator: "Env",
+			Unary: func(value ref.Val) ref.Val {
+				return env(value, types.String(""))
+			},
+			Binary: env,
+		},
+	)
+)
+
+// timeLayout returns the Go layout of a layout name, or the layout itself if it is not a known name.
+func timeLayout(layout string) string {
+	if goLayout, ok := timeLayouts[layout]; ok {
+		return goLayout
+	}
+	return layout
+}
+
+// bytesOverload creates the overload of a single argument function working on the bytes of a string or bytes argument.
+func bytesOverload(function string, fn func(data []byte) ref.Val) *functions.Overload {
+	return &functions.Overload{
+		Operator: function,
+		Unary: func(value ref.Val) ref.Val {
+			switch v := value.(type) {
+			case types.String:
+				return fn([]byte(v))
+			case types.Bytes:
+				return fn(v)
+			}
+			return types.ValOrErr(value, "unexpected type '%v' passed to %s", value.Type(), function)
+		},
+	}
+}
+
+// hmacOverload creates the overload of an HMAC function returning the hex encoded signature of a message, HmacSha256(key, message).
+func hmacOverload(function string, h func() hash.Hash) *functions.Overload {
+	return &functions.Overload{
+		Operator: function,
+		Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+			key, ok := bytesOf(lhs)
+			if !ok {
+				return types.ValOrErr(lhs, "unexpected type '%v' passed to %s 1st Argument", lhs.Type(), function)
+			}
+			message, ok := bytesOf(rhs)
+			if !ok {
+				return types.ValOrErr(rhs, "unexpected type '%v' passed to %s 2nd Argument", rhs.Type(), function)
+			}
+			mac := hmac.New(h, key)
+			mac.Write(message)
+			return types.String(hex.EncodeToString(mac.Sum(nil)))
+		},
+	}
+}
+
+func bytesOf(value ref.Val) ([]byte, bool) {
+	switch v := value.(type) {
+	case types.String:
+		return []byte(v), true
+	case types.Bytes:
+		return v, true
+	}
+	return nil, false
+}
+
+func regexArguments(function string, lhs ref.Val, rhs ref.Val) (string, *regexp.Regexp, ref.Val) {
+	s1, ok := lhs.(types.String)
+	if !ok {
+		return "", nil, types.ValOrErr(lhs, "unexpected type '%v' passed to %s 1st Argument", lhs.Type(), function)
+	}
+	s2, ok := rhs.(types.String)
+	if !ok {
+		return "", nil, types.ValOrErr(rhs, "unexpected type '%v' passed to %s 2nd Argument", rhs.Type(), function)
+	}
+	re, err := compileRegex(string(s2))
+	if err != nil {
+		return "", nil, types.NewErr("%s got invalid pattern. got %s", function, err.Error())
+	}
+	return string(s1), re, nil
+}
+
+// compileRegex compiles the pattern, or takes it from the regex cache if it was compiled before.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	regexCacheMutex.RLock()
+	re, ok := regexCache[pattern]
+	regexCacheMutex.RUnlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCacheMutex.Lock()
+	defer regexCacheMutex.Unlock()
+	if len(regexCache) >= MaxCachedRegexes {
+		regexCache = make(map[string]*regexp.Regexp)
+	}
+	regexCache[pattern] = re
+	return re, nil
+}
+
+// schemaViolations validates the json document of the 1st argument against the schema, inline or file path, of the 2nd.
+func schemaViolations(function string, lhs ref.Val, rhs ref.Val) ([]string, ref.Val) {
+	document, ok := lhs.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(lhs, "unexpected type '%v' passed to %s 1st Argument", lhs.Type(), function)
+	}
+	source, ok := rhs.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(rhs, "unexpected type '%v' passed to %s 2nd Argument", rhs.Type(), function)
+	}
+	schema, err := LoadSchema(string(source))
+	if err != nil {
+		return nil, types.NewErr("%s got %s", function, err.Error())
+	}
+	return ValidateJSON(schema, string(document)), nil
+}
+
+func parseTime(lhs ref.Val, rhs ref.Val) ref.Val {
+	value, ok := lhs.(types.String)
+	if !ok {
+		return types.ValOrErr(lhs, "unexpected type '%v' passed to ParseTime 1st Argument", lhs.Type())
+	}
+	layout, ok := rhs.(types.String)
+	if !ok {
+		return types.ValOrErr(rhs, "unexpected type '%v' passed to ParseTime 2nd Argument", rhs.Type())
+	}
+	t, err := time.Parse(timeLayout(string(layout)), string(value))
+	if err != nil {
+		return types.NewErr("ParseTime got %s", err.Error())
+	}
+	return types.Timestamp{Time: t}
+}
+
+func env(lhs ref.Val, rhs ref.Val) ref.Val {
+	name, ok := lhs.(types.String)
+	if !ok {
+		return types.ValOrErr(lhs, "unexpected type '%v' passed to Env 1st Argument", lhs.Type())
+	}
+	defaultValue, ok := rhs.(types.String)
+	if !ok {
+		return types.ValOrErr(rhs, "unexpected type '%v' passed to Env 2nd Argument", rhs.Type())
+	}
+	if value, ok := os.LookupEnv(string(name)); ok {
+		// environment values are mostly api keys and passwords, keep them out of logs and dumps
+		internal.RegisterSecret(value)
+		return types.String(value)
+	}
+	return defaultValue
+}
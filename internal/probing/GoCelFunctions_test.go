@@ -0,0 +1,117 @@
+package probing
+
+import (
+	"context"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func evaluateAny(t *testing.T, expr string) interface{} {
+	pc := internal.NewProbeContext()
+	pc["ref.body"] = `order 1234 of customer c-77`
+	out, err := GoCelEvaluate(context.Background(), expr, pc, reflect.Interface)
+	assert.NoError(t, err, expr)
+	return out
+}
+
+func TestGoCelRegexFunctions(t *testing.T) {
+	assert.Equal(t, "1234", evaluateAny(t, `RegexFind(ref.body, "[0-9]+")`))
+	assert.Equal(t, "", evaluateAny(t, `RegexFind(ref.body, "x[0-9]+")`))
+	assert.Equal(t, []string{"1234", "77"}, evaluateAny(t, `RegexGroups(ref.body, "order ([0-9]+) of customer c-([0-9]+)")`))
+	assert.Equal(t, []string{}, evaluateAny(t, `RegexGroups(ref.body, "invoice ([0-9]+)")`))
+
+	_, err := GoCelEvaluate(context.Background(), `RegexFind("abc", "[")`, internal.NewProbeContext(), reflect.String)
+	assert.Error(t, err)
+}
+
+func TestCompileRegex(t *testing.T) {
+	re, err := compileRegex("c-([0-9]+)")
+	assert.NoError(t, err)
+	cached, err := compileRegex("c-([0-9]+)")
+	assert.NoError(t, err)
+	assert.Same(t, re, cached)
+
+	_, err = compileRegex("[")
+	assert.Error(t, err)
+	regexCacheMutex.RLock()
+	_, ok := regexCache["["]
+	regexCacheMutex.RUnlock()
+	assert.False(t, ok)
+
+	for i := 0; i < MaxCachedRegexes; i++ {
+		_, err = compileRegex(fmt.Sprintf("x%d", i))
+		assert.NoError(t, err)
+	}
+	regexCacheMutex.RLock()
+	assert.LessOrEqual(t, len(regexCache), MaxCachedRegexes)
+	regexCacheMutex.RUnlock()
+}
+
+func TestGoCelEncodingFunctions(t *testing.T) {
+	assert.Equal(t, "dXNlcjpwYXNz", evaluateAny(t, `Base64Encode("user:pass")`))
+	assert.Equal(t, "user:pass", evaluateAny(t, `Base64Decode("dXNlcjpwYXNz")`))
+	assert.Equal(t, "Pz8_", evaluateAny(t, `Base64URLEncode("???")`))
+	assert.Equal(t, "???", evaluateAny(t, `Base64URLDecode("Pz8_")`))
+	assert.Equal(t, "???", evaluateAny(t, `Base64URLDecode("Pz8_====")`))
+	assert.Equal(t, "a+b%26c%3Dd", evaluateAny(t, `URLEncode("a b&c=d")`))
+	assert.Equal(t, "a b&c=d", evaluateAny(t, `URLDecode("a+b%26c%3Dd")`))
+	assert.Equal(t, "6d696870", evaluateAny(t, `HexEncode("mihp")`))
+	assert.Equal(t, []byte("mihp"), evaluateAny(t, `HexDecode("6d696870")`))
+	assert.Equal(t, "bWlocA==", evaluateAny(t, `Base64Encode(HexDecode("6d696870"))`))
+
+	_, err := GoCelEvaluate(context.Background(), `Base64Decode("***")`, internal.NewProbeContext(), reflect.String)
+	assert.Error(t, err)
+	_, err = GoCelEvaluate(context.Background(), `HexDecode("xyz")`, internal.NewProbeContext(), reflect.Interface)
+	assert.Error(t, err)
+}
+
+func TestGoCelHashFunctions(t *testing.T) {
+	assert.Equal(t, "a9993e364706816aba3e25717850c26c9cd0d89d", evaluateAny(t, `Sha1("abc")`))
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", evaluateAny(t, `Sha256("abc")`))
+	assert.Equal(t, "de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9", evaluateAny(t, `HmacSha1("key", "The quick brown fox jumps over the lazy dog")`))
+	assert.Equal(t, "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", evaluateAny(t, `HmacSha256("key", "The quick brown fox jumps over the lazy dog")`))
+	assert.Equal(t, "97yD9DBThCSxMpjmqm+xQ+9NWaFJRhdZl0edvC0aPNg=", evaluateAny(t, `Base64Encode(HexDecode(HmacSha256(b"key", "The quick brown fox jumps over the lazy dog")))`))
+}
+
+func TestGoCelIdAndTimeFunctions(t *testing.T) {
+	id := evaluateAny(t, `UUID()`).(string)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$`), id)
+	assert.NotEqual(t, id, evaluateAny(t, `UUID()`))
+
+	before := time.Now()
+	now := evaluateAny(t, `Now()`).(time.Time)
+	assert.False(t, now.Before(before))
+	assert.True(t, evaluateAny(t, `Now() > timestamp("2021-01-01T00:00:00Z")`).(bool))
+
+	assert.Equal(t, "2021-10-25", evaluateAny(t, `FormatTime(timestamp("2021-10-25T10:20:30Z"), "2006-01-02")`))
+	assert.Equal(t, "2021-10-25T10:20:30Z", evaluateAny(t, `FormatTime(timestamp("2021-10-25T10:20:30Z"), "RFC3339")`))
+	assert.Equal(t, "Mon, 25 Oct 2021 10:20:30 GMT", evaluateAny(t, `FormatTime(timestamp("2021-10-25T10:20:30Z"), "HTTP")`))
+
+	assert.Equal(t, time.Date(2021, 10, 25, 10, 20, 30, 0, time.UTC), evaluateAny(t, `ParseTime("2021-10-25T10:20:30Z")`).(time.Time).UTC())
+	assert.Equal(t, time.Date(2021, 10, 25, 0, 0, 0, 0, time.UTC), evaluateAny(t, `ParseTime("25/10/2021", "02/01/2006")`))
+	_, err := GoCelEvaluate(context.Background(), `ParseTime("yesterday")`, internal.NewProbeContext(), reflect.Interface)
+	assert.Error(t, err)
+
+	assert.Equal(t, 1635157230, evaluateAny(t, `UnixTime(timestamp("2021-10-25T10:20:30Z"))`))
+	assert.Equal(t, 1635157230500, evaluateAny(t, `UnixMilli(timestamp("2021-10-25T10:20:30.5Z"))`))
+	assert.Equal(t, time.Date(2021, 10, 25, 10, 20, 30, 0, time.UTC), evaluateAny(t, `FromUnixTime(1635157230)`))
+	assert.Equal(t, time.Date(2021, 10, 25, 10, 20, 30, 500000000, time.UTC), evaluateAny(t, `FromUnixMilli(1635157230500)`))
+}
+
+func TestGoCelEnvFunction(t *testing.T) {
+	os.Setenv("MIHP_TEST_ENV", "env-s3cret")
+	defer os.Unsetenv("MIHP_TEST_ENV")
+
+	assert.Equal(t, "env-s3cret", evaluateAny(t, `Env("MIHP_TEST_ENV")`))
+	assert.Equal(t, "", evaluateAny(t, `Env("MIHP_TEST_ENV_MISSING")`))
+	assert.Equal(t, "fallback", evaluateAny(t, `Env("MIHP_TEST_ENV_MISSING", "fallback")`))
+	assert.Equal(t, "env-s3cret", evaluateAny(t, `Env("MIHP_TEST_ENV", "fallback")`))
+	assert.Equal(t, "key=******", internal.Redact("key=env-s3cret"))
+	assert.Equal(t, "key=fallback", internal.Redact("key=fallback"))
+}
@@ -0,0 +1,166 @@
+package probing
+
+import (
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	"golang.org/x/net/html"
+	"reflect"
+	"strings"
+)
+
+var (
+	namespaceMapType = reflect.TypeOf(map[string]string{})
+
+	// celMarkupFunctions are the functions querying HTML documents with CSS selectors and XML documents with XPath.
+	celMarkupFunctions = cel.Functions(
+		&functions.Overload{
+			Operator: "HtmlSelect",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				nodes, errVal := htmlSelect("HtmlSelect", lhs, rhs)
+				if errVal != nil {
+					return errVal
+				}
+				texts := make([]string, 0, len(nodes))
+				for _, node := range nodes {
+					texts = append(texts, htmlText(node))
+				}
+				return types.NewStringList(types.DefaultTypeAdapter, texts)
+			},
+			Function: func(values ...ref.Val) ref.Val {
+				if len(values) != 3 {
+					return types.NewErr("unexpected number of arguments passed to HtmlSelect")
+				}
+				attr, ok := values[2].(types.String)
+				if !ok {
+					return types.ValOrErr(values[2], "unexpected type '%v' passed to HtmlSelect 3rd Argument", values[2].Type())
+				}
+				nodes, errVal := htmlSelect("HtmlSelect", values[0], values[1])
+				if errVal != nil {
+					return errVal
+				}
+				attrs := make([]string, 0, len(nodes))
+				for _, node := range nodes {
+					for _, a := range node.Attr {
+						if a.Key == string(attr) {
+							attrs = append(attrs, a.Val)
+							break
+						}
+					}
+				}
+				return types.NewStringList(types.DefaultTypeAdapter, attrs)
+			},
+		},
+		&functions.Overload{
+			Operator: "HtmlCount",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				nodes, errVal := htmlSelect("HtmlCount", lhs, rhs)
+				if errVal != nil {
+					return errVal
+				}
+				return types.Int(len(nodes))
+			},
+		},
+		&functions.Overload{
+			Operator: "XPath",
+			Binary: func(lhs ref.Val, rhs ref.Val) ref.Val {
+				return xpathEvaluate(lhs, rhs, nil)
+			},
+			Function: func(values ...ref.Val) ref.Val {
+				if len(values) != 3 {
+					return types.NewErr("unexpected number of arguments passed to XPath")
+				}
+				namespaces, err := values[2].ConvertToNative(namespaceMapType)
+				if err != nil {
+					return types.ValOrErr(values[2], "XPath 3rd Argument must be a map of prefix to namespace. got %s", err.Error())
+				}
+				return xpathEvaluate(values[0], values[1], namespaces.(map[string]string))
+			},
+		},
+	)
+)
+
+// htmlSelect parses the HTML document and returns its elements matching the CSS selector.
+func htmlSelect(function string, lhs ref.Val, rhs ref.Val) ([]*html.Node, ref.Val) {
+	body, ok := lhs.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(lhs, "unexpected type '%v' passed to %s 1st Argument", lhs.Type(), function)
+	}
+	selector, ok := rhs.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(rhs, "unexpected type '%v' passed to %s 2nd Argument", rhs.Type(), function)
+	}
+	sel, err := cascadia.ParseGroup(string(selector))
+	if err != nil {
+		return nil, types.NewErr("%s got invalid selector. got %s", function, err.Error())
+	}
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return nil, types.NewErr("%s can not parse html. got %s", function, err.Error())
+	}
+	return cascadia.QueryAll(doc, sel), nil
+}
+
+// htmlText returns the text content of an element with its surrounding spaces trimmed.
+func htmlText(node *html.Node) string {
+	var sb strings.Builder
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(node)
+	return strings.TrimSpace(sb.String())
+}
+
+// xpathEvaluate evaluates the XPath expression on the XML document. Node sets are returned as the list of
+// the nodes' text, while expressions such as count() or string() return a number, string or bool.
+// The namespaces map prefixes used in the expression to namespace URIs, if nil the document's prefixes are used as is.
+func xpathEvaluate(lhs ref.Val, rhs ref.Val, namespaces map[string]string) ref.Val {
+	body, ok := lhs.(types.String)
+	if !ok {
+		return types.ValOrErr(lhs, "unexpected type '%v' passed to XPath 1st Argument", lhs.Type())
+	}
+	expression, ok := rhs.(types.String)
+	if !ok {
+		return types.ValOrErr(rhs, "unexpected type '%v' passed to XPath 2nd Argument", rhs.Type())
+	}
+	var expr *xpath.Expr
+	var err error
+	if namespaces == nil {
+		expr, err = xpath.Compile(string(expression))
+	} else {
+		expr, err = xpath.CompileWithNS(string(expression), namespaces)
+	}
+	if err != nil {
+		return types.NewErr("XPath got invalid expression. got %s", err.Error())
+	}
+	doc, err := xmlquery.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return types.NewErr("XPath can not parse xml. got %s", err.Error())
+	}
+	switch result := expr.Evaluate(xmlquery.CreateXPathNavigator(doc)).(type) {
+	case *xpath.NodeIterator:
+		values := make([]string, 0)
+		for result.MoveNext() {
+			values = append(values, result.Current().Value())
+		}
+		return types.NewStringList(types.DefaultTypeAdapter, values)
+	case float64:
+		return types.Double(result)
+	case string:
+		return types.String(result)
+	case bool:
+		return types.Bool(result)
+	default:
+		return types.NewErr("XPath got unsupported result %v", result)
+	}
+}
@@ -0,0 +1,68 @@
+package probing
+
+import (
+	"context"
+	"github.com/newm4n/mihp/internal"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+const (
+	testHtml = `<html><body>
+<h1 class="title"> Your cart </h1>
+<ul id="items"><li data-sku="A1">Apple</li><li data-sku="B2"><b>Banana</b> bread</li></ul>
+<form><input type="hidden" name="csrf" value="t0k3n"><button id="checkout">Checkout</button></form>
+</body></html>`
+
+	testSoapFault = `<?xml version="1.0"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:m="http://example.com/stock">
+<soap:Body><soap:Fault><faultcode>soap:Server</faultcode><faultstring>Out of stock</faultstring></soap:Fault></soap:Body>
+</soap:Envelope>`
+
+	testSoapResponse = `<?xml version="1.0"?>
+<env:Envelope xmlns:env="http://schemas.xmlsoap.org/soap/envelope/">
+<env:Body><p:Price xmlns:p="http://example.com/stock" currency="EUR">34.5</p:Price><p:Price xmlns:p="http://example.com/stock" currency="USD">37</p:Price></env:Body>
+</env:Envelope>`
+)
+
+func evaluateMarkup(t *testing.T, expr string) interface{} {
+	pc := internal.NewProbeContext()
+	pc["ref.html"] = testHtml
+	pc["ref.fault"] = testSoapFault
+	pc["ref.soap"] = testSoapResponse
+	out, err := GoCelEvaluate(context.Background(), expr, pc, reflect.Interface)
+	assert.NoError(t, err, expr)
+	return out
+}
+
+func TestGoCelHtmlFunctions(t *testing.T) {
+	assert.Equal(t, []string{"Your cart"}, evaluateMarkup(t, `HtmlSelect(ref.html, "h1.title")`))
+	assert.Equal(t, []string{"Apple", "Banana bread"}, evaluateMarkup(t, `HtmlSelect(ref.html, "#items li")`))
+	assert.Equal(t, []string{"A1", "B2"}, evaluateMarkup(t, `HtmlSelect(ref.html, "#items li", "data-sku")`))
+	assert.Equal(t, []string{"t0k3n"}, evaluateMarkup(t, `HtmlSelect(ref.html, "input[name=csrf]", "value")`))
+	assert.Equal(t, 1, evaluateMarkup(t, `HtmlCount(ref.html, "button#checkout")`))
+	assert.Equal(t, 0, evaluateMarkup(t, `HtmlCount(ref.html, "div.error")`))
+	assert.Equal(t, true, evaluateMarkup(t, `HtmlCount(ref.html, "button#checkout") == 1 && HtmlSelect(ref.html, "#items li")[0] == "Apple"`))
+
+	_, err := GoCelEvaluate(context.Background(), `HtmlCount("<p></p>", "p[")`, internal.NewProbeContext(), reflect.Int64)
+	assert.Error(t, err)
+}
+
+func TestGoCelXPathFunction(t *testing.T) {
+	assert.Equal(t, []string{"Out of stock"}, evaluateMarkup(t, `XPath(ref.fault, "//soap:Fault/faultstring")`))
+	assert.Equal(t, float64(1), evaluateMarkup(t, `XPath(ref.fault, "count(//soap:Fault)")`))
+	assert.Equal(t, float64(0), evaluateMarkup(t, `XPath(ref.soap, "count(//soap:Fault)")`))
+	assert.Equal(t, true, evaluateMarkup(t, `size(XPath(ref.soap, "//env:Fault")) == 0`))
+
+	// with namespaces, the expression prefixes are mapped to namespace URIs whatever the document prefixes are
+	assert.Equal(t, []string{"34.5", "37"}, evaluateMarkup(t, `XPath(ref.soap, "//s:Body/stock:Price", {"s": "http://schemas.xmlsoap.org/soap/envelope/", "stock": "http://example.com/stock"})`))
+	assert.Equal(t, []string{"EUR", "USD"}, evaluateMarkup(t, `XPath(ref.soap, "//stock:Price/@currency", {"stock": "http://example.com/stock"})`))
+	assert.Equal(t, "34.5", evaluateMarkup(t, `XPath(ref.soap, "string(//stock:Price[@currency='EUR'])", {"stock": "http://example.com/stock"})`))
+	assert.Equal(t, []string{}, evaluateMarkup(t, `XPath(ref.soap, "//stock:Price", {"stock": "http://example.com/other"})`))
+
+	_, err := GoCelEvaluate(context.Background(), `XPath("<a>", "//a[")`, internal.NewProbeContext(), reflect.Interface)
+	assert.Error(t, err)
+	_, err = GoCelEvaluate(context.Background(), `XPath("<a>", "//a")`, internal.NewProbeContext(), reflect.Interface)
+	assert.Error(t, err)
+}
@@ -0,0 +1,230 @@
+package probing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OAuth2ExpiryLeeway is how long before its expiry a cached OAuth2 token is renewed.
+	OAuth2ExpiryLeeway = 30 * time.Second
+)
+
+var (
+	hmacAlgorithms = map[string]func() hash.Hash{
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+	}
+
+	oauth2Tokens      = make(map[string]*oauth2Token)
+	oauth2TokensMutex sync.Mutex
+)
+
+// oauth2Token is the token endpoint response.
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	expiry      time.Time
+}
+
+// usable checks if the token can still be used, tokens without expiry are never cached.
+func (token *oauth2Token) usable(now time.Time) bool {
+	return !token.expiry.IsZero() && now.Add(OAuth2ExpiryLeeway).Before(token.expiry)
+}
+
+// EffectiveAuth returns the auth configuration a request should use, the request's own or the probe's.
+// Returns nil if the request is not authenticated.
+func EffectiveAuth(probe *internal.Probe, probeRequest *internal.ProbeRequest) *internal.AuthConfig {
+	auth := probe.Auth
+	if probeRequest.Auth != nil {
+		auth = probeRequest.Auth
+	}
+	if auth == nil || len(auth.Type) == 0 || auth.Type == internal.AuthNone {
+		return nil
+	}
+	return auth
+}
+
+// Authenticate adds the authentication header to the request header and records "auth.type", "auth.token"
+// and, for OAuth2, "auth.expiry" of the request.
+func Authenticate(ctx context.Context, client *http.Client, pctx internal.ProbeContext, probe *internal.Probe, probeRequest *internal.ProbeRequest,
+	auth *internal.AuthConfig, header http.Header, method, URL string, body []byte) error {
+	prefix := fmt.Sprintf("probe.%s.req.%s.auth", probe.Name, probeRequest.Name)
+	pctx[fmt.Sprintf("%s.type", prefix)] = auth.Type
+	switch auth.Type {
+	case internal.AuthBasic:
+		credentials := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	case internal.AuthBearer:
+		out, err := GoCelEvaluate(ctx, auth.TokenExpr, pctx, reflect.String)
+		if err != nil {
+			return fmt.Errorf("%w : probe %s request %s token expression [%s] got %s", errors.ErrAuthError, probe.Name, probeRequest.Name, auth.TokenExpr, err.Error())
+		}
+		pctx[fmt.Sprintf("%s.token", prefix)] = out.(string)
+		header.Set("Authorization", "Bearer "+out.(string))
+	case internal.AuthOAuth2:
+		token, cached, err := oauth2AccessToken(ctx, client, auth)
+		if err != nil {
+			return fmt.Errorf("%w : probe %s request %s got %s", errors.ErrAuthError, probe.Name, probeRequest.Name, err.Error())
+		}
+		pctx[fmt.Sprintf("%s.token", prefix)] = token.AccessToken
+		pctx[fmt.Sprintf("%s.cached", prefix)] = cached
+		if !token.expiry.IsZero() {
+			pctx[fmt.Sprintf("%s.expiry", prefix)] = token.expiry
+		}
+		header.Set("Authorization", "Bearer "+token.AccessToken)
+	case internal.AuthHMAC:
+		return signRequest(ctx, pctx, probe, probeRequest, auth, header, method, URL, body)
+	default:
+		return fmt.Errorf("%w : probe %s request %s unknown auth type %s", errors.ErrAuthError, probe.Name, probeRequest.Name, auth.Type)
+	}
+	return nil
+}
+
+// signRequest sets the Date header and the HMAC signature of the canonical string, recorded as "auth.date",
+// "auth.canonical" and "auth.token".
+func signRequest(ctx context.Context, pctx internal.ProbeContext, probe *internal.Probe, probeRequest *internal.ProbeRequest,
+	auth *internal.AuthConfig, header http.Header, method, URL string, body []byte) error {
+	prefix := fmt.Sprintf("probe.%s.req.%s.auth", probe.Name, probeRequest.Name)
+	algorithm := strings.ToLower(auth.Algorithm)
+	if len(algorithm) == 0 {
+		algorithm = "sha256"
+	}
+	newHash, ok := hmacAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("%w : probe %s request %s unknown hmac algorithm %s", errors.ErrAuthError, probe.Name, probeRequest.Name, auth.Algorithm)
+	}
+
+	date := header.Get("Date")
+	if len(date) == 0 {
+		date = time.Now().UTC().Format(http.TimeFormat)
+		header.Set("Date", date)
+	}
+	pctx[fmt.Sprintf("%s.date", prefix)] = date
+
+	var canonical string
+	if len(auth.CanonicalExpr) > 0 {
+		out, err := GoCelEvaluate(ctx, auth.CanonicalExpr, pctx, reflect.String)
+		if err != nil {
+			return fmt.Errorf("%w : probe %s request %s canonical expression [%s] got %s", errors.ErrAuthError, probe.Name, probeRequest.Name, auth.CanonicalExpr, err.Error())
+		}
+		canonical = out.(string)
+	} else {
+		requestURL, err := url.Parse(URL)
+		if err != nil {
+			return fmt.Errorf("%w : probe %s request %s got %s", errors.ErrAuthError, probe.Name, probeRequest.Name, err.Error())
+		}
+		bodyHash := sha256.Sum256(body)
+		canonical = strings.Join([]string{strings.ToUpper(method), requestURL.RequestURI(), date, hex.EncodeToString(bodyHash[:])}, "\n")
+	}
+	pctx[fmt.Sprintf("%s.canonical", prefix)] = canonical
+
+	mac := hmac.New(newHash, []byte(auth.Secret))
+	mac.Write([]byte(canonical))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	pctx[fmt.Sprintf("%s.token", prefix)] = signature
+
+	headerName, headerPrefix := auth.SignatureHeader()
+	if len(auth.KeyID) > 0 {
+		header.Set(headerName, fmt.Sprintf("%s %s:%s", headerPrefix, auth.KeyID, signature))
+	} else {
+		header.Set(headerName, fmt.Sprintf("%s %s", headerPrefix, signature))
+	}
+	return nil
+}
+
+// oauth2AccessToken returns the cached token of the auth configuration, or requests a new one from its token url
+// if there is none or it is about to expire. Cached tells whether the token came from the cache.
+func oauth2AccessToken(ctx context.Context, client *http.Client, auth *internal.AuthConfig) (token *oauth2Token, cached bool, err error) {
+	key := strings.Join([]string{auth.TokenURL, auth.ClientID, auth.GrantType(), auth.Username, strings.Join(auth.Scopes, " ")}, "\n")
+	oauth2TokensMutex.Lock()
+	token, ok := oauth2Tokens[key]
+	oauth2TokensMutex.Unlock()
+	if ok && token.usable(time.Now()) {
+		return token, true, nil
+	}
+
+	token, err = requestOAuth2Token(ctx, client, auth)
+	if err != nil {
+		return nil, false, err
+	}
+	oauth2TokensMutex.Lock()
+	defer oauth2TokensMutex.Unlock()
+	if token.usable(time.Now()) {
+		oauth2Tokens[key] = token
+	} else {
+		delete(oauth2Tokens, key)
+	}
+	return token, false, nil
+}
+
+func requestOAuth2Token(ctx context.Context, client *http.Client, auth *internal.AuthConfig) (*oauth2Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", auth.GrantType())
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+	if auth.GrantType() == internal.GrantPassword {
+		form.Set("username", auth.Username)
+		form.Set("password", auth.Password)
+	}
+	if auth.ClientAuthInBody {
+		form.Set("client_id", auth.ClientID)
+		form.Set("client_secret", auth.ClientSecret)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token url [%s]. got %s", auth.TokenURL, err.Error())
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if !auth.ClientAuthInBody {
+		request.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+	}
+
+	requestStart := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("token request to %s failed. got %s", auth.TokenURL, err.Error())
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, internal.DefaultCaptureMaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("can not read token response from %s. got %s", auth.TokenURL, err.Error())
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("token request to %s returns %d %s", auth.TokenURL, response.StatusCode, strings.TrimSpace(string(data)))
+	}
+	token := &oauth2Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, fmt.Errorf("invalid token response from %s. got %s", auth.TokenURL, err.Error())
+	}
+	if len(token.AccessToken) == 0 {
+		return nil, fmt.Errorf("token response from %s has no access_token", auth.TokenURL)
+	}
+	if token.ExpiresIn > 0 {
+		token.expiry = requestStart.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
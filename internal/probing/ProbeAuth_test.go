@@ -0,0 +1,164 @@
+package probing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbe_Auth(t *testing.T) {
+	var tokenCalls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/oauth/token":
+			atomic.AddInt32(&tokenCalls, 1)
+			req.ParseForm()
+			clientID, clientSecret, _ := req.BasicAuth()
+			if clientID != "probe" || clientSecret != "client-secret" || req.PostForm.Get("grant_type") != "client_credentials" || req.PostForm.Get("scope") != "read write" {
+				resp.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			resp.Header().Add("Content-Type", "application/json")
+			resp.Write([]byte(`{"access_token": "oauth-token-1", "token_type": "bearer", "expires_in": 3600}`))
+		case "/login":
+			if user, password, _ := req.BasicAuth(); user != "jane" || password != "pa55" {
+				resp.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			resp.Header().Add("Content-Type", "application/json")
+			resp.Write([]byte(`{"token": "login-token"}`))
+		default:
+			resp.Header().Add("Content-Type", "text/plain")
+			resp.Write([]byte(req.Header.Get("Authorization")))
+		}
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Auth",
+		ID:   "1013",
+		Auth: &internal.AuthConfig{
+			Type:         internal.AuthOAuth2,
+			TokenURL:     srv.URL + "/oauth/token",
+			ClientID:     "probe",
+			ClientSecret: "client-secret",
+			Scopes:       []string{"read", "write"},
+		},
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:          "Login",
+				PathExpr:      `"/login"`,
+				MethodExpr:    `"POST"`,
+				Auth:          &internal.AuthConfig{Type: internal.AuthBasic, Username: "jane", Password: "pa55"},
+				SuccessIfExpr: `this.resp.code == 200`,
+				Extract:       map[string]string{"token": `GetJsonStringValue(this.resp.body, "token")`},
+			},
+			{
+				Name:       "Profile",
+				PathExpr:   `"/profile"`,
+				MethodExpr: `"GET"`,
+				Auth:       &internal.AuthConfig{Type: internal.AuthBearer, TokenExpr: `vars.token`},
+			},
+			{
+				Name:       "Orders",
+				PathExpr:   `"/orders"`,
+				MethodExpr: `"GET"`,
+			},
+			{
+				Name:       "Public",
+				PathExpr:   `"/public"`,
+				MethodExpr: `"GET"`,
+				Auth:       &internal.AuthConfig{Type: internal.AuthNone},
+			},
+		},
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+	}
+
+	for run := 0; run < 2; run++ {
+		pCtx := internal.NewProbeContext()
+		assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+		assert.Equal(t, internal.AuthBasic, pCtx["probe.Auth.req.Login.auth.type"])
+		assert.Equal(t, "Bearer login-token", pCtx["probe.Auth.req.Profile.resp.body"])
+		assert.Equal(t, "login-token", pCtx["probe.Auth.req.Profile.auth.token"])
+		assert.Equal(t, "Bearer oauth-token-1", pCtx["probe.Auth.req.Orders.resp.body"])
+		assert.Equal(t, internal.AuthOAuth2, pCtx["probe.Auth.req.Orders.auth.type"])
+		assert.Equal(t, "oauth-token-1", pCtx["probe.Auth.req.Orders.auth.token"])
+		assert.Equal(t, run > 0, pCtx["probe.Auth.req.Orders.auth.cached"])
+		assert.WithinDuration(t, time.Now().Add(time.Hour), pCtx["probe.Auth.req.Orders.auth.expiry"].(time.Time), 10*time.Second)
+		assert.Equal(t, "", pCtx["probe.Auth.req.Public.resp.body"])
+		assert.NotContains(t, pCtx.ToString(false), `"oauth-token-1"`)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenCalls))
+
+	probe.Auth.ClientSecret = "wrong"
+	probe.Auth.ClientID = "other"
+	err := ExecuteProbe(context.Background(), probe, internal.NewProbeContext(), 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrAuthError))
+}
+
+func TestProbe_AuthHMAC(t *testing.T) {
+	secret := "hmac-secret"
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodyHash := sha256.Sum256(body)
+		canonical := strings.Join([]string{req.Method, req.URL.RequestURI(), req.Header.Get("Date"), hex.EncodeToString(bodyHash[:])}, "\n")
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(canonical))
+		expected := fmt.Sprintf("HMAC key-1:%s", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		if req.Header.Get("Authorization") != expected {
+			resp.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Signed",
+		ID:   "1014",
+		Auth: &internal.AuthConfig{Type: internal.AuthHMAC, KeyID: "key-1", Secret: secret},
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:          "Order",
+				PathExpr:      `"/orders?page=2"`,
+				MethodExpr:    `"POST"`,
+				BodyExpr:      `"{\"id\": 1}"`,
+				SuccessIfExpr: `this.resp.code == 200`,
+			},
+			{
+				Name:          "Custom",
+				PathExpr:      `"/orders"`,
+				MethodExpr:    `"GET"`,
+				SuccessIfExpr: `this.resp.code == 401 && this.auth.canonical == "GET|" + this.auth.date`,
+				Auth: &internal.AuthConfig{Type: internal.AuthHMAC, Secret: secret, Algorithm: "sha512",
+					CanonicalExpr: `this.method + "|" + this.auth.date`, Header: "X-Signature", Prefix: "v1"},
+			},
+		},
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Contains(t, pCtx["probe.Signed.req.Order.auth.canonical"], "POST\n/orders?page=2\n")
+	assert.NotEmpty(t, pCtx["probe.Signed.req.Custom.auth.token"])
+
+	probe.Requests[1].Auth.Algorithm = "md5"
+	err := ExecuteProbe(context.Background(), probe, internal.NewProbeContext(), 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrAuthError))
+}
@@ -0,0 +1,137 @@
+package probing
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/newm4n/mihp/internal"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// CapturedBody is the result of reading a response body through CaptureBody
+type CapturedBody struct {
+	// Data holds the first MaxSize bytes of the decoded body
+	Data []byte
+	// Size is the size of the whole decoded body
+	Size int
+	// Truncated is true if the body is bigger than the stored Data
+	Truncated bool
+	// MediaType is the media type of the body, taken from Content-Type or sniffed when absent
+	MediaType string
+	// Encoding is the Content-Encoding the body was decoded from
+	Encoding string
+	// SHA256 is the hex encoded sha256 of the whole decoded body
+	SHA256 string
+}
+
+// limitedCapture is a writer that hashes and counts everything written to it but only keeps the first max bytes.
+type limitedCapture struct {
+	max  int
+	size int
+	data []byte
+	hash hash.Hash
+}
+
+func (lc *limitedCapture) Write(p []byte) (int, error) {
+	lc.hash.Write(p)
+	lc.size += len(p)
+	if room := lc.max - len(lc.data); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		lc.data = append(lc.data, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// DecodeBody wraps the response body with the decompressor matching the response Content-Encoding.
+// Body already decompressed by the http transport is returned as is.
+func DecodeBody(response *http.Response) (io.Reader, string, error) {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	if response.Uncompressed {
+		return response.Body, "gzip", nil
+	}
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(response.Body)
+		return reader, encoding, err
+	case "deflate":
+		// most server sends zlib wrapped deflate as RFC 7230 says, but some sends raw deflate stream.
+		buffered := bufio.NewReader(response.Body)
+		header, err := buffered.Peek(2)
+		if err == nil && len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			reader, err := zlib.NewReader(buffered)
+			return reader, encoding, err
+		}
+		return flate.NewReader(buffered), encoding, nil
+	case "br":
+		return brotli.NewReader(response.Body), encoding, nil
+	default:
+		return response.Body, encoding, nil
+	}
+}
+
+// CaptureBody reads and decodes the whole response body, keeping at most the capture's max size in memory.
+func CaptureBody(response *http.Response, capture *internal.BodyCapture) (*CapturedBody, error) {
+	reader, encoding, err := DecodeBody(response)
+	if err != nil {
+		return nil, err
+	}
+	lc := &limitedCapture{
+		max:  capture.CaptureMaxSize(),
+		data: make([]byte, 0),
+		hash: sha256.New(),
+	}
+	_, err = io.Copy(lc, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := ""
+	if contentType := response.Header.Get("Content-Type"); len(contentType) > 0 {
+		if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+			mediaType = mt
+		} else {
+			mediaType = strings.TrimSpace(strings.Split(contentType, ";")[0])
+		}
+	} else if len(lc.data) > 0 {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(lc.data))
+	}
+
+	return &CapturedBody{
+		Data:      lc.data,
+		Size:      lc.size,
+		Truncated: lc.size > len(lc.data),
+		MediaType: mediaType,
+		Encoding:  encoding,
+		SHA256:    hex.EncodeToString(lc.hash.Sum(nil)),
+	}, nil
+}
+
+// Record stores the captured body into the probe context under "probe.<probe>.req.<request>.resp.body*"
+func (cb *CapturedBody) Record(pctx internal.ProbeContext, probeName, requestName string, capture *internal.BodyCapture) {
+	prefix := fmt.Sprintf("probe.%s.req.%s.resp.body", probeName, requestName)
+	pctx[fmt.Sprintf("%s.size", prefix)] = cb.Size
+	pctx[fmt.Sprintf("%s.truncated", prefix)] = cb.Truncated
+	pctx[fmt.Sprintf("%s.sha256", prefix)] = cb.SHA256
+	pctx[fmt.Sprintf("%s.mediatype", prefix)] = cb.MediaType
+	pctx[fmt.Sprintf("%s.encoding", prefix)] = cb.Encoding
+	if capture.IsCapturable(cb.MediaType) {
+		pctx[prefix] = string(cb.Data)
+	} else {
+		pctx[prefix] = "<binary>"
+		if capture.CaptureBase64() {
+			pctx[fmt.Sprintf("%s.base64", prefix)] = base64.StdEncoding.EncodeToString(cb.Data)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package probing
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"github.com/newm4n/mihp/internal"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func encodedResponse(t *testing.T, encoding string, body []byte) *http.Response {
+	buff := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch encoding {
+	case "br":
+		w = brotli.NewWriter(buff)
+	case "deflate":
+		w = zlib.NewWriter(buff)
+	case "raw-deflate":
+		fw, err := flate.NewWriter(buff, flate.DefaultCompression)
+		assert.NoError(t, err)
+		w = fw
+		encoding = "deflate"
+	}
+	_, err := w.Write(body)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return &http.Response{
+		Header: http.Header{
+			"Content-Type":     {"application/json"},
+			"Content-Encoding": {encoding},
+		},
+		Body: ioutil.NopCloser(buff),
+	}
+}
+
+func TestCaptureBody_Decoding(t *testing.T) {
+	body := []byte(`{"hello":"world"}`)
+	for _, encoding := range []string{"br", "deflate", "raw-deflate"} {
+		captured, err := CaptureBody(encodedResponse(t, encoding, body), nil)
+		assert.NoError(t, err, encoding)
+		assert.Equal(t, string(body), string(captured.Data), encoding)
+		assert.Equal(t, "application/json", captured.MediaType)
+	}
+}
+
+func TestBodyCapture_IsCapturable(t *testing.T) {
+	var capture *internal.BodyCapture
+	assert.True(t, capture.IsCapturable("text/html"))
+	assert.True(t, capture.IsCapturable("application/problem+json"))
+	assert.True(t, capture.IsCapturable("application/soap+xml"))
+	assert.False(t, capture.IsCapturable("image/png"))
+
+	capture = &internal.BodyCapture{MediaTypes: []string{"image/*"}}
+	assert.True(t, capture.IsCapturable("image/png"))
+	assert.False(t, capture.IsCapturable("text/html"))
+}
@@ -0,0 +1,94 @@
+package probing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"math"
+	"time"
+)
+
+var (
+	tlsVersionNames = map[uint16]string{
+		tls.VersionTLS10: "TLS 1.0",
+		tls.VersionTLS11: "TLS 1.1",
+		tls.VersionTLS12: "TLS 1.2",
+		tls.VersionTLS13: "TLS 1.3",
+	}
+)
+
+// TLSVersionName returns the human readable name of a negotiated TLS version, such as "TLS 1.2"
+func TLSVersionName(version uint16) string {
+	if name, ok := tlsVersionNames[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// DaysToExpire returns the number of whole days from now until the certificate's NotAfter.
+// Negative value means the certificate is already expired.
+func DaysToExpire(cert *x509.Certificate, now time.Time) int {
+	return int(math.Floor(cert.NotAfter.Sub(now).Hours() / 24))
+}
+
+// RecordTLSState publishes the negotiated TLS parameters and the server's peer certificates into the probe context
+// under "probe.<probe>.req.<request>.tls.*". The leaf certificate is stored under "tls.cert.*" while
+// the whole chain (leaf first) is stored as arrays under "tls.chain.*".
+func RecordTLSState(pctx internal.ProbeContext, probeName, requestName string, state *tls.ConnectionState) {
+	prefix := fmt.Sprintf("probe.%s.req.%s.tls", probeName, requestName)
+	if state == nil {
+		pctx[fmt.Sprintf("%s.enabled", prefix)] = false
+		return
+	}
+	pctx[fmt.Sprintf("%s.enabled", prefix)] = true
+	pctx[fmt.Sprintf("%s.version", prefix)] = TLSVersionName(state.Version)
+	pctx[fmt.Sprintf("%s.cipher", prefix)] = tls.CipherSuiteName(state.CipherSuite)
+	pctx[fmt.Sprintf("%s.servername", prefix)] = state.ServerName
+
+	now := time.Now()
+	pctx[fmt.Sprintf("%s.chain.length", prefix)] = len(state.PeerCertificates)
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	subjects := make([]string, len(state.PeerCertificates))
+	issuers := make([]string, len(state.PeerCertificates))
+	minDays := math.MaxInt32
+	for idx, cert := range state.PeerCertificates {
+		subjects[idx] = cert.Subject.String()
+		issuers[idx] = cert.Issuer.String()
+		if days := DaysToExpire(cert, now); days < minDays {
+			minDays = days
+		}
+	}
+	pctx[fmt.Sprintf("%s.chain.subject", prefix)] = subjects
+	pctx[fmt.Sprintf("%s.chain.issuer", prefix)] = issuers
+	pctx[fmt.Sprintf("%s.chain.daystoexpire", prefix)] = minDays
+
+	leaf := state.PeerCertificates[0]
+	pctx[fmt.Sprintf("%s.cert.subject", prefix)] = leaf.Subject.String()
+	pctx[fmt.Sprintf("%s.cert.subject.cn", prefix)] = leaf.Subject.CommonName
+	pctx[fmt.Sprintf("%s.cert.issuer", prefix)] = leaf.Issuer.String()
+	pctx[fmt.Sprintf("%s.cert.issuer.cn", prefix)] = leaf.Issuer.CommonName
+	pctx[fmt.Sprintf("%s.cert.san", prefix)] = certificateSANs(leaf)
+	pctx[fmt.Sprintf("%s.cert.notbefore", prefix)] = leaf.NotBefore
+	pctx[fmt.Sprintf("%s.cert.notafter", prefix)] = leaf.NotAfter
+	pctx[fmt.Sprintf("%s.cert.daystoexpire", prefix)] = DaysToExpire(leaf, now)
+	pctx[fmt.Sprintf("%s.cert.serial", prefix)] = leaf.SerialNumber.String()
+	pctx[fmt.Sprintf("%s.cert.algorithm", prefix)] = leaf.SignatureAlgorithm.String()
+}
+
+// certificateSANs collects all subject alternative names (DNS, IP, email and URI) of a certificate.
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses))
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
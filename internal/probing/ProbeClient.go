@@ -2,7 +2,12 @@ package probing
 
 import (
 	"crypto/tls"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sort"
 	"time"
 )
 
@@ -19,3 +24,51 @@ func NewHttpClient(timeoutSecond, tlsHandsakeTimeout int, ignoreTLS bool) *http.
 
 	return ret
 }
+
+// NewProbeHttpClient creates the http client that is shared among all requests of a single probe run.
+// The client carries a cookie jar so cookies set by one request are sent by the following ones,
+// and reuses connections between requests unless the probe asks for fresh connections.
+// Traffic is routed through the probe's proxy, or the DefaultProxy.
+func NewProbeHttpClient(probe *internal.Probe, timeoutSecond int, ignoreTLS bool) (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := NewTLSConfig(probe.TLS, ignoreTLS)
+	if err != nil {
+		return nil, err
+	}
+	proxyFunc, err := NewProxyFunc(EffectiveProxy(probe))
+	if err != nil {
+		return nil, err
+	}
+	client := NewHttpClient(timeoutSecond, timeoutSecond, ignoreTLS)
+	client.Jar = jar
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport.DisableKeepAlives = probe.FreshConnection
+		transport.TLSClientConfig = tlsConfig
+		transport.Proxy = proxyFunc
+	}
+	return client, nil
+}
+
+// RecordCookies stores the cookies the client's jar would send to the specified URL into the probe context
+// as "probe.<probe>.cookie.<name>". The list of cookie names is stored in "probe.<probe>.cookie"
+func RecordCookies(pctx internal.ProbeContext, probeName string, client *http.Client, URL *url.URL) {
+	if client.Jar == nil || URL == nil {
+		return
+	}
+	names := make([]string, 0)
+	if namesItv, ok := pctx[fmt.Sprintf("probe.%s.cookie", probeName)]; ok {
+		names = namesItv.([]string)
+	}
+	for _, cookie := range client.Jar.Cookies(URL) {
+		key := fmt.Sprintf("probe.%s.cookie.%s", probeName, cookie.Name)
+		if _, exist := pctx[key]; !exist {
+			names = append(names, cookie.Name)
+		}
+		pctx[key] = cookie.Value
+	}
+	sort.Strings(names)
+	pctx[fmt.Sprintf("probe.%s.cookie", probeName)] = names
+}
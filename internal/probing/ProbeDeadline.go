@@ -0,0 +1,46 @@
+package probing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFail    = "fail"
+	OutcomeTimeout = "timeout"
+)
+
+// WithProbeDeadline bounds the context with the probe's deadline, if any. The deadline covers the whole request chain.
+func WithProbeDeadline(ctx context.Context, probe *internal.Probe) (context.Context, context.CancelFunc) {
+	if probe.Deadline > 0 {
+		return context.WithTimeout(ctx, probe.Deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// IsTimeout checks if the error is caused by the probe's context being cancelled or its deadline exceeded.
+func IsTimeout(err error) bool {
+	return errors.Is(err, mihperrors.ErrProbeTimeout)
+}
+
+// ProbeOutcome returns the outcome of a probe run from the error returned by ExecuteProbe.
+func ProbeOutcome(err error) string {
+	if err == nil {
+		return OutcomeSuccess
+	}
+	if IsTimeout(err) {
+		return OutcomeTimeout
+	}
+	return OutcomeFail
+}
+
+// RecordTimeout marks a probe request as timed-out in the probe context and returns the ErrProbeTimeout to report.
+func RecordTimeout(ctx context.Context, pctx internal.ProbeContext, probeName, requestName string) error {
+	pctx[fmt.Sprintf("probe.%s.req.%s.error", probeName, requestName)] = ctx.Err()
+	pctx[fmt.Sprintf("probe.%s.req.%s.outcome", probeName, requestName)] = OutcomeTimeout
+	return fmt.Errorf("%w : probe %s request %s got %s", mihperrors.ErrProbeTimeout, probeName, requestName, ctx.Err())
+}
@@ -8,15 +8,16 @@ import (
 	"github.com/newm4n/mihp/pkg/errors"
 	"github.com/newm4n/mihp/pkg/helper/cron"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"net/http/httptrace"
 	"reflect"
 	"strings"
 	"time"
 )
 
 const (
-	TimeoutsSecond = 10
+	DefaultTimeoutSecond = 10
 )
 
 var (
@@ -45,8 +46,19 @@ func ExecuteProbe(ctx context.Context, probe *internal.Probe, pctx internal.Prob
 	pctx["probe"] = probe.Name
 	if ignoreSchedule || ProbeCanStartBySchedule(ctx, probe) {
 		pctx[fmt.Sprintf("probe.%s.id", probe.Name)] = probe.ID
+		ctx, cancel := WithProbeDeadline(ctx, probe)
+		defer cancel()
+		if probe.Deadline > 0 {
+			pctx[fmt.Sprintf("probe.%s.deadline", probe.Name)] = probe.Deadline
+		}
 		startTime := time.Now()
 		pctx[fmt.Sprintf("probe.%s.starttime", probe.Name)] = startTime
+		if window := InMaintenance(probe, startTime); window != nil {
+			pctx[fmt.Sprintf("probe.%s.maintenance", probe.Name)] = true
+			pctx[fmt.Sprintf("probe.%s.maintenance.window", probe.Name)] = window.ID
+		} else {
+			pctx[fmt.Sprintf("probe.%s.maintenance", probe.Name)] = false
+		}
 
 		defer func() {
 			pctx[fmt.Sprintf("probe.%s.endtime", probe.Name)] = time.Now()
@@ -60,34 +72,62 @@ func ExecuteProbe(ctx context.Context, probe *internal.Probe, pctx internal.Prob
 
 		pctx[fmt.Sprintf("probe.%s.req", probe.Name)] = strings.Join(reqNames, ",")
 
+		if proxy := EffectiveProxy(probe); proxy != nil {
+			pctx[fmt.Sprintf("probe.%s.proxy", probe.Name)] = proxy.String()
+		} else {
+			pctx[fmt.Sprintf("probe.%s.proxy", probe.Name)] = "DIRECT"
+		}
+
+		probeLog.Tracef("Creating HTTP client with %d second timeout", timeoutSecond)
+		client, err := NewProbeHttpClient(probe, timeoutSecond, ignoreTLS)
+		if err != nil {
+			pctx[fmt.Sprintf("probe.%s.fail", probe.Name)] = true
+			pctx[fmt.Sprintf("probe.%s.success", probe.Name)] = false
+			pctx[fmt.Sprintf("probe.%s.outcome", probe.Name)] = OutcomeFail
+			pctx[fmt.Sprintf("probe.%s.status", probe.Name)] = StatusDown
+			probeLog.Errorf("error while creating http client. got %s", err.Error())
+			return fmt.Errorf("%w : got %s", errors.ErrCreateHttpClient, err.Error())
+		}
+		defer client.CloseIdleConnections()
+
 		for seq, reqs := range probe.Requests {
-			err := ExecuteProbeRequest(ctx, probe, reqs, seq, timeoutSecond, ignoreTLS, pctx)
+			err := ExecuteProbeRequest(ctx, client, probe, reqs, seq, pctx)
 			if err != nil {
 				pctx[fmt.Sprintf("probe.%s.fail", probe.Name)] = true
 				pctx[fmt.Sprintf("probe.%s.success", probe.Name)] = false
+				pctx[fmt.Sprintf("probe.%s.outcome", probe.Name)] = ProbeOutcome(err)
+				pctx[fmt.Sprintf("probe.%s.req.%s.status", probe.Name, reqs.Name)] = StatusDown
+				RecordProbeStatus(pctx, probe, time.Now().Sub(startTime), err)
 				probeLog.Errorf("error when execute probe request %s. got %s", reqs.Name, err.Error())
 				return err
 			}
 		}
 		pctx[fmt.Sprintf("probe.%s.fail", probe.Name)] = false
 		pctx[fmt.Sprintf("probe.%s.success", probe.Name)] = true
+		pctx[fmt.Sprintf("probe.%s.outcome", probe.Name)] = OutcomeSuccess
+		if status := RecordProbeStatus(pctx, probe, time.Now().Sub(startTime), nil); status != StatusUp {
+			probeLog.Warnf("probe is %s", status)
+		}
 	} else {
 		probeLog.Tracef("probe.%s can't start", probe.Name)
 	}
 	return nil
 }
 
-func ExecuteProbeRequest(ctx context.Context, probe *internal.Probe, probeRequest *internal.ProbeRequest,
-	sequence int, timeoutSecond int, ignoreTLS bool, pctx internal.ProbeContext) error {
+func ExecuteProbeRequest(ctx context.Context, client *http.Client, probe *internal.Probe, probeRequest *internal.ProbeRequest,
+	sequence int, pctx internal.ProbeContext) error {
 
 	requestLog := engineLog.WithField("probe", probe.Name).WithField("request", probeRequest.Name)
 
 	if ctx.Err() != nil {
-		pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = ctx.Err()
 		requestLog.Errorf("context error. got %s", ctx.Err())
-		return fmt.Errorf("%w : context probably timed-out. got %s", errors.ErrContextError, ctx.Err())
+		return RecordTimeout(ctx, pctx, probe.Name, probeRequest.Name)
 	}
 	pctx[fmt.Sprintf("probe.%s.req.%s.sequence", probe.Name, probeRequest.Name)] = sequence
+	pctx[CurrentRequestKey(probe.Name)] = probeRequest.Name
+	if sequence > 0 && sequence <= len(probe.Requests) {
+		pctx[PreviousRequestKey(probe.Name)] = probe.Requests[sequence-1].Name
+	}
 	if len(probeRequest.StartRequestIfExpr) > 0 {
 		out, err := GoCelEvaluate(ctx, probeRequest.StartRequestIfExpr, pctx, reflect.Bool)
 		if err != nil {
@@ -105,11 +145,6 @@ func ExecuteProbeRequest(ctx context.Context, probe *internal.Probe, probeReques
 	requestLog.Tracef("StartRequestIfExpr is OK")
 	pctx[fmt.Sprintf("probe.%s.req.%s.canstart", probe.Name, probeRequest.Name)] = true
 
-	requestLog.Tracef("Retriefing HTTP client with %d second timeout", TimeoutsSecond)
-	client := NewHttpClient(timeoutSecond, timeoutSecond, ignoreTLS)
-	var request *http.Request
-	var err error
-
 	requestLog.Tracef("Evaluating PathExpr [%s]", probeRequest.PathExpr)
 	urlItv, err := GoCelEvaluate(ctx, probeRequest.PathExpr, pctx, reflect.String)
 	if err != nil {
@@ -133,6 +168,7 @@ func ExecuteProbeRequest(ctx context.Context, probe *internal.Probe, probeReques
 	requestLog.Tracef("MethodExpr [%s] evaluated as [%s]", probeRequest.MethodExpr, METHOD)
 	pctx[fmt.Sprintf("probe.%s.req.%s.method", probe.Name, probeRequest.Name)] = METHOD
 
+	var requestBody []byte
 	if probeRequest.BodyExpr != "" {
 		requestLog.Tracef("Evaluating BodyExpr [%s]", probeRequest.BodyExpr)
 		bodyItv, err := GoCelEvaluate(ctx, probeRequest.BodyExpr, pctx, reflect.String)
@@ -144,24 +180,10 @@ func ExecuteProbeRequest(ctx context.Context, probe *internal.Probe, probeReques
 		pctx[fmt.Sprintf("probe.%s.req.%s.body", probe.Name, probeRequest.Name)] = bodyItv.(string)
 
 		requestLog.Tracef("BodyExpr [%s] evaluated as [%s]", probeRequest.BodyExpr, bodyItv.(string))
-
-		req, err := http.NewRequest(METHOD, URL, bytes.NewBuffer([]byte(bodyItv.(string))))
-		if err != nil {
-			requestLog.Errorf("Error while creating new http Request. got %s", err.Error())
-			pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
-			return fmt.Errorf("%w : got %s", errors.ErrCreateHttpClient, err.Error())
-		}
-		request = req
-	} else {
-		req, err := http.NewRequest(METHOD, URL, nil)
-		if err != nil {
-			requestLog.Errorf("Error while creating new http Request. got %s", err.Error())
-			pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
-			return fmt.Errorf("%w : got %s", errors.ErrCreateHttpRequest, err.Error())
-		}
-		request = req
+		requestBody = []byte(bodyItv.(string))
 	}
 
+	requestHeader := make(http.Header)
 	if probeRequest.HeadersExpr != nil && len(probeRequest.HeadersExpr) > 0 {
 		requestLog.Tracef("Parsing %d request headers", len(probeRequest.HeadersExpr))
 		headerKeys := make([]string, 0)
@@ -179,32 +201,100 @@ func ExecuteProbeRequest(ctx context.Context, probe *internal.Probe, probeReques
 					pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
 					return fmt.Errorf("%w : error while evaluating probe %s request %s header %s expression [%s]", err, probe.Name, probeRequest.Name, hKey, expr)
 				}
-				requestLog.Tracef("Parsing request headers [%s] = [%s] --> [%s]", hKey, expr, iv.(string))
+				requestLog.Tracef("Parsing request headers [%s] = [%s] --> [%s]", hKey, expr, internal.RedactValue(fmt.Sprintf("probe.%s.req.%s.header.%s", probe.Name, probeRequest.Name, hKey), iv))
 				headerValArr[idx] = iv.(string)
 			}
 			for _, hV := range headerValArr {
-				request.Header.Add(hKey, hV)
+				requestHeader.Add(hKey, hV)
 			}
 			pctx[fmt.Sprintf("probe.%s.req.%s.header.%s", probe.Name, probeRequest.Name, hKey)] = headerValArr
 		}
 	}
 
+	if auth := EffectiveAuth(probe, probeRequest); auth != nil {
+		requestLog.Tracef("Authenticating request with %s", auth.Type)
+		if err := Authenticate(ctx, client, pctx, probe, probeRequest, auth, requestHeader, METHOD, URL, requestBody); err != nil {
+			requestLog.Errorf("Error authenticating request. got %s", err.Error())
+			pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
+			return err
+		}
+	}
+
 	reqStartTime := time.Now()
 	pctx[fmt.Sprintf("probe.%s.req.%s.starttime", probe.Name, probeRequest.Name)] = reqStartTime
 	requestLog.Tracef("Start calling http request.")
 
-	response, err := client.Do(request)
+	requestClient := *client
+	if probeRequest.Timeout > 0 {
+		requestClient.Timeout = probeRequest.Timeout
+	}
+	retry := NewRetryPolicy(probeRequest)
 
-	pctx[fmt.Sprintf("probe.%s.req.%s.duration", probe.Name, probeRequest.Name)] = time.Now().Sub(reqStartTime)
-	requestLog.Tracef("Calling http request. Takes %s", time.Now().Sub(reqStartTime))
+	var response *http.Response
+	var captured *CapturedBody
+	var captureErr error
+	var timing *HttpTiming
+	for attempt := 1; ; attempt++ {
+		var requestBodyReader io.Reader
+		if requestBody != nil {
+			requestBodyReader = bytes.NewReader(requestBody)
+		}
+		request, err := http.NewRequestWithContext(ctx, METHOD, URL, requestBodyReader)
+		if err != nil {
+			requestLog.Errorf("Error while creating new http Request. got %s", err.Error())
+			pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
+			return fmt.Errorf("%w : got %s", errors.ErrCreateHttpRequest, err.Error())
+		}
+		request.Header = requestHeader.Clone()
 
-	if err != nil {
-		requestLog.Errorf("Calling http request. Got %s", err.Error())
-		pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
-		return fmt.Errorf("%w : http error for probe %s request %s got %s", errors.ErrHttpCallError, probe.Name, probeRequest.Name, err.Error())
+		RecordProxy(pctx, probe, probeRequest.Name, request.URL)
+
+		redirects := NewRedirectRecorder(pctx, probe.Name, probeRequest)
+		requestClient.CheckRedirect = redirects.CheckRedirect
+
+		attemptStart := time.Now()
+		timing = NewHttpTiming()
+		request = request.WithContext(httptrace.WithClientTrace(request.Context(), timing.ClientTrace()))
+		redirects.Start()
+		response, err = requestClient.Do(request)
+		if err == nil {
+			captured, captureErr = CaptureBody(response, probeRequest.BodyCapture)
+			response.Body.Close()
+		}
+		timing.Done()
+
+		class := retry.Classify(err, response)
+		retrying := attempt <= retry.Retries && retry.ShouldRetry(class)
+		RecordAttempt(pctx, probe.Name, probeRequest.Name, attempt, class, err, response, time.Now().Sub(attemptStart), retrying)
+
+		if retrying {
+			requestLog.Warnf("Attempt %d of %d failed with %s, retrying.", attempt, retry.Retries+1, class)
+			if waitErr := retry.Wait(ctx, attempt); waitErr == nil {
+				continue
+			}
+		}
+
+		pctx[fmt.Sprintf("probe.%s.req.%s.duration", probe.Name, probeRequest.Name)] = time.Now().Sub(reqStartTime)
+		requestLog.Tracef("Calling http request. Takes %s", time.Now().Sub(reqStartTime))
+		timing.Record(pctx, probe.Name, probeRequest.Name)
+		requestLog.Tracef("Http timing dns=%s connect=%s tls=%s ttfb=%s transfer=%s", timing.DNS(), timing.Connect(), timing.TLS(), timing.TTFB(), timing.Transfer())
+
+		if ctx.Err() != nil {
+			requestLog.Errorf("Calling http request is aborted. got %s", ctx.Err())
+			return RecordTimeout(ctx, pctx, probe.Name, probeRequest.Name)
+		}
+		if err != nil {
+			requestLog.Errorf("Calling http request. Got %s", err.Error())
+			pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
+			return fmt.Errorf("%w : http error for probe %s request %s got %s", errors.ErrHttpCallError, probe.Name, probeRequest.Name, err.Error())
+		}
+		break
 	}
 
+	RecordCookies(pctx, probe.Name, client, response.Request.URL)
+
 	pctx[fmt.Sprintf("probe.%s.req.%s.resp.code", probe.Name, probeRequest.Name)] = response.StatusCode
+	pctx[fmt.Sprintf("probe.%s.req.%s.resp.url", probe.Name, probeRequest.Name)] = response.Request.URL.String()
 	requestLog.Tracef("Http response code is %d", response.StatusCode)
 
 	requestLog.Tracef("Http response has %d headers", len(response.Header))
@@ -215,27 +305,66 @@ func ExecuteProbeRequest(ctx context.Context, probe *internal.Probe, probeReques
 		}
 		pctx[fmt.Sprintf("probe.%s.req.%s.resp.header", probe.Name, probeRequest.Name)] = headerKeys
 		for hKey, hVals := range response.Header {
-			requestLog.Tracef("Http response header [%s] = [%s]", hKey, strings.Join(hVals, ","))
+			requestLog.Tracef("Http response header [%s] = [%s]", hKey, internal.RedactValue(fmt.Sprintf("resp.header.%s", hKey), strings.Join(hVals, ",")))
 			pctx[fmt.Sprintf("probe.%s.req.%s.resp.header.%s", probe.Name, probeRequest.Name, hKey)] = hVals
 		}
 	}
 
-	bodyBytes, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		requestLog.Errorf("Error http response body reading. got %s", err.Error())
+	if captureErr != nil {
+		requestLog.Errorf("Error http response body reading. got %s", captureErr.Error())
 		pctx[fmt.Sprintf("probe.%s.req.%s.resp.body", probe.Name, probeRequest.Name)] = ""
 		pctx[fmt.Sprintf("probe.%s.req.%s.resp.body.size", probe.Name, probeRequest.Name)] = 0
 	} else {
-		requestLog.Tracef("Http response body size is %d bytes", len(bodyBytes))
-		pctx[fmt.Sprintf("probe.%s.req.%s.resp.body.size", probe.Name, probeRequest.Name)] = len(bodyBytes)
-		if hVals := response.Header.Values("Content-Type"); hVals != nil {
-			if strings.Contains(hVals[0], "text/") {
-				requestLog.Tracef("Http response body is [%s]", string(bodyBytes))
-				pctx[fmt.Sprintf("probe.%s.req.%s.resp.body", probe.Name, probeRequest.Name)] = string(bodyBytes)
-			} else {
-				requestLog.Tracef("Http response body is binary")
-				pctx[fmt.Sprintf("probe.%s.req.%s.resp.body", probe.Name, probeRequest.Name)] = "<binary>"
+		requestLog.Tracef("Http response body size is %d bytes of %s", captured.Size, captured.MediaType)
+		captured.Record(pctx, probe.Name, probeRequest.Name, probeRequest.BodyCapture)
+		requestLog.Tracef("Http response body is [%s]", pctx[fmt.Sprintf("probe.%s.req.%s.resp.body", probe.Name, probeRequest.Name)])
+	}
+
+	RecordTLSState(pctx, probe.Name, probeRequest.Name, response.TLS)
+	if len(probeRequest.CertificateCheckExpr) > 0 {
+		if response.TLS == nil {
+			requestLog.Errorf("response is not using TLS, CertificateCheckExpr [%s] can not be checked", probeRequest.CertificateCheckExpr)
+			pctx[fmt.Sprintf("probe.%s.req.%s.tls.check", probe.Name, probeRequest.Name)] = false
+			pctx[fmt.Sprintf("probe.%s.req.%s.success", probe.Name, probeRequest.Name)] = false
+			pctx[fmt.Sprintf("probe.%s.req.%s.fail", probe.Name, probeRequest.Name)] = true
+			return fmt.Errorf("%w : probe %s request %s response is not using tls", errors.ErrCertificateCheckNoTLS, probe.Name, probeRequest.Name)
+		} else {
+			requestLog.Tracef("Evaluating CertificateCheckExpr [%s]", probeRequest.CertificateCheckExpr)
+			out, err := GoCelEvaluate(ctx, probeRequest.CertificateCheckExpr, pctx, reflect.Bool)
+			if err != nil {
+				pctx[fmt.Sprintf("probe.%s.req.%s.tls.check", probe.Name, probeRequest.Name)] = false
+				pctx[fmt.Sprintf("probe.%s.req.%s.success", probe.Name, probeRequest.Name)] = false
+				pctx[fmt.Sprintf("probe.%s.req.%s.fail", probe.Name, probeRequest.Name)] = true
+				pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
+				requestLog.Errorf("error when evaluating CertificateCheckExpr [%s]. got %s", probeRequest.CertificateCheckExpr, err.Error())
+				return fmt.Errorf("%w : probe %s request %s parsing CertificateCheckExpr parsing error [%s]", err, probe.Name, probeRequest.Name, probeRequest.CertificateCheckExpr)
 			}
+			if !out.(bool) {
+				requestLog.Errorf("evaluation of CertificateCheckExpr [%s] yields a %v", probeRequest.CertificateCheckExpr, out.(bool))
+				pctx[fmt.Sprintf("probe.%s.req.%s.tls.check", probe.Name, probeRequest.Name)] = false
+				pctx[fmt.Sprintf("probe.%s.req.%s.success", probe.Name, probeRequest.Name)] = false
+				pctx[fmt.Sprintf("probe.%s.req.%s.fail", probe.Name, probeRequest.Name)] = true
+				return fmt.Errorf("%w : probe %s request %s CertificateCheckExpr criteria returns false", errors.ErrCertificateCheckFalse, probe.Name, probeRequest.Name)
+			}
+			pctx[fmt.Sprintf("probe.%s.req.%s.tls.check", probe.Name, probeRequest.Name)] = true
+		}
+	}
+
+	if len(probeRequest.ResponseSchema) > 0 {
+		requestLog.Tracef("Validating response body against schema [%s]", probeRequest.ResponseSchema)
+		if err := ValidateResponseSchema(pctx, probe, probeRequest); err != nil {
+			requestLog.Errorf("Error validating response body. got %s", err.Error())
+			pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
+			return err
+		}
+	}
+
+	if len(probeRequest.Extract) > 0 {
+		requestLog.Tracef("Extracting %d variables", len(probeRequest.Extract))
+		if err := ExtractVariables(ctx, pctx, probe, probeRequest); err != nil {
+			requestLog.Errorf("Error extracting variables. got %s", err.Error())
+			pctx[fmt.Sprintf("probe.%s.req.%s.error", probe.Name, probeRequest.Name)] = err
+			return err
 		}
 	}
 
@@ -280,5 +409,10 @@ func ExecuteProbeRequest(ctx context.Context, probe *internal.Probe, probeReques
 	pctx[fmt.Sprintf("probe.%s.req.%s.fail", probe.Name, probeRequest.Name)] = false
 	pctx[fmt.Sprintf("probe.%s.req.%s.success", probe.Name, probeRequest.Name)] = true
 
+	if err := EvaluateRequestStatus(ctx, pctx, probe, probeRequest); err != nil {
+		requestLog.Errorf("error when evaluating DegradedIfExpr [%s]. got %s", probeRequest.DegradedIfExpr, err.Error())
+		return err
+	}
+	requestLog.Tracef("Request status is %s", pctx[fmt.Sprintf("probe.%s.req.%s.status", probe.Name, probeRequest.Name)])
 	return nil
 }
@@ -1,12 +1,24 @@
 package probing
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/newm4n/mihp/internal"
 	"github.com/newm4n/mihp/internal/probing/dummy"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -42,10 +54,9 @@ func TestProbe_Chaining(t *testing.T) {
 		HeadersExpr: map[string][]string{
 			"User-Agent": {"\"mihp/1.0.0 mihp is http probe\""},
 		},
-		BodyExpr:             "",
-		CertificateCheckExpr: "false",
-		SuccessIfExpr:        `IsDefined("probe.Local.req.Login.resp.code") && GetInt("probe.Local.req.Login.resp.code")==200`,
-		FailIfExpr:           "",
+		BodyExpr:      "",
+		SuccessIfExpr: `IsDefined("probe.Local.req.Login.resp.code") && GetInt("probe.Local.req.Login.resp.code")==200`,
+		FailIfExpr:    "",
 	}
 	probe.Requests = append(probe.Requests, req1)
 
@@ -57,11 +68,10 @@ func TestProbe_Chaining(t *testing.T) {
 			"User-Agent":    {`"mihp/1.0.0 mihp is http probe"`},
 			"Authorization": {`GetStringElem("probe.Local.req.Login.resp.header.Testtoken",0)`},
 		},
-		BodyExpr:             "",
-		CertificateCheckExpr: "false",
-		StartRequestIfExpr:   `IsDefined("probe.Local.req.Login.success") && GetBool("probe.Local.req.Login.success") == true `,
-		SuccessIfExpr:        `IsDefined("probe.Local.req.Dashboard.resp.code") && GetInt("probe.Local.req.Dashboard.resp.code")==200`,
-		FailIfExpr:           "",
+		BodyExpr:           "",
+		StartRequestIfExpr: `IsDefined("probe.Local.req.Login.success") && GetBool("probe.Local.req.Login.success") == true `,
+		SuccessIfExpr:      `IsDefined("probe.Local.req.Dashboard.resp.code") && GetInt("probe.Local.req.Dashboard.resp.code")==200`,
+		FailIfExpr:         "",
 	}
 	probe.Requests = append(probe.Requests, req2)
 
@@ -106,3 +116,515 @@ func TestProbe_ExecuteGoogle(t *testing.T) {
 	assert.True(t, pCtx["probe.Google.success"].(bool))
 	t.Log(pCtx.ToString(false))
 }
+
+func TestProbe_CertificateCheck(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Content-Type", "text/plain")
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte("OK"))
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name:     "Secure",
+		ID:       "1002",
+		Requests: make([]*internal.ProbeRequest, 0),
+		BaseURL:  srv.URL,
+		Cron:     "* * * * * * *",
+	}
+	req := &internal.ProbeRequest{
+		Name:                 "Home",
+		PathExpr:             `"/"`,
+		MethodExpr:           `"GET"`,
+		CertificateCheckExpr: `GetInt("probe.Secure.req.Home.tls.cert.daystoexpire") > 30 && GetString("probe.Secure.req.Home.tls.cert.issuer").contains("Acme Co")`,
+	}
+	probe.Requests = append(probe.Requests, req)
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.True(t, pCtx["probe.Secure.req.Home.tls.check"].(bool))
+	assert.Contains(t, pCtx["probe.Secure.req.Home.tls.cert.san"].([]string), "example.com")
+	assert.NotEmpty(t, pCtx["probe.Secure.req.Home.tls.version"])
+
+	req.CertificateCheckExpr = `GetString("probe.Secure.req.Home.tls.cert.issuer").contains("Let's Encrypt")`
+	pCtx = internal.NewProbeContext()
+	err := ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, mihperrors.ErrCertificateCheckFalse))
+	assert.False(t, pCtx["probe.Secure.req.Home.tls.check"].(bool))
+	assert.False(t, pCtx["probe.Secure.success"].(bool))
+
+	plain := httptest.NewServer(srv.Config.Handler)
+	defer plain.Close()
+	probe.BaseURL = plain.URL
+	req.CertificateCheckExpr = `GetInt("probe.Secure.req.Home.tls.cert.daystoexpire") > 30`
+	pCtx = internal.NewProbeContext()
+	err = ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, mihperrors.ErrCertificateCheckNoTLS))
+	assert.False(t, pCtx["probe.Secure.req.Home.tls.check"].(bool))
+	assert.True(t, pCtx["probe.Secure.req.Home.fail"].(bool))
+	assert.False(t, pCtx["probe.Secure.success"].(bool))
+}
+
+func TestProbe_Timing(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		resp.Header().Add("Content-Type", "text/plain")
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte("OK"))
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name:     "Timed",
+		ID:       "1003",
+		Requests: make([]*internal.ProbeRequest, 0),
+		BaseURL:  srv.URL,
+		Cron:     "* * * * * * *",
+	}
+	req := &internal.ProbeRequest{
+		Name:          "Home",
+		PathExpr:      `"/"`,
+		MethodExpr:    `"GET"`,
+		SuccessIfExpr: `probe.Timed.req.Home.timing.ttfb < duration("800ms") && GetDuration("probe.Timed.req.Home.timing.server") >= duration("100ms")`,
+	}
+	probe.Requests = append(probe.Requests, req)
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.True(t, pCtx["probe.Timed.req.Home.timing.connect"].(time.Duration) > 0)
+	assert.True(t, pCtx["probe.Timed.req.Home.timing.tls"].(time.Duration) > 0)
+	assert.True(t, pCtx["probe.Timed.req.Home.timing.ttfb"].(time.Duration) >= pCtx["probe.Timed.req.Home.timing.server"].(time.Duration))
+
+	req.SuccessIfExpr = `probe.Timed.req.Home.timing.ttfb < duration("10ms")`
+	pCtx = internal.NewProbeContext()
+	assert.Error(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+}
+
+func TestProbe_CookieSession(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Content-Type", "text/plain")
+		switch req.URL.Path {
+		case "/login":
+			http.SetCookie(resp, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+			resp.WriteHeader(http.StatusOK)
+		case "/dashboard":
+			if c, err := req.Cookie("session"); err != nil || c.Value != "s3cr3t" {
+				resp.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			resp.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name:     "Session",
+		ID:       "1004",
+		Requests: make([]*internal.ProbeRequest, 0),
+		BaseURL:  srv.URL,
+		Cron:     "* * * * * * *",
+	}
+	probe.Requests = append(probe.Requests, &internal.ProbeRequest{
+		Name:          "Login",
+		PathExpr:      `"/login"`,
+		MethodExpr:    `"GET"`,
+		SuccessIfExpr: `GetString("probe.Session.cookie.session") == "s3cr3t"`,
+	}, &internal.ProbeRequest{
+		Name:          "Dashboard",
+		PathExpr:      `"/dashboard"`,
+		MethodExpr:    `"GET"`,
+		SuccessIfExpr: `probe.Session.req.Dashboard.resp.code == 200`,
+	})
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, []string{"session"}, pCtx["probe.Session.cookie"])
+	assert.False(t, pCtx["probe.Session.req.Login.timing.reused"].(bool))
+	assert.True(t, pCtx["probe.Session.req.Dashboard.timing.reused"].(bool))
+
+	probe.FreshConnection = true
+	pCtx = internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.False(t, pCtx["probe.Session.req.Dashboard.timing.reused"].(bool))
+}
+
+func TestProbe_Redirect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/":
+			http.Redirect(resp, req, "/a", http.StatusMovedPermanently)
+		case "/a":
+			http.Redirect(resp, req, "/b", http.StatusFound)
+		default:
+			resp.Header().Add("Content-Type", "text/plain")
+			resp.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	req := &internal.ProbeRequest{
+		Name:       "Root",
+		PathExpr:   `"/"`,
+		MethodExpr: `"GET"`,
+	}
+	probe := &internal.Probe{
+		Name:     "Redirect",
+		ID:       "1005",
+		Requests: []*internal.ProbeRequest{req},
+		BaseURL:  srv.URL,
+		Cron:     "* * * * * * *",
+	}
+
+	req.SuccessIfExpr = `probe.Redirect.req.Root.resp.code == 200 && probe.Redirect.req.Root.redirect.count == 2 && GetInt("probe.Redirect.req.Root.redirect.0.code") == 301`
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, srv.URL+"/b", pCtx["probe.Redirect.req.Root.resp.url"])
+	assert.Equal(t, []int{301, 302}, pCtx["probe.Redirect.req.Root.redirect.code"])
+
+	noFollow := false
+	req.FollowRedirects = &noFollow
+	req.SuccessIfExpr = `probe.Redirect.req.Root.resp.code == 301 && GetStringElem("probe.Redirect.req.Root.resp.header.Location", 0) == "/a"`
+	pCtx = internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+
+	req.FollowRedirects = nil
+	req.MaxRedirects = 1
+	req.SuccessIfExpr = ""
+	pCtx = internal.NewProbeContext()
+	err := ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrHttpCallError))
+	assert.Equal(t, 2, pCtx["probe.Redirect.req.Root.redirect.count"])
+}
+
+func TestProbe_RetryRedirect(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/":
+			attempts++
+			if attempts == 1 {
+				http.Redirect(resp, req, "/a", http.StatusMovedPermanently)
+			} else {
+				http.Redirect(resp, req, "/b", http.StatusFound)
+			}
+		case "/a":
+			http.Redirect(resp, req, "/b", http.StatusFound)
+		default:
+			if attempts == 1 {
+				resp.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				resp.WriteHeader(http.StatusOK)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Redirect",
+		ID:   "1006",
+		Requests: []*internal.ProbeRequest{{
+			Name:          "Root",
+			PathExpr:      `"/"`,
+			MethodExpr:    `"GET"`,
+			Retries:       1,
+			RetryOn:       []string{"5xx"},
+			RetryBackoff:  time.Millisecond,
+			SuccessIfExpr: `probe.Redirect.req.Root.resp.code == 200`,
+		}},
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, 1, pCtx["probe.Redirect.req.Root.redirect.count"])
+	assert.Equal(t, []int{302}, pCtx["probe.Redirect.req.Root.redirect.code"])
+	assert.Equal(t, srv.URL+"/b", pCtx["probe.Redirect.req.Root.redirect.0.location"])
+	_, stale := pCtx["probe.Redirect.req.Root.redirect.1.url"]
+	assert.False(t, stale)
+}
+
+func TestProbe_BodyCapture(t *testing.T) {
+	jsonBody := `{"status":"ok","data":{"token":"abc"}}`
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api":
+			resp.Header().Add("Content-Type", "application/problem+json; charset=utf-8")
+			resp.Header().Add("Content-Encoding", "gzip")
+			resp.WriteHeader(http.StatusOK)
+			gz := gzip.NewWriter(resp)
+			gz.Write([]byte(jsonBody))
+			gz.Close()
+		case "/image":
+			resp.Header().Add("Content-Type", "image/png")
+			resp.WriteHeader(http.StatusOK)
+			resp.Write([]byte{0x89, 'P', 'N', 'G', 1, 2, 3, 4})
+		}
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name:    "Api",
+		ID:      "1006",
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:       "Json",
+				PathExpr:   `"/api"`,
+				MethodExpr: `"GET"`,
+				HeadersExpr: map[string][]string{
+					"Accept-Encoding": {`"gzip"`},
+				},
+				SuccessIfExpr: `GetJsonStringValue(probe.Api.req.Json.resp.body, "data.token") == "abc"`,
+			},
+			{
+				Name:        "Image",
+				PathExpr:    `"/image"`,
+				MethodExpr:  `"GET"`,
+				BodyCapture: &internal.BodyCapture{MaxSize: 4, Base64Binary: true},
+			},
+		},
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	sum := sha256.Sum256([]byte(jsonBody))
+	assert.Equal(t, hex.EncodeToString(sum[:]), pCtx["probe.Api.req.Json.resp.body.sha256"])
+	assert.Equal(t, "gzip", pCtx["probe.Api.req.Json.resp.body.encoding"])
+	assert.Equal(t, len(jsonBody), pCtx["probe.Api.req.Json.resp.body.size"])
+
+	assert.Equal(t, "<binary>", pCtx["probe.Api.req.Image.resp.body"])
+	assert.Equal(t, 8, pCtx["probe.Api.req.Image.resp.body.size"])
+	assert.True(t, pCtx["probe.Api.req.Image.resp.body.truncated"].(bool))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G'}), pCtx["probe.Api.req.Image.resp.body.base64"])
+}
+
+func TestProbe_Proxy(t *testing.T) {
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Content-Type", "text/plain")
+		if req.Header.Get("Proxy-Authorization") == "" {
+			resp.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte(req.URL.String()))
+	}))
+	defer proxySrv.Close()
+
+	probe := &internal.Probe{
+		Name:    "Proxied",
+		ID:      "1008",
+		BaseURL: "http://intranet.invalid",
+		Cron:    "* * * * * * *",
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:          "Home",
+				PathExpr:      `"/home"`,
+				MethodExpr:    `"GET"`,
+				SuccessIfExpr: `probe.Proxied.req.Home.resp.body == "http://intranet.invalid/home"`,
+			},
+		},
+	}
+
+	DefaultProxy = &internal.ProxyConfig{URL: proxySrv.URL, Username: "user", Password: "secret"}
+	defer func() {
+		DefaultProxy = nil
+	}()
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, proxySrv.URL, pCtx["probe.Proxied.proxy"])
+	assert.Equal(t, proxySrv.URL, pCtx["probe.Proxied.req.Home.proxy"])
+
+	probe.Proxy = &internal.ProxyConfig{URL: proxySrv.URL, NoProxy: []string{".invalid"}}
+	pCtx = internal.NewProbeContext()
+	assert.Error(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, "DIRECT", pCtx["probe.Proxied.req.Home.proxy"])
+}
+
+func TestProbe_Deadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			select {
+			case <-req.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Deadline",
+		ID:   "1009",
+		Requests: []*internal.ProbeRequest{
+			{Name: "Fast", PathExpr: `"/fast"`, MethodExpr: `"GET"`},
+			{Name: "Slow", PathExpr: `"/slow"`, MethodExpr: `"GET"`},
+		},
+		BaseURL:  srv.URL,
+		Cron:     "* * * * * * *",
+		Deadline: 300 * time.Millisecond,
+	}
+
+	start := time.Now()
+	pCtx := internal.NewProbeContext()
+	err := ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.True(t, IsTimeout(err))
+	assert.False(t, errors.Is(err, mihperrors.ErrHttpCallError))
+	assert.Equal(t, OutcomeTimeout, pCtx["probe.Deadline.outcome"])
+	assert.Equal(t, OutcomeTimeout, pCtx["probe.Deadline.req.Slow.outcome"])
+	assert.Equal(t, 200, pCtx["probe.Deadline.req.Fast.resp.code"])
+
+	// cancelling the parent context aborts the in-flight call as well
+	probe.Deadline = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	pCtx = internal.NewProbeContext()
+	err = ExecuteProbe(ctx, probe, pCtx, 10, true, true)
+	assert.True(t, time.Since(start) < 2*time.Second)
+	assert.True(t, IsTimeout(err))
+}
+
+func TestProbe_Extract(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/login":
+			resp.Header().Add("Content-Type", "application/json")
+			resp.WriteHeader(http.StatusOK)
+			resp.Write([]byte(`{"data":{"token":"abc","ttl":60}}`))
+		case "/items/60":
+			if req.Header.Get("Authorization") != "Bearer abc" {
+				resp.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			resp.WriteHeader(http.StatusOK)
+		default:
+			resp.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Extract",
+		ID:   "1010",
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:       "Login",
+				PathExpr:   `"/login"`,
+				MethodExpr: `"GET"`,
+				Extract: map[string]string{
+					"token": `GetJsonStringValue(probe.Extract.req.Login.resp.body, "data.token")`,
+					"ttl":   `GetJsonIntValue(probe.Extract.req.Login.resp.body, "data.ttl")`,
+				},
+			},
+			{
+				Name:       "Items",
+				PathExpr:   `"/items/" + string(vars.ttl)`,
+				MethodExpr: `"GET"`,
+				HeadersExpr: map[string][]string{
+					"Authorization": {`"Bearer " + vars.token`},
+				},
+				SuccessIfExpr: `probe.Extract.req.Items.resp.code == 200`,
+			},
+		},
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, "abc", pCtx["vars.token"])
+	assert.Equal(t, 60, pCtx["vars.ttl"])
+
+	probe.Requests[0].Extract["bad-name"] = `"x"`
+	pCtx = internal.NewProbeContext()
+	err := ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrExtractError))
+}
+
+func TestProbe_ScopedContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/login":
+			http.SetCookie(resp, &http.Cookie{Name: "session", Value: "s1"})
+			resp.WriteHeader(http.StatusOK)
+			resp.Write([]byte("welcome"))
+		case "/home":
+			resp.WriteHeader(http.StatusAccepted)
+		default:
+			resp.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Scoped",
+		ID:   "1011",
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:          "Login",
+				PathExpr:      `"/login"`,
+				MethodExpr:    `"GET"`,
+				SuccessIfExpr: `this.resp.code == 200 && this.resp.body == "welcome"`,
+			},
+			{
+				Name:               "Home",
+				StartRequestIfExpr: `prev.resp.code == 200 && size(req["Login"].resp.header["Set-Cookie"]) == 1`,
+				PathExpr:           `"/home"`,
+				MethodExpr:         `"GET"`,
+				SuccessIfExpr:      `this.resp.code == 202 && req.Login.resp.code == 200 && "code" in this.resp && prev == req["Login"]`,
+			},
+		},
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, true, pCtx["probe.Scoped.req.Home.success"])
+	assert.Equal(t, "Home", pCtx[CurrentRequestKey("Scoped")])
+	assert.Equal(t, "Login", pCtx[PreviousRequestKey("Scoped")])
+
+	// the flat keys are still available along the aliases
+	out, err := GoCelEvaluate(context.Background(), `probe.Scoped.req.Login.resp.code == req.Login.resp.code`, pCtx, reflect.Bool)
+	assert.NoError(t, err)
+	assert.Equal(t, true, out)
+}
+
+func TestProbe_RedactHeaderTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	buff := &bytes.Buffer{}
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(buff)
+	defer logrus.SetOutput(os.Stderr)
+
+	probe := &internal.Probe{
+		Name:     "Login",
+		ID:       "1010",
+		Requests: make([]*internal.ProbeRequest, 0),
+		BaseURL:  srv.URL,
+		Cron:     "* * * * * * *",
+	}
+	probe.Requests = append(probe.Requests, &internal.ProbeRequest{
+		Name:       "Token",
+		PathExpr:   `"/"`,
+		MethodExpr: `"GET"`,
+		HeadersExpr: map[string][]string{
+			"Authorization": {`"Bearer " + "tr4ce" + "-t0ken"`},
+		},
+	})
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Contains(t, buff.String(), "Parsing request headers [Authorization]")
+	assert.False(t, strings.Contains(buff.String(), "tr4ce-t0ken"))
+}
@@ -0,0 +1,119 @@
+package probing
+
+import (
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/errors"
+	"log"
+	"time"
+)
+
+// Clock returns the current time, tests replace it with a fake one.
+type Clock func() time.Time
+
+// EscalationTrigger is called while a probe stays DOWN. Since is when the probe went DOWN, count how many times
+// the outage was notified already, and escalated whether the escalation recipients must be notified too.
+type EscalationTrigger func(probeName, probeId string, since time.Time, down time.Duration, count int, escalated bool)
+
+func LogEscalation(probeName, probeId string, since time.Time, down time.Duration, count int, escalated bool) {
+	if escalated {
+		log.Printf("Probe %s [%s] is still DOWN since %s for %s, notification %d, escalated", probeName, probeId, since.Format(time.RFC3339), down.String(), count+1)
+	} else {
+		log.Printf("Probe %s [%s] is still DOWN since %s for %s, notification %d", probeName, probeId, since.Format(time.RFC3339), down.String(), count+1)
+	}
+}
+
+// DownDuration returns how long the probe is DOWN at the time, zero if it is not.
+func (t *ProbeEventTracker) DownDuration(now time.Time) time.Duration {
+	if t.Status != StatusDown {
+		return 0
+	}
+	return now.Sub(t.FirstDown)
+}
+
+// Escalate calls the trigger if the DOWN probe is due for another notification or for escalation under the policy.
+// Nothing is notified before the DOWN status itself was, once the outage is acknowledged, nor while the probe
+// is in a maintenance window.
+// Returns true if the trigger is called.
+func (t *ProbeEventTracker) Escalate(policy *internal.EscalationPolicy, now time.Time, trigger EscalationTrigger) bool {
+	if policy == nil || t.Status != StatusDown || t.Notifications == 0 || t.Acknowledged {
+		return false
+	}
+	if t.UpDownHistory != nil && t.UpDownHistory.Len() > 0 && t.UpDownHistory.Back().Value.(*DownHistory).Maintenance {
+		return false
+	}
+	down := t.DownDuration(now)
+	escalate := policy.EscalateAfter > 0 && !t.Escalated && down >= policy.EscalateAfter
+	renotify := policy.RenotifyEvery > 0 && now.Sub(t.Notified) >= policy.RenotifyEvery
+	if !escalate && !renotify {
+		return false
+	}
+	t.Escalated = t.Escalated || escalate
+	trigger(t.ProbeName, t.ProbeID, t.FirstDown, down, t.Notifications, t.Escalated)
+	t.Notified = now
+	t.Notifications++
+	return true
+}
+
+// resetEscalation starts the notification count of a new status notified at the time.
+func (t *ProbeEventTracker) resetEscalation(at time.Time) {
+	t.Notified = at
+	t.Notifications = 1
+	t.Escalated = false
+	t.Acknowledged = false
+	t.AcknowledgedBy = ""
+}
+
+// Acknowledgement tells who acknowledged the outage of a probe, and when.
+type Acknowledgement struct {
+	ProbeID string    `json:"probe_id"`
+	By      string    `json:"by"`
+	At      time.Time `json:"at"`
+}
+
+// CheckEscalation escalates every tracker whose probe has an escalation policy, at the time of the Clock.
+func (proc *ProbeEventProcessor) CheckEscalation() {
+	proc.mutex.Lock()
+	defer proc.mutex.Unlock()
+	escalated := false
+	for _, t := range proc.Trackers {
+		escalated = proc.escalate(t) || escalated
+	}
+	if escalated {
+		if err := proc.SaveState(); err != nil {
+			stateLog.Errorf("can not save state to %s. got %s", proc.StateFile, err.Error())
+		}
+	}
+}
+
+func (proc *ProbeEventProcessor) escalate(t *ProbeEventTracker) bool {
+	probe, ok := proc.Probes[t.ProbeName]
+	if !ok {
+		return false
+	}
+	now, trigger := time.Now, proc.Escalation
+	if proc.Clock != nil {
+		now = proc.Clock
+	}
+	if trigger == nil {
+		trigger = LogEscalation
+	}
+	return t.Escalate(probe.Escalation, now(), trigger)
+}
+
+// Acknowledge stops the re-notification and escalation of the DOWN probe until its status changes.
+// An acknowledgement made at the time only applies to an outage that started before.
+func (proc *ProbeEventProcessor) Acknowledge(probeName, by string, at time.Time) error {
+	proc.mutex.Lock()
+	defer proc.mutex.Unlock()
+	t := proc.Tracker(probeName)
+	if t == nil || t.Status != StatusDown || t.FirstDown.After(at) {
+		return fmt.Errorf("%w : probe %s", errors.ErrProbeNotDown, probeName)
+	}
+	if t.Acknowledged {
+		return nil
+	}
+	t.Acknowledged = true
+	t.AcknowledgedBy = by
+	return proc.SaveState()
+}
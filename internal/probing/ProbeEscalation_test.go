@@ -0,0 +1,64 @@
+package probing
+
+import (
+	"errors"
+	"github.com/newm4n/mihp/internal"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProbeEventProcessor_Escalation(t *testing.T) {
+	type escalation struct {
+		down      time.Duration
+		count     int
+		escalated bool
+	}
+	escalations := make([]escalation, 0)
+	statuses := make([]string, 0)
+	eventProc := NewProbeEventProcessor(func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+		statuses = append(statuses, status)
+	}, &internal.Probe{Name: "dummy", UpThreshold: 1, DownThreshold: 1, Escalation: &internal.EscalationPolicy{
+		RenotifyEvery: 10 * time.Minute,
+		EscalateAfter: 25 * time.Minute,
+	}})
+	eventProc.Escalation = func(probeName, probeId string, since time.Time, down time.Duration, count int, escalated bool) {
+		escalations = append(escalations, escalation{down: down, count: count, escalated: escalated})
+	}
+	start := time.Date(2021, 10, 17, 2, 0, 0, 0, time.UTC)
+	now := start
+	eventProc.Clock = func() time.Time { return now }
+
+	results := DummyContext("dummy", "123456789", start, time.Minute, []bool{true, false})
+	for _, ctx := range results {
+		eventProc.AcceptProbeContext(ctx)
+	}
+	assert.Equal(t, []string{StatusUp, StatusDown}, statuses)
+
+	down := start.Add(time.Minute)
+	for _, minutes := range []time.Duration{9, 10, 20, 25, 30} {
+		now = down.Add(minutes * time.Minute)
+		eventProc.CheckEscalation()
+	}
+	assert.Equal(t, []escalation{
+		{down: 10 * time.Minute, count: 1},
+		{down: 20 * time.Minute, count: 2},
+		{down: 25 * time.Minute, count: 3, escalated: true},
+	}, escalations)
+	tracker := eventProc.Tracker("dummy")
+	assert.Equal(t, 30*time.Minute, tracker.DownDuration(now))
+
+	assert.True(t, errors.Is(eventProc.Acknowledge("dummy", "ops@example.com", start), mihperrors.ErrProbeNotDown))
+	assert.NoError(t, eventProc.Acknowledge("dummy", "ops@example.com", now))
+	now = down.Add(time.Hour)
+	eventProc.CheckEscalation()
+	assert.Len(t, escalations, 3)
+
+	up := DummyContext("dummy", "123456789", now, time.Minute, []bool{true})[0]
+	eventProc.AcceptProbeContext(up)
+	assert.Equal(t, []string{StatusUp, StatusDown, StatusUp}, statuses)
+	assert.False(t, tracker.Acknowledged)
+	assert.Equal(t, time.Duration(0), tracker.DownDuration(now))
+	assert.True(t, errors.Is(eventProc.Acknowledge("dummy", "ops@example.com", now), mihperrors.ErrProbeNotDown))
+}
@@ -4,99 +4,183 @@ import (
 	"container/list"
 	"fmt"
 	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/helper"
 	"log"
+	"strings"
+	"sync"
 	"time"
 )
 
-type Trigger func(probeName, probeId string, down bool, firstUp, lastUp, firstDown, lastDown time.Time)
+const (
+	// DefaultUpThreshold and DefaultDownThreshold are the consecutive results needed to change status,
+	// when the probe does not configure them. DOWN threshold applies to DEGRADED as well.
+	DefaultUpThreshold   = 3
+	DefaultDownThreshold = 3
+	// DefaultFlapWindow is how many of the latest results are checked for flapping,
+	// DefaultFlapThreshold how many status changes among them make the probe FLAPPING.
+	DefaultFlapWindow    = 20
+	DefaultFlapThreshold = 8
+	// HistorySize is how many results a tracker keeps, it bounds the flap window.
+	HistorySize = 30
+)
 
-func LogTrigger(probeName, probeId string, down bool, firstUp, lastUp, firstDown, lastDown time.Time) {
-	if !down {
-		if lastUp.Sub(firstDown) > 24*365*time.Hour {
-			log.Printf("Probe %s [%s] is detected online for the first time this year at %s", probeName, probeId, firstUp.Format(time.RFC3339))
-		} else {
-			log.Printf("Probe %s [%s] is back up at %s after downed for %s", probeName, probeId, firstUp.Format(time.RFC3339), firstUp.Sub(firstDown).String())
-		}
+// Trigger is called when a probe changes status. Since is when the new status started, previousSince when the
+// previous status started. Latency summarizes the probe durations of the last hour.
+type Trigger func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary)
+
+func LogTrigger(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+	if since.Sub(previousSince) > 24*365*time.Hour {
+		log.Printf("Probe %s [%s] is detected %s for the first time this year at %s, latency %s", probeName, probeId, status, since.Format(time.RFC3339), latency)
 	} else {
-		if lastDown.Sub(firstUp) > 24*365*time.Hour {
-			log.Printf("Probe %s [%s] is downed for the first time this year at %s", probeName, probeId, firstDown.Format(time.RFC3339))
-		} else {
-			log.Printf("Probe %s [%s] is downed at %s after on-line for %s", probeName, probeId, firstDown.Format(time.RFC3339), firstDown.Sub(firstUp).String())
-		}
+		log.Printf("Probe %s [%s] is %s at %s after %s for %s, latency %s", probeName, probeId, status, since.Format(time.RFC3339), previous, since.Sub(previousSince).String(), latency)
 	}
 }
 
-func NewProbeEventProcessor(trigger Trigger) *ProbeEventProcessor {
-	if trigger != nil {
-		return &ProbeEventProcessor{Trigger: trigger}
+// NewProbeEventProcessor creates a processor calling the trigger on status changes, LogTrigger if nil.
+// The probes give the thresholds of their trackers, other probes use the defaults.
+func NewProbeEventProcessor(trigger Trigger, probes ...*internal.Probe) *ProbeEventProcessor {
+	if trigger == nil {
+		trigger = LogTrigger
+	}
+	proc := &ProbeEventProcessor{Trigger: trigger, Escalation: LogEscalation, Clock: time.Now, Probes: make(map[string]*internal.Probe)}
+	for _, probe := range probes {
+		proc.Probes[probe.Name] = probe
 	}
-	return &ProbeEventProcessor{Trigger: LogTrigger}
+	return proc
 }
 
 type ProbeEventProcessor struct {
 	Trackers []*ProbeEventTracker
 	Trigger  Trigger
+	Probes   map[string]*internal.Probe
+	// Escalation is called while a probe with an escalation policy stays DOWN, Clock tells when that is.
+	Escalation EscalationTrigger
+	Clock      Clock
+	// StateFile, if set, is where the trackers are saved after every accepted probe context, see RestoreState.
+	StateFile string
+
+	mutex sync.Mutex
 }
 
-func (proc *ProbeEventProcessor) AcceptProbeContext(pbctx internal.ProbeContext) *ProbeEventTracker {
-	if proc.Trackers == nil {
-		proc.Trackers = make([]*ProbeEventTracker, 0)
-	}
+// Tracker returns the tracker of the probe, nil if no result of the probe was accepted yet.
+func (proc *ProbeEventProcessor) Tracker(probeName string) *ProbeEventTracker {
 	for _, t := range proc.Trackers {
-		if t.ProbeName == pbctx["probe"].(string) {
-			t.AcceptProbeContext(pbctx, proc.Trigger)
+		if t.ProbeName == probeName {
 			return t
 		}
 	}
+	return nil
+}
+
+func (proc *ProbeEventProcessor) AcceptProbeContext(pbctx internal.ProbeContext) *ProbeEventTracker {
+	proc.mutex.Lock()
+	defer proc.mutex.Unlock()
+	if proc.Trackers == nil {
+		proc.Trackers = make([]*ProbeEventTracker, 0)
+	}
 	name := pbctx["probe"].(string)
-	id := pbctx[fmt.Sprintf("probe.%s.id", name)].(string)
-	t := &ProbeEventTracker{
-		ProbeID:          id,
-		ProbeName:        name,
-		FailThreshold:    2,
-		SuccessThreshold: 2,
-		FailCount:        0,
-		SuccessCount:     0,
-		LastDown:         time.UnixMilli(0),
-		LastUp:           time.UnixMilli(0),
-		LastStatusDown:   true,
+	t := proc.Tracker(name)
+	if t == nil {
+		id := pbctx[fmt.Sprintf("probe.%s.id", name)].(string)
+		t = NewProbeEventTracker(name, id, proc.Probes[name])
+		proc.Trackers = append(proc.Trackers, t)
 	}
 	t.AcceptProbeContext(pbctx, proc.Trigger)
-	proc.Trackers = append(proc.Trackers, t)
+	proc.escalate(t)
+	if err := proc.SaveState(); err != nil {
+		stateLog.Errorf("can not save state to %s. got %s", proc.StateFile, err.Error())
+	}
+	return t
+}
+
+// NewProbeEventTracker creates a DOWN tracker using the thresholds of the probe, or the defaults if probe is nil
+// or leaves them zero.
+func NewProbeEventTracker(name, id string, probe *internal.Probe) *ProbeEventTracker {
+	t := &ProbeEventTracker{
+		ProbeID:           id,
+		ProbeName:         name,
+		FailThreshold:     DefaultDownThreshold,
+		SuccessThreshold:  DefaultUpThreshold,
+		DegradedThreshold: DefaultDownThreshold,
+		FlapWindow:        DefaultFlapWindow,
+		FlapThreshold:     DefaultFlapThreshold,
+		FailCount:         0,
+		SuccessCount:      0,
+		DegradedCount:     0,
+		LastDown:          time.UnixMilli(0),
+		LastUp:            time.UnixMilli(0),
+		LastDegraded:      time.UnixMilli(0),
+		LastFlapping:      time.UnixMilli(0),
+		Status:            StatusDown,
+	}
+	if probe != nil {
+		if probe.UpThreshold > 0 {
+			t.SuccessThreshold = probe.UpThreshold
+		}
+		if probe.DownThreshold > 0 {
+			t.FailThreshold = probe.DownThreshold
+			t.DegradedThreshold = probe.DownThreshold
+		}
+		if probe.FlapWindow > 0 {
+			t.FlapWindow = probe.FlapWindow
+		}
+		if probe.FlapThreshold > 0 {
+			t.FlapThreshold = probe.FlapThreshold
+		}
+	}
+	if t.FlapWindow > HistorySize {
+		t.FlapWindow = HistorySize
+	}
 	return t
 }
 
 type ProbeEventTracker struct {
-	ProbeID          string
-	ProbeName        string
-	FailThreshold    int
-	SuccessThreshold int
+	ProbeID           string
+	ProbeName         string
+	FailThreshold     int
+	SuccessThreshold  int
+	DegradedThreshold int
+	FlapWindow        int
+	FlapThreshold     int
 
-	FailCount    int
-	SuccessCount int
+	FailCount     int
+	SuccessCount  int
+	DegradedCount int
 
-	FirstUp   time.Time
-	LastUp    time.Time
-	FirstDown time.Time
-	LastDown  time.Time
+	FirstUp       time.Time
+	LastUp        time.Time
+	FirstDown     time.Time
+	LastDown      time.Time
+	FirstDegraded time.Time
+	LastDegraded  time.Time
+	FirstFlapping time.Time
+	LastFlapping  time.Time
 
-	LastStatusDown bool
-	UpDownHistory  *list.List
+	// Status is the last notified status, StatusUp, StatusDegraded, StatusDown or StatusFlapping.
+	Status        string
+	UpDownHistory *list.List
 
-	RequestStatistic map[string]map[int64]*DurationStatistic
-	ProbeStatistic   map[int64]*DurationStatistic
-}
+	// Notified is when the status was last notified, Notifications how many times. Escalated tells whether
+	// the escalation recipients were notified, Acknowledged whether someone stopped the re-notification.
+	Notified       time.Time
+	Notifications  int
+	Escalated      bool
+	Acknowledged   bool
+	AcknowledgedBy string
 
-type DurationStatistic struct {
-	Maximum time.Duration
-	Minimum time.Duration
-	Count   int64
-	Average time.Duration
+	RequestStatistic map[string]*LatencyStatistic
+	ProbeStatistic   *LatencyStatistic
+
+	// Downtime and MaintenanceTime are the unix seconds spent DOWN outside and inside maintenance windows.
+	Downtime        *helper.Interval
+	MaintenanceTime *helper.Interval
 }
 
 type DownHistory struct {
-	Down bool
-	Time time.Time
+	Down        bool
+	Status      string
+	Time        time.Time
+	Maintenance bool
 }
 
 func (t *ProbeEventTracker) AcceptProbeContext(pbctx internal.ProbeContext, trigger Trigger) {
@@ -107,60 +191,212 @@ func (t *ProbeEventTracker) AcceptProbeContext(pbctx internal.ProbeContext, trig
 	name := pbctx["probe"].(string)
 	id := pbctx[fmt.Sprintf("probe.%s.id", name)].(string)
 
-	// todo Record probe duration and request duration here
-
 	if name != t.ProbeName {
 		return
 	}
-	if pbctx[fmt.Sprintf("probe.%s.success", name)].(bool) {
+	t.recordLatency(pbctx)
+	at := pbctx[fmt.Sprintf("probe.%s.starttime", t.ProbeName)].(time.Time)
+	status := ContextStatus(pbctx, name)
+	switch status {
+	case StatusUp:
 		t.FailCount = 0
+		t.DegradedCount = 0
 		t.SuccessCount++
-		t.UpDownHistory.PushBack(&DownHistory{
-			Down: false,
-			Time: pbctx[fmt.Sprintf("probe.%s.starttime", t.ProbeName)].(time.Time),
-		})
-	} else {
-		t.FailCount++
+	case StatusDegraded:
+		t.FailCount = 0
 		t.SuccessCount = 0
-		t.UpDownHistory.PushBack(&DownHistory{
-			Down: true,
-			Time: pbctx[fmt.Sprintf("probe.%s.starttime", t.ProbeName)].(time.Time),
-		})
+		t.DegradedCount++
+	default:
+		t.SuccessCount = 0
+		t.DegradedCount = 0
+		t.FailCount++
 	}
+	maintenance, _ := pbctx[fmt.Sprintf("probe.%s.maintenance", name)].(bool)
+	t.recordDowntime(at)
+	t.UpDownHistory.PushBack(&DownHistory{
+		Down:        status == StatusDown,
+		Status:      status,
+		Time:        at,
+		Maintenance: maintenance,
+	})
 
-	if t.UpDownHistory.Len() > 30 {
+	if t.UpDownHistory.Len() > HistorySize {
 		t.UpDownHistory.Remove(t.UpDownHistory.Front())
 	}
 
-	if t.FailCount > t.FailThreshold && !t.LastStatusDown {
-		t.LastStatusDown = true
+	// results within a maintenance window never change the status, a probe still failing after the window notifies then
+	if maintenance {
+		return
+	}
 
-		if t.UpDownHistory.Len() < t.FailThreshold {
-			t.FirstDown = t.UpDownHistory.Front().Value.(*DownHistory).Time
-		} else {
-			ele := t.UpDownHistory.Back()
-			for i := 0; i < t.FailThreshold-1; i++ {
-				ele = ele.Prev()
-			}
-			t.FirstDown = ele.Value.(*DownHistory).Time
-			t.LastUp = ele.Prev().Value.(*DownHistory).Time
+	// a flapping probe only notifies once when it starts flapping, and once when it settles
+	changes := t.StatusChanges(t.FlapWindow)
+	if t.Status != StatusFlapping && changes >= t.FlapThreshold {
+		previous := t.Status
+		t.Status = StatusFlapping
+		t.FirstFlapping = t.UpDownHistory.Back().Value.(*DownHistory).Time
+		if ele := t.UpDownHistory.Back().Prev(); ele != nil {
+			*t.last(previous) = ele.Value.(*DownHistory).Time
+		}
+		t.resetEscalation(at)
+		trigger(name, id, StatusFlapping, previous, t.FirstFlapping, *t.first(previous), t.ProbeLatency(PeriodHour, at))
+		return
+	}
+	if t.Status == StatusFlapping && changes > t.FlapThreshold/2 {
+		return
+	}
+
+	count, threshold := t.FailCount, t.FailThreshold
+	switch status {
+	case StatusUp:
+		count, threshold = t.SuccessCount, t.SuccessThreshold
+	case StatusDegraded:
+		count, threshold = t.DegradedCount, t.DegradedThreshold
+	}
+	if count >= threshold && t.Status != status {
+		previous := t.Status
+		t.Status = status
+
+		// walk back to the first history entry of the current status
+		ele := t.UpDownHistory.Back()
+		for ele.Prev() != nil && ele.Prev().Value.(*DownHistory).Status == status {
+			ele = ele.Prev()
+		}
+		*t.first(status) = ele.Value.(*DownHistory).Time
+		if ele.Prev() != nil {
+			*t.last(previous) = ele.Prev().Value.(*DownHistory).Time
 		}
 
-		trigger(name, id, true, t.FirstUp, t.LastUp, t.FirstDown, t.LastDown)
-	} else if t.SuccessCount > t.SuccessThreshold && t.LastStatusDown {
-		t.LastStatusDown = false
+		t.resetEscalation(at)
+		trigger(name, id, status, previous, *t.first(status), *t.first(previous), t.ProbeLatency(PeriodHour, at))
+	}
+}
+
+// recordLatency adds the probe duration and the duration of each of its requests to the statistics.
+func (t *ProbeEventTracker) recordLatency(pbctx internal.ProbeContext) {
+	at, ok := pbctx[fmt.Sprintf("probe.%s.starttime", t.ProbeName)].(time.Time)
+	if !ok {
+		return
+	}
+	if t.ProbeStatistic == nil {
+		t.ProbeStatistic = NewLatencyStatistic()
+	}
+	if t.RequestStatistic == nil {
+		t.RequestStatistic = make(map[string]*LatencyStatistic)
+	}
+	if duration, ok := pbctx[fmt.Sprintf("probe.%s.duration", t.ProbeName)].(time.Duration); ok {
+		t.ProbeStatistic.Record(at, duration)
+	}
+	requests, _ := pbctx[fmt.Sprintf("probe.%s.req", t.ProbeName)].(string)
+	for _, request := range strings.Split(requests, ",") {
+		duration, ok := pbctx[fmt.Sprintf("probe.%s.req.%s.duration", t.ProbeName, request)].(time.Duration)
+		if !ok {
+			continue
+		}
+		if _, ok := t.RequestStatistic[request]; !ok {
+			t.RequestStatistic[request] = NewLatencyStatistic()
+		}
+		t.RequestStatistic[request].Record(at, duration)
+	}
+}
 
-		if t.UpDownHistory.Len() < t.SuccessThreshold {
-			t.FirstUp = t.UpDownHistory.Front().Value.(*DownHistory).Time
-		} else {
-			ele := t.UpDownHistory.Back()
-			for i := 0; i < t.SuccessThreshold-1; i++ {
-				ele = ele.Prev()
+// recordDowntime accounts the time between the previous result and the one at the time. It keeps the
+// DailyRetention latest days.
+func (t *ProbeEventTracker) recordDowntime(at time.Time) {
+	if t.Downtime == nil {
+		t.Downtime = &helper.Interval{Ranges: make([]*helper.Range, 0)}
+	}
+	if t.MaintenanceTime == nil {
+		t.MaintenanceTime = &helper.Interval{Ranges: make([]*helper.Range, 0)}
+	}
+	if t.UpDownHistory.Len() == 0 {
+		return
+	}
+	previous := t.UpDownHistory.Back().Value.(*DownHistory)
+	if !at.After(previous.Time) {
+		return
+	}
+	switch {
+	case previous.Maintenance:
+		t.MaintenanceTime.AddRange(previous.Time.Unix(), at.Unix()-1)
+	case previous.Down:
+		t.Downtime.AddRange(previous.Time.Unix(), at.Unix()-1)
+	}
+	retention := at.Add(-DailyRetention * PeriodDay).Unix()
+	t.Downtime.RemoveBefore(retention)
+	t.MaintenanceTime.RemoveBefore(retention)
+}
+
+// Uptime returns the ratio of time the probe was not DOWN between from and to, leaving the maintenance
+// windows out. A period entirely in maintenance is fully up.
+func (t *ProbeEventTracker) Uptime(from, to time.Time) float64 {
+	if t.Downtime == nil || t.MaintenanceTime == nil || !to.After(from) {
+		return 1
+	}
+	total := to.Unix() - from.Unix() - t.MaintenanceTime.CountIn(from.Unix(), to.Unix()-1)
+	if total <= 0 {
+		return 1
+	}
+	return 1 - float64(t.Downtime.CountIn(from.Unix(), to.Unix()-1))/float64(total)
+}
+
+// ProbeLatency reports the probe durations of the PeriodHour or PeriodDay containing the time.
+func (t *ProbeEventTracker) ProbeLatency(period time.Duration, at time.Time) *LatencySummary {
+	if t.ProbeStatistic == nil {
+		t.ProbeStatistic = NewLatencyStatistic()
+	}
+	return t.ProbeStatistic.Summary(period, at)
+}
+
+// RequestLatency reports the durations of the request in the PeriodHour or PeriodDay containing the time.
+func (t *ProbeEventTracker) RequestLatency(requestName string, period time.Duration, at time.Time) *LatencySummary {
+	stat, ok := t.RequestStatistic[requestName]
+	if !ok {
+		stat = NewLatencyStatistic()
+	}
+	return stat.Summary(period, at)
+}
+
+// StatusChanges counts the status changes among the latest window results of the history, results within
+// maintenance windows are left out.
+func (t *ProbeEventTracker) StatusChanges(window int) int {
+	changes := 0
+	status := ""
+	ele := t.UpDownHistory.Back()
+	for i := 0; i < window && ele != nil; i++ {
+		if history := ele.Value.(*DownHistory); !history.Maintenance {
+			if len(status) > 0 && history.Status != status {
+				changes++
 			}
-			t.FirstUp = ele.Value.(*DownHistory).Time
-			t.LastDown = ele.Prev().Value.(*DownHistory).Time
+			status = history.Status
 		}
+		ele = ele.Prev()
+	}
+	return changes
+}
+
+// first returns the field holding when the status was first seen.
+func (t *ProbeEventTracker) first(status string) *time.Time {
+	switch status {
+	case StatusUp:
+		return &t.FirstUp
+	case StatusDegraded:
+		return &t.FirstDegraded
+	case StatusFlapping:
+		return &t.FirstFlapping
+	}
+	return &t.FirstDown
+}
 
-		trigger(name, id, false, t.FirstUp, t.LastUp, t.FirstDown, t.LastDown)
+// last returns the field holding when the status was last seen.
+func (t *ProbeEventTracker) last(status string) *time.Time {
+	switch status {
+	case StatusUp:
+		return &t.LastUp
+	case StatusDegraded:
+		return &t.LastDegraded
+	case StatusFlapping:
+		return &t.LastFlapping
 	}
+	return &t.LastDown
 }
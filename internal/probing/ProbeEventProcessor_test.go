@@ -3,6 +3,7 @@ package probing
 import (
 	"fmt"
 	"github.com/newm4n/mihp/internal"
+	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
 )
@@ -36,3 +37,75 @@ func TestNewProbeEventProcessor(t *testing.T) {
 		time.Sleep(1 * time.Second)
 	}
 }
+
+func TestProbeEventProcessor_Degraded(t *testing.T) {
+	type transition struct {
+		status, previous string
+		since            time.Time
+	}
+	transitions := make([]transition, 0)
+	eventProc := NewProbeEventProcessor(func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+		transitions = append(transitions, transition{status: status, previous: previous, since: since})
+	})
+
+	start := time.Now()
+	statuses := []string{StatusUp, StatusUp, StatusUp, StatusDegraded, StatusDegraded, StatusDegraded, StatusDown, StatusDown, StatusDown, StatusUp, StatusUp, StatusUp}
+	success := make([]bool, len(statuses))
+	for i, status := range statuses {
+		success[i] = status != StatusDown
+	}
+	for i, ctx := range DummyContext("dummy", "123456789", start, time.Minute, success) {
+		ctx["probe.dummy.status"] = statuses[i]
+		eventProc.AcceptProbeContext(ctx)
+	}
+
+	assert.Equal(t, []transition{
+		{status: StatusUp, previous: StatusDown, since: start},
+		{status: StatusDegraded, previous: StatusUp, since: start.Add(3 * time.Minute)},
+		{status: StatusDown, previous: StatusDegraded, since: start.Add(6 * time.Minute)},
+		{status: StatusUp, previous: StatusDown, since: start.Add(9 * time.Minute)},
+	}, transitions)
+	tracker := eventProc.Trackers[0]
+	assert.Equal(t, StatusUp, tracker.Status)
+	assert.Equal(t, start.Add(5*time.Minute), tracker.LastDegraded)
+	assert.Equal(t, start.Add(8*time.Minute), tracker.LastDown)
+}
+
+func TestProbeEventProcessor_Thresholds(t *testing.T) {
+	statuses := make([]string, 0)
+	trigger := func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+		statuses = append(statuses, status)
+	}
+
+	eventProc := NewProbeEventProcessor(trigger, &internal.Probe{Name: "dummy", UpThreshold: 1, DownThreshold: 2})
+	for _, ctx := range DummyContext("dummy", "123456789", time.Now(), time.Minute, []bool{true, false, true, false, false, true}) {
+		eventProc.AcceptProbeContext(ctx)
+	}
+	assert.Equal(t, []string{StatusUp, StatusDown, StatusUp}, statuses)
+	assert.Equal(t, 1, eventProc.Trackers[0].SuccessThreshold)
+	assert.Equal(t, 2, eventProc.Trackers[0].FailThreshold)
+
+	statuses = statuses[:0]
+	eventProc = NewProbeEventProcessor(trigger)
+	for _, ctx := range DummyContext("dummy", "123456789", time.Now(), time.Minute, []bool{true, true, true, false, false, false}) {
+		eventProc.AcceptProbeContext(ctx)
+	}
+	assert.Equal(t, []string{StatusUp, StatusDown}, statuses)
+	assert.Equal(t, DefaultUpThreshold, eventProc.Trackers[0].SuccessThreshold)
+}
+
+func TestProbeEventProcessor_Flapping(t *testing.T) {
+	statuses := make([]string, 0)
+	eventProc := NewProbeEventProcessor(func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+		statuses = append(statuses, status)
+	}, &internal.Probe{Name: "dummy", UpThreshold: 1, DownThreshold: 1, FlapWindow: 6, FlapThreshold: 4})
+
+	success := []bool{true, false, true, false, true, false, true, false, true, true, true, true, true}
+	for _, ctx := range DummyContext("dummy", "123456789", time.Now(), time.Minute, success) {
+		eventProc.AcceptProbeContext(ctx)
+	}
+	assert.Equal(t, []string{StatusUp, StatusDown, StatusUp, StatusDown, StatusFlapping, StatusUp}, statuses)
+	tracker := eventProc.Trackers[0]
+	assert.Equal(t, StatusUp, tracker.Status)
+	assert.Equal(t, 1, tracker.StatusChanges(6))
+}
@@ -0,0 +1,151 @@
+package probing
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"github.com/newm4n/mihp/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// StateVersion is the version of the event tracker state file format.
+	StateVersion = 1
+)
+
+var (
+	stateLog = logrus.WithField("module", "ProbeEventState")
+)
+
+// processorState is the content of the state file.
+type processorState struct {
+	Version  int                  `json:"version"`
+	Saved    time.Time            `json:"saved"`
+	Trackers []*ProbeEventTracker `json:"trackers"`
+}
+
+// trackerAlias has the fields of ProbeEventTracker without its json methods.
+type trackerAlias ProbeEventTracker
+
+// MarshalJSON writes the tracker with its history as a list.
+func (t *ProbeEventTracker) MarshalJSON() ([]byte, error) {
+	history := make([]*DownHistory, 0)
+	if t.UpDownHistory != nil {
+		for ele := t.UpDownHistory.Front(); ele != nil; ele = ele.Next() {
+			history = append(history, ele.Value.(*DownHistory))
+		}
+	}
+	return json.Marshal(&struct {
+		*trackerAlias
+		UpDownHistory []*DownHistory
+	}{trackerAlias: (*trackerAlias)(t), UpDownHistory: history})
+}
+
+// UnmarshalJSON reads the tracker written by MarshalJSON.
+func (t *ProbeEventTracker) UnmarshalJSON(data []byte) error {
+	content := &struct {
+		*trackerAlias
+		UpDownHistory []*DownHistory
+	}{trackerAlias: (*trackerAlias)(t)}
+	if err := json.Unmarshal(data, content); err != nil {
+		return err
+	}
+	t.UpDownHistory = list.New().Init()
+	for _, history := range content.UpDownHistory {
+		if history == nil {
+			return fmt.Errorf("empty history entry")
+		}
+		t.UpDownHistory.PushBack(history)
+	}
+	return nil
+}
+
+// SaveState writes the trackers to the StateFile, if any. The file is replaced atomically,
+// so a crash while saving never leaves a half written state.
+func (proc *ProbeEventProcessor) SaveState() error {
+	if len(proc.StateFile) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(&processorState{Version: StateVersion, Saved: time.Now(), Trackers: proc.Trackers}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(proc.StateFile), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(proc.StateFile), filepath.Base(proc.StateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), proc.StateFile)
+}
+
+// RestoreState reads the trackers back from the StateFile, a missing file is a fresh start. The thresholds
+// always come from the probes of the processor, not from the file. A corrupt file is moved aside to
+// "<StateFile>.corrupt" and the processor starts fresh, returning ErrStateCorrupt.
+func (proc *ProbeEventProcessor) RestoreState() error {
+	if len(proc.StateFile) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(proc.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := &processorState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return proc.discardState(err.Error())
+	}
+	if state.Version != StateVersion {
+		return proc.discardState(fmt.Sprintf("unknown version %d", state.Version))
+	}
+	for _, tracker := range state.Trackers {
+		if tracker == nil || len(tracker.ProbeName) == 0 {
+			return proc.discardState("tracker without probe name")
+		}
+		switch tracker.Status {
+		case StatusUp, StatusDegraded, StatusDown, StatusFlapping:
+		default:
+			return proc.discardState(fmt.Sprintf("tracker %s has unknown status %s", tracker.ProbeName, tracker.Status))
+		}
+	}
+
+	for _, tracker := range state.Trackers {
+		configured := NewProbeEventTracker(tracker.ProbeName, tracker.ProbeID, proc.Probes[tracker.ProbeName])
+		tracker.SuccessThreshold = configured.SuccessThreshold
+		tracker.FailThreshold = configured.FailThreshold
+		tracker.DegradedThreshold = configured.DegradedThreshold
+		tracker.FlapWindow = configured.FlapWindow
+		tracker.FlapThreshold = configured.FlapThreshold
+		if tracker.UpDownHistory == nil {
+			tracker.UpDownHistory = list.New().Init()
+		}
+	}
+	proc.Trackers = state.Trackers
+	stateLog.Debugf("restored %d trackers saved at %s from %s", len(state.Trackers), state.Saved.Format(time.RFC3339), proc.StateFile)
+	return nil
+}
+
+// discardState moves the corrupt state file aside so the next save does not overwrite the evidence.
+func (proc *ProbeEventProcessor) discardState(cause string) error {
+	proc.Trackers = make([]*ProbeEventTracker, 0)
+	corrupt := proc.StateFile + ".corrupt"
+	if err := os.Rename(proc.StateFile, corrupt); err != nil {
+		stateLog.Errorf("can not move corrupt state file %s aside. got %s", proc.StateFile, err.Error())
+	}
+	return fmt.Errorf("%w : %s moved to %s, got %s", errors.ErrStateCorrupt, proc.StateFile, corrupt, cause)
+}
@@ -0,0 +1,60 @@
+package probing
+
+import (
+	"errors"
+	"github.com/newm4n/mihp/internal"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProbeEventProcessor_State(t *testing.T) {
+	statuses := make([]string, 0)
+	trigger := func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+		statuses = append(statuses, status)
+	}
+	stateFile := filepath.Join(t.TempDir(), "state", "email-123456789.json")
+	start := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+	contexts := DummyContext("dummy", "123456789", start, time.Minute, []bool{true, true, true, true, false})
+
+	eventProc := NewProbeEventProcessor(trigger)
+	eventProc.StateFile = stateFile
+	assert.NoError(t, eventProc.RestoreState())
+	for _, ctx := range contexts[:4] {
+		eventProc.AcceptProbeContext(ctx)
+	}
+	assert.Equal(t, []string{StatusUp}, statuses)
+
+	// restarted minion, with the up threshold changed meanwhile
+	statuses = statuses[:0]
+	restarted := NewProbeEventProcessor(trigger, &internal.Probe{Name: "dummy", UpThreshold: 5, DownThreshold: 1})
+	restarted.StateFile = stateFile
+	assert.NoError(t, restarted.RestoreState())
+	tracker := restarted.Tracker("dummy")
+	assert.Equal(t, StatusUp, tracker.Status)
+	assert.Equal(t, 4, tracker.SuccessCount)
+	assert.Equal(t, 5, tracker.SuccessThreshold)
+	assert.Equal(t, start, tracker.FirstUp.UTC())
+	assert.Equal(t, 4, tracker.UpDownHistory.Len())
+	assert.Equal(t, int64(4), tracker.ProbeLatency(PeriodHour, start).Count)
+
+	restarted.AcceptProbeContext(contexts[0])
+	assert.Empty(t, statuses)
+	restarted.AcceptProbeContext(contexts[4])
+	assert.Equal(t, []string{StatusDown}, statuses)
+
+	assert.NoError(t, os.WriteFile(stateFile, []byte(`{"version": 1, "trackers": [{"ProbeName": "dummy", "Status": "UP", "UpDownHistory": [`), 0600))
+	corrupt := NewProbeEventProcessor(trigger)
+	corrupt.StateFile = stateFile
+	err := corrupt.RestoreState()
+	assert.True(t, errors.Is(err, mihperrors.ErrStateCorrupt))
+	assert.Empty(t, corrupt.Trackers)
+	assert.FileExists(t, stateFile+".corrupt")
+	assert.NoFileExists(t, stateFile)
+
+	assert.NoError(t, os.WriteFile(stateFile, []byte(`{"version": 1, "trackers": [{"ProbeName": "dummy", "Status": "SIDEWAYS"}]}`), 0600))
+	assert.True(t, errors.Is(corrupt.RestoreState(), mihperrors.ErrStateCorrupt))
+}
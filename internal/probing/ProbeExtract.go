@@ -0,0 +1,32 @@
+package probing
+
+import (
+	"context"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/errors"
+	"reflect"
+)
+
+// VariableKey returns the probe context key of an extracted variable, "vars.<name>".
+// Note that "var" can not be used as it is a reserved word in CEL expressions.
+func VariableKey(name string) string {
+	return fmt.Sprintf("vars.%s", name)
+}
+
+// ExtractVariables evaluates the request's Extract expressions in name order and stores each result as "vars.<name>".
+// Variables extracted earlier, including by the same request, can be used by the following expressions.
+func ExtractVariables(ctx context.Context, pctx internal.ProbeContext, probe *internal.Probe, probeRequest *internal.ProbeRequest) error {
+	for _, name := range probeRequest.ExtractNames() {
+		expr := probeRequest.Extract[name]
+		if !internal.IsValidVariableName(name) {
+			return fmt.Errorf("%w : probe %s request %s variable name \"%s\" is not a valid identifier", errors.ErrExtractError, probe.Name, probeRequest.Name, name)
+		}
+		value, err := GoCelEvaluate(ctx, expr, pctx, reflect.Interface)
+		if err != nil {
+			return fmt.Errorf("%w : probe %s request %s variable %s expression [%s] got %s", errors.ErrExtractError, probe.Name, probeRequest.Name, name, expr, err.Error())
+		}
+		pctx[VariableKey(name)] = value
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package probing
+
+import (
+	"fmt"
+	"github.com/newm4n/mihp/pkg/helper"
+	"time"
+)
+
+const (
+	PeriodHour = time.Hour
+	PeriodDay  = 24 * time.Hour
+
+	// HourlyRetention and DailyRetention are how many hourly and daily histograms a LatencyStatistic keeps.
+	HourlyRetention = 48
+	DailyRetention  = 31
+)
+
+// NewLatencyStatistic creates an empty LatencyStatistic.
+func NewLatencyStatistic() *LatencyStatistic {
+	return &LatencyStatistic{
+		Hourly: make(map[int64]*helper.Histogram),
+		Daily:  make(map[int64]*helper.Histogram),
+	}
+}
+
+// LatencyStatistic keeps duration histograms bucketed by hour and by day, keyed by the hours or days since
+// the unix epoch.
+type LatencyStatistic struct {
+	Hourly map[int64]*helper.Histogram `json:"hourly"`
+	Daily  map[int64]*helper.Histogram `json:"daily"`
+}
+
+// LatencySummary reports the percentiles of a period.
+type LatencySummary struct {
+	Period time.Duration
+	Start  time.Time
+	Count  int64
+	P50    time.Duration
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+func (summary *LatencySummary) String() string {
+	if summary == nil || summary.Count == 0 {
+		return "no data"
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s of %d runs", summary.P50, summary.P90, summary.P99, summary.Max, summary.Count)
+}
+
+// periodIndex returns the number of periods between the unix epoch and the time.
+func periodIndex(t time.Time, period time.Duration) int64 {
+	return t.Unix() / int64(period/time.Second)
+}
+
+// Record counts the duration measured at the time, dropping the histograms older than the retention.
+func (stat *LatencyStatistic) Record(t time.Time, duration time.Duration) {
+	for _, period := range []time.Duration{PeriodHour, PeriodDay} {
+		histograms, retention := stat.histograms(period)
+		idx := periodIndex(t, period)
+		h, ok := histograms[idx]
+		if !ok {
+			h = helper.NewHistogram()
+			histograms[idx] = h
+			for old := range histograms {
+				if old <= idx-retention {
+					delete(histograms, old)
+				}
+			}
+		}
+		h.Record(int64(duration))
+	}
+}
+
+// Summary reports the PeriodHour or PeriodDay containing the time.
+func (stat *LatencyStatistic) Summary(period time.Duration, t time.Time) *LatencySummary {
+	histograms, _ := stat.histograms(period)
+	idx := periodIndex(t, period)
+	summary := &LatencySummary{Period: period, Start: time.Unix(idx*int64(period/time.Second), 0)}
+	if h, ok := histograms[idx]; ok {
+		summary.Count = h.Count
+		summary.P50 = time.Duration(h.Percentile(50))
+		summary.P90 = time.Duration(h.Percentile(90))
+		summary.P99 = time.Duration(h.Percentile(99))
+		summary.Max = time.Duration(h.Max)
+	}
+	return summary
+}
+
+func (stat *LatencyStatistic) histograms(period time.Duration) (map[int64]*helper.Histogram, int64) {
+	if period == PeriodDay {
+		if stat.Daily == nil {
+			stat.Daily = make(map[int64]*helper.Histogram)
+		}
+		return stat.Daily, DailyRetention
+	}
+	if stat.Hourly == nil {
+		stat.Hourly = make(map[int64]*helper.Histogram)
+	}
+	return stat.Hourly, HourlyRetention
+}
@@ -0,0 +1,62 @@
+package probing
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLatencyStatistic(t *testing.T) {
+	stat := NewLatencyStatistic()
+	start := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= 100; i++ {
+		stat.Record(start.Add(time.Duration(i)*time.Second), time.Duration(i)*10*time.Millisecond)
+	}
+	stat.Record(start.Add(time.Hour), 5*time.Second)
+
+	hour := stat.Summary(PeriodHour, start.Add(30*time.Minute))
+	assert.Equal(t, start, hour.Start.UTC())
+	assert.Equal(t, int64(100), hour.Count)
+	assert.InEpsilon(t, float64(500*time.Millisecond), float64(hour.P50), 0.01)
+	assert.InEpsilon(t, float64(900*time.Millisecond), float64(hour.P90), 0.01)
+	assert.InEpsilon(t, float64(990*time.Millisecond), float64(hour.P99), 0.01)
+	assert.Equal(t, time.Second, hour.Max)
+
+	day := stat.Summary(PeriodDay, start)
+	assert.Equal(t, int64(101), day.Count)
+	assert.Equal(t, 5*time.Second, day.Max)
+	assert.Equal(t, "no data", stat.Summary(PeriodHour, start.Add(-time.Hour)).String())
+
+	stat.Record(start.Add(HourlyRetention*time.Hour), time.Second)
+	assert.Equal(t, int64(0), stat.Summary(PeriodHour, start).Count)
+	assert.Len(t, stat.Hourly, 2)
+}
+
+func TestProbeEventTracker_Latency(t *testing.T) {
+	var notified *LatencySummary
+	eventProc := NewProbeEventProcessor(func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+		notified = latency
+	})
+	start := time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)
+	for i, ctx := range DummyContext("dummy", "123456789", start, time.Second, []bool{true, true, true, true}) {
+		ctx["probe.dummy.req"] = "Login,Profile"
+		ctx["probe.dummy.req.Login.duration"] = time.Duration(i+1) * 100 * time.Millisecond
+		ctx["probe.dummy.req.Profile.duration"] = 50 * time.Millisecond
+		eventProc.AcceptProbeContext(ctx)
+	}
+
+	tracker := eventProc.Tracker("dummy")
+	assert.NotNil(t, tracker)
+	assert.Nil(t, eventProc.Tracker("other"))
+	assert.Equal(t, int64(4), tracker.ProbeLatency(PeriodHour, start).Count)
+	assert.Equal(t, 2*time.Second, tracker.ProbeLatency(PeriodDay, start).P99)
+	login := tracker.RequestLatency("Login", PeriodHour, start)
+	assert.InEpsilon(t, float64(200*time.Millisecond), float64(login.P50), 0.01)
+	assert.Equal(t, 400*time.Millisecond, login.Max)
+	assert.Equal(t, 50*time.Millisecond, tracker.RequestLatency("Profile", PeriodDay, start).Max)
+	assert.Equal(t, int64(0), tracker.RequestLatency("Missing", PeriodDay, start).Count)
+
+	assert.NotNil(t, notified)
+	assert.Equal(t, int64(3), notified.Count)
+	assert.Equal(t, "p50=2s p90=2s p99=2s max=2s of 3 runs", notified.String())
+}
@@ -0,0 +1,168 @@
+package probing
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/errors"
+	"github.com/newm4n/mihp/pkg/helper/cron"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// Maintenance holds the global and ad hoc maintenance windows of this process.
+	Maintenance = NewMaintenanceRegistry()
+)
+
+// CheckMaintenanceWindow returns an error if the window has neither a valid cron with a positive duration,
+// nor a start before its end.
+func CheckMaintenanceWindow(window *internal.MaintenanceWindow) error {
+	if len(window.Cron) > 0 {
+		if _, err := cron.NewSchedule(window.Cron); err != nil {
+			return fmt.Errorf("%w : window %s has invalid cron %s", errors.ErrInvalidMaintenance, window.ID, window.Cron)
+		}
+		if window.Duration <= 0 {
+			return fmt.Errorf("%w : window %s with cron must have a positive duration", errors.ErrInvalidMaintenance, window.ID)
+		}
+		if !window.Start.IsZero() && !window.End.IsZero() && !window.Start.Before(window.End) {
+			return fmt.Errorf("%w : window %s starts at or after its end", errors.ErrInvalidMaintenance, window.ID)
+		}
+		return nil
+	}
+	if window.Start.IsZero() || window.End.IsZero() {
+		return fmt.Errorf("%w : window %s needs either a cron and duration, or a start and end", errors.ErrInvalidMaintenance, window.ID)
+	}
+	if !window.Start.Before(window.End) {
+		return fmt.Errorf("%w : window %s starts at or after its end", errors.ErrInvalidMaintenance, window.ID)
+	}
+	return nil
+}
+
+// MaintenanceActive checks whether the window is open at the time. A recurring window is open if its cron
+// matched within the last Duration.
+func MaintenanceActive(window *internal.MaintenanceWindow, at time.Time) bool {
+	if !window.Start.IsZero() && at.Before(window.Start) {
+		return false
+	}
+	if !window.End.IsZero() && !at.Before(window.End) {
+		return false
+	}
+	if len(window.Cron) == 0 {
+		return !window.Start.IsZero() && !window.End.IsZero()
+	}
+	if window.Duration <= 0 {
+		return false
+	}
+	schedule, err := cron.NewSchedule(window.Cron)
+	if err != nil {
+		return false
+	}
+	_, ok := schedule.LastIn(at.Add(-window.Duration).Add(time.Second), at)
+	return ok
+}
+
+// InMaintenance returns the window of the probe, or of the Maintenance registry, open at the time. Nil if none.
+func InMaintenance(probe *internal.Probe, at time.Time) *internal.MaintenanceWindow {
+	for _, window := range probe.Maintenance {
+		if MaintenanceActive(window, at) {
+			return window
+		}
+	}
+	return Maintenance.Active(probe, at)
+}
+
+// NewMaintenanceRegistry creates an empty registry.
+func NewMaintenanceRegistry() *MaintenanceRegistry {
+	return &MaintenanceRegistry{windows: make(map[string]*internal.MaintenanceWindow)}
+}
+
+// MaintenanceRegistry keeps the maintenance windows not bound to a probe, it is safe for concurrent use.
+type MaintenanceRegistry struct {
+	mutex   sync.RWMutex
+	windows map[string]*internal.MaintenanceWindow
+}
+
+// Add checks the window and adds it, giving it an ID if it has none. A window with the same ID is replaced.
+func (reg *MaintenanceRegistry) Add(window *internal.MaintenanceWindow) error {
+	if err := CheckMaintenanceWindow(window); err != nil {
+		return err
+	}
+	if len(window.ID) == 0 {
+		window.ID = uuid.New().String()
+	}
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.windows[window.ID] = window
+	return nil
+}
+
+// Remove deletes the window, returns false if there is no window with the ID.
+func (reg *MaintenanceRegistry) Remove(id string) bool {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	if _, ok := reg.windows[id]; !ok {
+		return false
+	}
+	delete(reg.windows, id)
+	return true
+}
+
+// Replace swaps every window for the given ones at once, the invalid ones are skipped and reported in the error.
+func (reg *MaintenanceRegistry) Replace(windows []*internal.MaintenanceWindow) error {
+	replaced := make(map[string]*internal.MaintenanceWindow, len(windows))
+	var failed error
+	for _, window := range windows {
+		if err := CheckMaintenanceWindow(window); err != nil {
+			failed = err
+			continue
+		}
+		if len(window.ID) == 0 {
+			window.ID = uuid.New().String()
+		}
+		replaced[window.ID] = window
+	}
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.windows = replaced
+	return failed
+}
+
+// List returns the windows ordered by ID.
+func (reg *MaintenanceRegistry) List() []*internal.MaintenanceWindow {
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+	windows := make([]*internal.MaintenanceWindow, 0, len(reg.windows))
+	for _, window := range reg.windows {
+		windows = append(windows, window)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].ID < windows[j].ID })
+	return windows
+}
+
+// Active returns a window applying to the probe that is open at the time, nil if none.
+func (reg *MaintenanceRegistry) Active(probe *internal.Probe, at time.Time) *internal.MaintenanceWindow {
+	for _, window := range reg.List() {
+		if !appliesTo(window, probe) {
+			continue
+		}
+		if MaintenanceActive(window, at) {
+			return window
+		}
+	}
+	return nil
+}
+
+// appliesTo checks whether the window lists the probe by name or ID, a window listing no probe applies to all.
+func appliesTo(window *internal.MaintenanceWindow, probe *internal.Probe) bool {
+	if len(window.Probes) == 0 {
+		return true
+	}
+	for _, p := range window.Probes {
+		if p == probe.Name || (len(probe.ID) > 0 && p == probe.ID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package probing
+
+import (
+	"github.com/newm4n/mihp/internal"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceActive(t *testing.T) {
+	sunday := time.Date(2021, 10, 17, 0, 0, 0, 0, time.UTC)
+	once := &internal.MaintenanceWindow{ID: "once", Start: sunday.Add(time.Hour), End: sunday.Add(2 * time.Hour)}
+	assert.NoError(t, CheckMaintenanceWindow(once))
+	assert.False(t, MaintenanceActive(once, sunday.Add(59*time.Minute)))
+	assert.True(t, MaintenanceActive(once, sunday.Add(time.Hour)))
+	assert.False(t, MaintenanceActive(once, sunday.Add(2*time.Hour)))
+
+	weekly := &internal.MaintenanceWindow{ID: "weekly", Cron: "0 0 2 * 0 * *", Duration: 30 * time.Minute}
+	assert.NoError(t, CheckMaintenanceWindow(weekly))
+	assert.False(t, MaintenanceActive(weekly, sunday.Add(119*time.Minute)))
+	assert.True(t, MaintenanceActive(weekly, sunday.Add(2*time.Hour)))
+	assert.True(t, MaintenanceActive(weekly, sunday.Add(149*time.Minute)))
+	assert.False(t, MaintenanceActive(weekly, sunday.Add(150*time.Minute)))
+	assert.False(t, MaintenanceActive(weekly, sunday.Add(26*time.Hour)))
+
+	assert.Error(t, CheckMaintenanceWindow(&internal.MaintenanceWindow{ID: "bad", Cron: "0 0 2 * 0 * *"}))
+	assert.Error(t, CheckMaintenanceWindow(&internal.MaintenanceWindow{ID: "bad", Start: once.End, End: once.Start}))
+	assert.Error(t, CheckMaintenanceWindow(&internal.MaintenanceWindow{ID: "bad"}))
+}
+
+func TestMaintenanceRegistry(t *testing.T) {
+	defer Maintenance.Replace(nil)
+	now := time.Now()
+	probe := &internal.Probe{Name: "dummy", ID: "123456789"}
+	assert.Nil(t, InMaintenance(probe, now))
+
+	other := &internal.MaintenanceWindow{Start: now.Add(-time.Minute), End: now.Add(time.Minute), Probes: []string{"other"}}
+	assert.NoError(t, Maintenance.Add(other))
+	assert.NotEmpty(t, other.ID)
+	assert.Nil(t, InMaintenance(probe, now))
+
+	byID := &internal.MaintenanceWindow{ID: "deploy", Start: now.Add(-time.Minute), End: now.Add(time.Minute), Probes: []string{"123456789"}}
+	assert.NoError(t, Maintenance.Add(byID))
+	assert.Equal(t, byID, InMaintenance(probe, now))
+	assert.Len(t, Maintenance.List(), 2)
+
+	assert.True(t, Maintenance.Remove("deploy"))
+	assert.False(t, Maintenance.Remove("deploy"))
+	assert.Nil(t, InMaintenance(probe, now))
+
+	assert.Error(t, Maintenance.Add(&internal.MaintenanceWindow{ID: "bad"}))
+	assert.Len(t, Maintenance.List(), 1)
+
+	always := &internal.MaintenanceWindow{ID: "always", Start: now.Add(-time.Hour), End: now.Add(time.Hour)}
+	assert.Error(t, Maintenance.Replace([]*internal.MaintenanceWindow{always, {ID: "bad"}}))
+	assert.Equal(t, []*internal.MaintenanceWindow{always}, Maintenance.List())
+
+	// readers never see the registry empty while it is replaced
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			assert.NotNil(t, Maintenance.Active(probe, now))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, Maintenance.Replace([]*internal.MaintenanceWindow{always}))
+	}
+	<-done
+}
+
+func TestProbeEventProcessor_Maintenance(t *testing.T) {
+	statuses := make([]string, 0)
+	trigger := func(probeName, probeId, status, previous string, since, previousSince time.Time, latency *LatencySummary) {
+		statuses = append(statuses, status)
+	}
+	eventProc := NewProbeEventProcessor(trigger, &internal.Probe{Name: "dummy", UpThreshold: 1, DownThreshold: 2})
+
+	start := time.Date(2021, 10, 17, 2, 0, 0, 0, time.UTC)
+	maintenance := []bool{false, true, true, true, false, false}
+	for i, ctx := range DummyContext("dummy", "123456789", start, time.Minute, []bool{true, false, false, false, false, true}) {
+		ctx["probe.dummy.maintenance"] = maintenance[i]
+		eventProc.AcceptProbeContext(ctx)
+		if i == 3 {
+			assert.Equal(t, []string{StatusUp}, statuses)
+		}
+	}
+
+	// the probe still fails after the window, so it notifies DOWN since the window started
+	assert.Equal(t, []string{StatusUp, StatusDown, StatusUp}, statuses)
+	tracker := eventProc.Tracker("dummy")
+	assert.Equal(t, start.Add(time.Minute), tracker.FirstDown)
+	assert.Equal(t, 0.5, tracker.Uptime(start, start.Add(5*time.Minute)))
+	assert.Equal(t, 1.0, tracker.Uptime(start.Add(time.Minute), start.Add(4*time.Minute)))
+}
@@ -0,0 +1,53 @@
+package probing
+
+import (
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"net/http"
+	"net/url"
+)
+
+var (
+	// DefaultProxy is the proxy used by probes that do not specify their own. Minion sets this from its configuration.
+	DefaultProxy *internal.ProxyConfig
+)
+
+// EffectiveProxy returns the proxy configuration a probe should use, the probe's own or the DefaultProxy.
+// Returns nil if probe traffic should go direct.
+func EffectiveProxy(probe *internal.Probe) *internal.ProxyConfig {
+	if probe.Proxy != nil && len(probe.Proxy.URL) > 0 {
+		return probe.Proxy
+	}
+	if DefaultProxy != nil && len(DefaultProxy.URL) > 0 {
+		return DefaultProxy
+	}
+	return nil
+}
+
+// NewProxyFunc creates the http.Transport's Proxy function for the proxy configuration.
+func NewProxyFunc(proxy *internal.ProxyConfig) (func(*http.Request) (*url.URL, error), error) {
+	if proxy == nil {
+		return nil, nil
+	}
+	proxyURL, err := proxy.ProxyURL()
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if proxy.Bypass(req.URL.Host) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// RecordProxy stores the proxy the request is routed through, without credentials, into
+// "probe.<probe>.req.<request>.proxy". Direct connection is recorded as "DIRECT"
+func RecordProxy(pctx internal.ProbeContext, probe *internal.Probe, requestName string, URL *url.URL) {
+	proxy := EffectiveProxy(probe)
+	if proxy == nil || URL == nil || proxy.Bypass(URL.Host) {
+		pctx[fmt.Sprintf("probe.%s.req.%s.proxy", probe.Name, requestName)] = "DIRECT"
+		return
+	}
+	pctx[fmt.Sprintf("probe.%s.req.%s.proxy", probe.Name, requestName)] = proxy.String()
+}
@@ -0,0 +1,81 @@
+package probing
+
+import (
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RedirectRecorder applies the redirect policy of a probe request and records every redirect hop into the probe context
+// under "probe.<probe>.req.<request>.redirect.<n>.*"
+type RedirectRecorder struct {
+	ProbeName   string
+	RequestName string
+	Follow      bool
+	Max         int
+	Context     internal.ProbeContext
+
+	lastMark  time.Time
+	locations []string
+	codes     []int
+}
+
+// NewRedirectRecorder creates a RedirectRecorder following the redirect settings of the probe request.
+func NewRedirectRecorder(pctx internal.ProbeContext, probeName string, probeRequest *internal.ProbeRequest) *RedirectRecorder {
+	return &RedirectRecorder{
+		ProbeName:   probeName,
+		RequestName: probeRequest.Name,
+		Follow:      probeRequest.ShouldFollowRedirects(),
+		Max:         probeRequest.RedirectLimit(),
+		Context:     pctx,
+		lastMark:    time.Now(),
+		locations:   make([]string, 0),
+		codes:       make([]int, 0),
+	}
+}
+
+// Start resets the hop timer and clears the hops recorded by a previous attempt, should be called right before the http call is made.
+func (rr *RedirectRecorder) Start() {
+	rr.lastMark = time.Now()
+	prefix := fmt.Sprintf("probe.%s.req.%s.redirect.", rr.ProbeName, rr.RequestName)
+	for key := range rr.Context {
+		if strings.HasPrefix(key, prefix) {
+			delete(rr.Context, key)
+		}
+	}
+	rr.Context[prefix+"count"] = 0
+}
+
+// CheckRedirect is to be used as http.Client's CheckRedirect.
+func (rr *RedirectRecorder) CheckRedirect(req *http.Request, via []*http.Request) error {
+	hop := len(via) - 1
+	prefix := fmt.Sprintf("probe.%s.req.%s.redirect", rr.ProbeName, rr.RequestName)
+	now := time.Now()
+
+	code := 0
+	if req.Response != nil {
+		code = req.Response.StatusCode
+	}
+	rr.locations = append(rr.locations, req.URL.String())
+	rr.codes = append(rr.codes, code)
+
+	rr.Context[fmt.Sprintf("%s.%d.url", prefix, hop)] = via[hop].URL.String()
+	rr.Context[fmt.Sprintf("%s.%d.code", prefix, hop)] = code
+	rr.Context[fmt.Sprintf("%s.%d.location", prefix, hop)] = req.URL.String()
+	rr.Context[fmt.Sprintf("%s.%d.duration", prefix, hop)] = now.Sub(rr.lastMark)
+	rr.Context[fmt.Sprintf("%s.count", prefix)] = len(rr.locations)
+	rr.Context[fmt.Sprintf("%s.location", prefix)] = rr.locations
+	rr.Context[fmt.Sprintf("%s.code", prefix)] = rr.codes
+	rr.lastMark = now
+
+	if !rr.Follow {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > rr.Max {
+		return fmt.Errorf("%w : stopped after %d redirects", errors.ErrTooManyRedirects, rr.Max)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package probing
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RetryClassTimeout    = "timeout"
+	RetryClassDNS        = "dns"
+	RetryClassTLS        = "tls"
+	RetryClassConnection = "connection"
+	RetryClassError      = "error"
+)
+
+// RetryPolicy decides whether a failed attempt of a probe request should be retried and how long to wait.
+type RetryPolicy struct {
+	Retries    int
+	RetryOn    []string
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewRetryPolicy creates the RetryPolicy of a probe request, applying the defaults.
+func NewRetryPolicy(probeRequest *internal.ProbeRequest) *RetryPolicy {
+	policy := &RetryPolicy{
+		Retries:    probeRequest.Retries,
+		RetryOn:    probeRequest.RetryOn,
+		Backoff:    probeRequest.RetryBackoff,
+		MaxBackoff: probeRequest.RetryMaxBackoff,
+	}
+	if policy.Retries < 0 {
+		policy.Retries = 0
+	}
+	if len(policy.RetryOn) == 0 {
+		policy.RetryOn = internal.DefaultRetryOn
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = internal.DefaultRetryBackoff
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = internal.DefaultRetryMaxBackoff
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	return policy
+}
+
+// ClassifyError returns the failure class of an http call error.
+func ClassifyError(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return RetryClassTimeout
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return RetryClassDNS
+	}
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		strings.Contains(err.Error(), "tls: ") {
+		return RetryClassTLS
+	}
+	return RetryClassConnection
+}
+
+// Classify returns the failure class of an attempt, either the class of the error or the response status code.
+func (policy *RetryPolicy) Classify(err error, response *http.Response) string {
+	if err != nil {
+		return ClassifyError(err)
+	}
+	return strconv.Itoa(response.StatusCode)
+}
+
+// ShouldRetry checks if the class is listed in RetryOn
+func (policy *RetryPolicy) ShouldRetry(class string) bool {
+	for _, on := range policy.RetryOn {
+		on = strings.ToLower(strings.TrimSpace(on))
+		if on == class {
+			return true
+		}
+		if _, err := strconv.Atoi(class); err == nil {
+			if len(on) == 3 && strings.HasSuffix(on, "xx") && on[0] == class[0] {
+				return true
+			}
+		} else if on == RetryClassError {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns how long to wait before the specified retry (1 for the first retry). The delay grows exponentially
+// from Backoff up to MaxBackoff, and is randomized between half and the full amount to avoid retrying in lock step.
+func (policy *RetryPolicy) Delay(retry int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < retry && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// Wait sleeps for the delay of the specified retry, returns error if the context is done before that.
+func (policy *RetryPolicy) Wait(ctx context.Context, retry int) error {
+	timer := time.NewTimer(policy.Delay(retry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RecordAttempt stores the outcome of an attempt into "probe.<probe>.req.<request>.attempt.<n>.*"
+func RecordAttempt(pctx internal.ProbeContext, probeName, requestName string, attempt int, class string, err error, response *http.Response, duration time.Duration, retrying bool) {
+	prefix := fmt.Sprintf("probe.%s.req.%s.attempt.%d", probeName, requestName, attempt)
+	pctx[fmt.Sprintf("%s.class", prefix)] = class
+	pctx[fmt.Sprintf("%s.duration", prefix)] = duration
+	if err != nil {
+		pctx[fmt.Sprintf("%s.error", prefix)] = err.Error()
+	}
+	if response != nil {
+		pctx[fmt.Sprintf("%s.code", prefix)] = response.StatusCode
+	}
+	if retrying {
+		pctx[fmt.Sprintf("%s.outcome", prefix)] = "retry"
+	} else {
+		pctx[fmt.Sprintf("%s.outcome", prefix)] = "final"
+	}
+	pctx[fmt.Sprintf("probe.%s.req.%s.attempts", probeName, requestName)] = attempt
+}
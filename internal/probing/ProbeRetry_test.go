@@ -0,0 +1,103 @@
+package probing
+
+import (
+	"context"
+	"errors"
+	"github.com/newm4n/mihp/internal"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_ShouldRetry(t *testing.T) {
+	policy := NewRetryPolicy(&internal.ProbeRequest{RetryOn: []string{"timeout", "5xx", "429"}})
+	assert.True(t, policy.ShouldRetry(RetryClassTimeout))
+	assert.True(t, policy.ShouldRetry("503"))
+	assert.True(t, policy.ShouldRetry("429"))
+	assert.False(t, policy.ShouldRetry("404"))
+	assert.False(t, policy.ShouldRetry(RetryClassConnection))
+
+	policy = NewRetryPolicy(&internal.ProbeRequest{RetryOn: []string{"error"}})
+	assert.True(t, policy.ShouldRetry(RetryClassDNS))
+	assert.True(t, policy.ShouldRetry(RetryClassTLS))
+	assert.False(t, policy.ShouldRetry("500"))
+
+	policy = NewRetryPolicy(&internal.ProbeRequest{})
+	assert.Equal(t, internal.DefaultRetryOn, policy.RetryOn)
+	assert.True(t, policy.ShouldRetry(RetryClassConnection))
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := NewRetryPolicy(&internal.ProbeRequest{RetryBackoff: 100 * time.Millisecond, RetryMaxBackoff: 300 * time.Millisecond})
+	for i := 0; i < 20; i++ {
+		d := policy.Delay(1)
+		assert.True(t, d >= 50*time.Millisecond && d <= 100*time.Millisecond, "first retry delay %s", d)
+		d = policy.Delay(2)
+		assert.True(t, d >= 100*time.Millisecond && d <= 200*time.Millisecond, "second retry delay %s", d)
+		d = policy.Delay(10)
+		assert.True(t, d >= 150*time.Millisecond && d <= 300*time.Millisecond, "capped retry delay %s", d)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	assert.Equal(t, RetryClassTimeout, ClassifyError(context.DeadlineExceeded))
+	assert.Equal(t, RetryClassDNS, ClassifyError(&net.DNSError{Err: "no such host", Name: "nowhere.invalid"}))
+	assert.Equal(t, RetryClassConnection, ClassifyError(errors.New("connection reset by peer")))
+}
+
+func TestProbe_Retry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	req := &internal.ProbeRequest{
+		Name:          "Flaky",
+		PathExpr:      `"/"`,
+		MethodExpr:    `"GET"`,
+		SuccessIfExpr: `probe.Retry.req.Flaky.resp.code == 200`,
+		Retries:       2,
+		RetryOn:       []string{"503"},
+		RetryBackoff:  10 * time.Millisecond,
+	}
+	probe := &internal.Probe{
+		Name:     "Retry",
+		ID:       "1008",
+		Requests: []*internal.ProbeRequest{req},
+		BaseURL:  srv.URL,
+		Cron:     "* * * * * * *",
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, 2, pCtx["probe.Retry.req.Flaky.attempts"])
+	assert.Equal(t, "retry", pCtx["probe.Retry.req.Flaky.attempt.1.outcome"])
+	assert.Equal(t, "503", pCtx["probe.Retry.req.Flaky.attempt.1.class"])
+	assert.Equal(t, "final", pCtx["probe.Retry.req.Flaky.attempt.2.outcome"])
+
+	// a per request timeout shorter than the server response yields a timeout failure
+	slow := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		resp.WriteHeader(http.StatusOK)
+	}))
+	defer slow.Close()
+	probe.BaseURL = slow.URL
+	req.Timeout = 50 * time.Millisecond
+	req.Retries = 1
+	req.RetryOn = []string{"timeout"}
+	pCtx = internal.NewProbeContext()
+	err := ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrHttpCallError))
+	assert.Equal(t, 2, pCtx["probe.Retry.req.Flaky.attempts"])
+	assert.Equal(t, RetryClassTimeout, pCtx["probe.Retry.req.Flaky.attempt.2.class"])
+}
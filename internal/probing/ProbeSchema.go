@@ -0,0 +1,102 @@
+package probing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	schemaCache      = make(map[string]*jsonschema.Schema)
+	schemaCacheMutex sync.RWMutex
+)
+
+// IsInlineSchema checks if the schema source is the schema itself rather than a file path.
+func IsInlineSchema(source string) bool {
+	trimmed := strings.TrimSpace(source)
+	return strings.HasPrefix(trimmed, "{") || trimmed == "true" || trimmed == "false"
+}
+
+// LoadSchema compiles a JSON Schema, given inline or as a file path. Compiled schemas are cached by their source,
+// so a schema file is only read once.
+func LoadSchema(source string) (*jsonschema.Schema, error) {
+	schemaCacheMutex.RLock()
+	schema, ok := schemaCache[source]
+	schemaCacheMutex.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	var err error
+	if IsInlineSchema(source) {
+		schema, err = jsonschema.CompileString("inline.json", source)
+	} else {
+		schema, err = jsonschema.Compile(strings.TrimSpace(source))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w : schema [%s] got %s", errors.ErrSchemaError, source, err.Error())
+	}
+
+	schemaCacheMutex.Lock()
+	defer schemaCacheMutex.Unlock()
+	schemaCache[source] = schema
+	return schema, nil
+}
+
+// ValidateJSON validates the json document against the schema and returns the violations, one message per
+// failing value such as "/items/0/price: expected number, but got string". A document that can not be parsed
+// is reported as a violation too.
+func ValidateJSON(schema *jsonschema.Schema, document string) []string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return []string{fmt.Sprintf("document is not a valid json. got %s", err.Error())}
+	}
+	err := schema.Validate(doc)
+	if err == nil {
+		return []string{}
+	}
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []string{err.Error()}
+	}
+	violations := make([]string, 0)
+	var collect func(ve *jsonschema.ValidationError)
+	collect = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			location := ve.InstanceLocation
+			if len(location) == 0 {
+				location = "/"
+			}
+			violations = append(violations, fmt.Sprintf("%s: %s", location, ve.Message))
+		}
+		for _, cause := range ve.Causes {
+			collect(cause)
+		}
+	}
+	collect(validationErr)
+	sort.Strings(violations)
+	return violations
+}
+
+// ValidateResponseSchema validates the captured response body against the request's ResponseSchema,
+// recording "resp.schema.valid" and "resp.schema.errors". An error is returned only if the schema can not be loaded.
+func ValidateResponseSchema(pctx internal.ProbeContext, probe *internal.Probe, probeRequest *internal.ProbeRequest) error {
+	schema, err := LoadSchema(probeRequest.ResponseSchema)
+	if err != nil {
+		return err
+	}
+	var violations []string
+	if body, ok := pctx[fmt.Sprintf("probe.%s.req.%s.resp.body", probe.Name, probeRequest.Name)].(string); ok {
+		violations = ValidateJSON(schema, body)
+	} else {
+		violations = []string{"response body is not captured"}
+	}
+	pctx[fmt.Sprintf("probe.%s.req.%s.resp.schema.valid", probe.Name, probeRequest.Name)] = len(violations) == 0
+	pctx[fmt.Sprintf("probe.%s.req.%s.resp.schema.errors", probe.Name, probeRequest.Name)] = violations
+	return nil
+}
@@ -0,0 +1,131 @@
+package probing
+
+import (
+	"context"
+	"errors"
+	"github.com/newm4n/mihp/internal"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["id", "items"],
+	"properties": {
+		"id": {"type": "string"},
+		"items": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["price"],
+				"properties": {"price": {"type": "number", "minimum": 0}}
+			}
+		}
+	}
+}`
+
+func TestValidateJSON(t *testing.T) {
+	schema, err := LoadSchema(orderSchema)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{}, ValidateJSON(schema, `{"id": "o-1", "items": [{"price": 1.5}]}`))
+
+	violations := ValidateJSON(schema, `{"id": 1, "items": [{"price": "free"}, {"price": -1}, {}]}`)
+	assert.Len(t, violations, 4)
+	assert.Contains(t, violations[0], "/id: expected string, but got number")
+	assert.Contains(t, violations[1], "/items/0/price: expected number, but got string")
+	assert.Contains(t, violations[2], "/items/1/price: must be >= 0")
+	assert.Contains(t, violations[3], "/items/2: missing properties: 'price'")
+
+	violations = ValidateJSON(schema, `not a json`)
+	assert.Len(t, violations, 1)
+	assert.Contains(t, violations[0], "document is not a valid json")
+
+	file := filepath.Join(t.TempDir(), "order.json")
+	assert.NoError(t, os.WriteFile(file, []byte(orderSchema), 0644))
+	fileSchema, err := LoadSchema(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, ValidateJSON(fileSchema, `{"id": "o-1", "items": []}`))
+
+	_, err = LoadSchema(`{"type": 12}`)
+	assert.True(t, errors.Is(err, mihperrors.ErrSchemaError))
+	_, err = LoadSchema(filepath.Join(t.TempDir(), "missing.json"))
+	assert.True(t, errors.Is(err, mihperrors.ErrSchemaError))
+}
+
+func TestGoCelSchemaFunctions(t *testing.T) {
+	pc := internal.NewProbeContext()
+	pc["ref.schema"] = orderSchema
+	pc["ref.good"] = `{"id": "o-1", "items": [{"price": 1.5}]}`
+	pc["ref.bad"] = `{"id": "o-1", "items": [{"price": "1.5"}]}`
+
+	out, err := GoCelEvaluate(context.Background(), `SchemaValid(ref.good, ref.schema) && !SchemaValid(ref.bad, ref.schema)`, pc, reflect.Bool)
+	assert.NoError(t, err)
+	assert.True(t, out.(bool))
+
+	out, err = GoCelEvaluate(context.Background(), `SchemaErrors(ref.bad, ref.schema)`, pc, reflect.Interface)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/items/0/price: expected number, but got string"}, out)
+
+	_, err = GoCelEvaluate(context.Background(), `SchemaValid(ref.good, "{\"type\": 12}")`, pc, reflect.Bool)
+	assert.Error(t, err)
+}
+
+func TestProbe_ResponseSchema(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusOK)
+		switch req.URL.Path {
+		case "/orders/1":
+			resp.Write([]byte(`{"id": "1", "items": [{"price": 3}]}`))
+		default:
+			resp.Write([]byte(`{"id": 2, "items": [{"price": "3"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Schema",
+		ID:   "1012",
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:           "Good",
+				PathExpr:       `"/orders/1"`,
+				MethodExpr:     `"GET"`,
+				ResponseSchema: orderSchema,
+				SuccessIfExpr:  `this.resp.schema.valid`,
+			},
+			{
+				Name:           "Drifted",
+				PathExpr:       `"/orders/2"`,
+				MethodExpr:     `"GET"`,
+				ResponseSchema: orderSchema,
+			},
+		},
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, true, pCtx["probe.Schema.req.Good.resp.schema.valid"])
+	assert.Equal(t, []string{}, pCtx["probe.Schema.req.Good.resp.schema.errors"])
+	assert.Equal(t, false, pCtx["probe.Schema.req.Drifted.resp.schema.valid"])
+	assert.Equal(t, []string{"/id: expected string, but got number", "/items/0/price: expected number, but got string"}, pCtx["probe.Schema.req.Drifted.resp.schema.errors"])
+
+	probe.Requests[1].SuccessIfExpr = `this.resp.schema.valid`
+	pCtx = internal.NewProbeContext()
+	err := ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrSuccessIfIsFalse))
+
+	probe.Requests[1].ResponseSchema = "does-not-exist.json"
+	pCtx = internal.NewProbeContext()
+	err = ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrSchemaError))
+}
@@ -0,0 +1,195 @@
+package probing
+
+import (
+	"fmt"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/newm4n/mihp/internal"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// ScopeThis is the alias of the request being executed, "this.resp.code" is "probe.<probe>.req.<current>.resp.code"
+	ScopeThis = "this"
+	// ScopePrev is the alias of the request executed before the current one.
+	ScopePrev = "prev"
+	// ScopeReq is the alias of the probe's requests by name, "req.Login.resp.code" or req["Login"].resp.code
+	ScopeReq = "req"
+)
+
+// CurrentRequestKey returns the probe context key holding the name of the request being executed.
+func CurrentRequestKey(probeName string) string {
+	return fmt.Sprintf("probe.%s.current", probeName)
+}
+
+// PreviousRequestKey returns the probe context key holding the name of the request executed before the current one.
+func PreviousRequestKey(probeName string) string {
+	return fmt.Sprintf("probe.%s.previous", probeName)
+}
+
+// ScopePrefix returns the flat key prefix the alias stands for in the probe context.
+// It returns false if the alias is not known or not available, eg. "prev" during the first request.
+func ScopePrefix(pctx internal.ProbeContext, alias string) (string, bool) {
+	probeName, ok := pctx["probe"].(string)
+	if !ok {
+		return "", false
+	}
+	switch alias {
+	case ScopeThis:
+		if current, ok := pctx[CurrentRequestKey(probeName)].(string); ok {
+			return fmt.Sprintf("probe.%s.req.%s", probeName, current), true
+		}
+	case ScopePrev:
+		if previous, ok := pctx[PreviousRequestKey(probeName)].(string); ok {
+			return fmt.Sprintf("probe.%s.req.%s", probeName, previous), true
+		}
+	case ScopeReq:
+		return fmt.Sprintf("probe.%s.req", probeName), true
+	}
+	return "", false
+}
+
+// resolveScoped resolves a dotted name starting with one of the aliases. If the name is not a key
+// but a prefix of keys, eg. "this.resp", it resolves into a scope map over those keys.
+func resolveScoped(pctx internal.ProbeContext, name string) (interface{}, bool) {
+	alias, rest := name, ""
+	if idx := strings.Index(name, "."); idx > 0 {
+		alias, rest = name[:idx], name[idx:]
+	}
+	prefix, ok := ScopePrefix(pctx, alias)
+	if !ok {
+		return nil, false
+	}
+	if len(rest) == 0 {
+		return &scopeValue{pctx: pctx, prefix: prefix}, true
+	}
+	key := prefix + rest
+	if val, ok := pctx[key]; ok {
+		return val, true
+	}
+	if hasChildren(pctx, key) {
+		return &scopeValue{pctx: pctx, prefix: key}, true
+	}
+	return nil, false
+}
+
+// hasChildren checks if there is any key in the probe context under the prefix.
+func hasChildren(pctx internal.ProbeContext, prefix string) bool {
+	prefix = prefix + "."
+	for key := range pctx {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeValue exposes the probe context keys under a prefix as a CEL map, keyed by the next name segment.
+type scopeValue struct {
+	pctx   internal.ProbeContext
+	prefix string
+}
+
+// children returns the sorted names of the next segment of the keys under the prefix.
+func (sv *scopeValue) children() []string {
+	prefix := sv.prefix + "."
+	names := make(map[string]bool)
+	for key := range sv.pctx {
+		if strings.HasPrefix(key, prefix) {
+			name := key[len(prefix):]
+			if idx := strings.Index(name, "."); idx >= 0 {
+				name = name[:idx]
+			}
+			names[name] = true
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Find returns the value of the key under the prefix, or the scope of the keys under it.
+// When a key both holds a value and has keys under it, the value is returned, except for the
+// name lists such as "resp.header" whose elements are keys under it, which are returned as a scope.
+func (sv *scopeValue) Find(key ref.Val) (ref.Val, bool) {
+	var name string
+	switch k := key.(type) {
+	case types.String:
+		name = string(k)
+	case types.Int, types.Uint:
+		name = fmt.Sprintf("%v", k.Value())
+	default:
+		return types.MaybeNoSuchOverloadErr(key), false
+	}
+	fullKey := fmt.Sprintf("%s.%s", sv.prefix, name)
+	val, ok := sv.pctx[fullKey]
+	if _, isList := val.([]string); ok && !(isList && hasChildren(sv.pctx, fullKey)) {
+		return types.DefaultTypeAdapter.NativeToValue(val), true
+	}
+	if hasChildren(sv.pctx, fullKey) {
+		return &scopeValue{pctx: sv.pctx, prefix: fullKey}, true
+	}
+	return nil, false
+}
+
+func (sv *scopeValue) Get(key ref.Val) ref.Val {
+	val, found := sv.Find(key)
+	if !found {
+		return types.ValOrErr(val, "no such key: %v", key)
+	}
+	return val
+}
+
+func (sv *scopeValue) Contains(key ref.Val) ref.Val {
+	_, found := sv.Find(key)
+	return types.Bool(found)
+}
+
+func (sv *scopeValue) Size() ref.Val {
+	return types.Int(len(sv.children()))
+}
+
+func (sv *scopeValue) Iterator() traits.Iterator {
+	return types.NewStringList(types.DefaultTypeAdapter, sv.children()).Iterator()
+}
+
+func (sv *scopeValue) ConvertToNative(typeDesc reflect.Type) (interface{}, error) {
+	return nil, fmt.Errorf("scope %s can not be converted to %s", sv.prefix, typeDesc)
+}
+
+func (sv *scopeValue) ConvertToType(typeVal ref.Type) ref.Val {
+	switch typeVal {
+	case types.MapType:
+		return sv
+	case types.TypeType:
+		return types.MapType
+	}
+	return types.NewErr("scope %s can not be converted to %s", sv.prefix, typeVal)
+}
+
+func (sv *scopeValue) Equal(other ref.Val) ref.Val {
+	o, ok := other.(*scopeValue)
+	return types.Bool(ok && o.prefix == sv.prefix)
+}
+
+func (sv *scopeValue) Type() ref.Type {
+	return types.MapType
+}
+
+// Value returns the keys under the prefix, relative to it, with their values.
+func (sv *scopeValue) Value() interface{} {
+	prefix := sv.prefix + "."
+	ret := make(map[string]interface{})
+	for key, val := range sv.pctx {
+		if strings.HasPrefix(key, prefix) {
+			ret[key[len(prefix):]] = val
+		}
+	}
+	return ret
+}
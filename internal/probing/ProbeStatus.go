@@ -0,0 +1,90 @@
+package probing
+
+import (
+	"context"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"reflect"
+	"time"
+)
+
+const (
+	StatusUp       = "UP"
+	StatusDegraded = "DEGRADED"
+	StatusDown     = "DOWN"
+	// StatusFlapping is only reported by the ProbeEventTracker, when the probe keeps changing status.
+	StatusFlapping = "FLAPPING"
+)
+
+// RecordLatency records "latency.budget" and "latency.exceeded" under the prefix. Returns true if the budget is exceeded.
+func RecordLatency(pctx internal.ProbeContext, prefix string, budget, duration time.Duration) bool {
+	if budget <= 0 {
+		pctx[fmt.Sprintf("%s.latency.exceeded", prefix)] = false
+		return false
+	}
+	exceeded := duration > budget
+	pctx[fmt.Sprintf("%s.latency.budget", prefix)] = budget
+	pctx[fmt.Sprintf("%s.latency.exceeded", prefix)] = exceeded
+	return exceeded
+}
+
+// EvaluateRequestStatus records the "degraded" and "status" of a successful request. The request is DEGRADED if it
+// took longer than its latency budget or its DegradedIfExpr returns true.
+func EvaluateRequestStatus(ctx context.Context, pctx internal.ProbeContext, probe *internal.Probe, probeRequest *internal.ProbeRequest) error {
+	prefix := fmt.Sprintf("probe.%s.req.%s", probe.Name, probeRequest.Name)
+	duration, _ := pctx[fmt.Sprintf("%s.duration", prefix)].(time.Duration)
+	degraded := RecordLatency(pctx, prefix, probeRequest.LatencyBudget, duration)
+	if len(probeRequest.DegradedIfExpr) > 0 {
+		out, err := GoCelEvaluate(ctx, probeRequest.DegradedIfExpr, pctx, reflect.Bool)
+		if err != nil {
+			pctx[fmt.Sprintf("%s.success", prefix)] = false
+			pctx[fmt.Sprintf("%s.fail", prefix)] = true
+			pctx[fmt.Sprintf("%s.status", prefix)] = StatusDown
+			pctx[fmt.Sprintf("%s.error", prefix)] = err
+			return fmt.Errorf("%w : probe %s request %s parsing DegradedIfExpr parsing error [%s]", err, probe.Name, probeRequest.Name, probeRequest.DegradedIfExpr)
+		}
+		degraded = degraded || out.(bool)
+	}
+	pctx[fmt.Sprintf("%s.degraded", prefix)] = degraded
+	if degraded {
+		pctx[fmt.Sprintf("%s.status", prefix)] = StatusDegraded
+	} else {
+		pctx[fmt.Sprintf("%s.status", prefix)] = StatusUp
+	}
+	return nil
+}
+
+// RecordProbeStatus records the "degraded" and "status" of a probe run that took the duration. A failed run is DOWN,
+// a successful one is DEGRADED if any of its requests is degraded or the probe took longer than its latency budget.
+func RecordProbeStatus(pctx internal.ProbeContext, probe *internal.Probe, duration time.Duration, err error) string {
+	prefix := fmt.Sprintf("probe.%s", probe.Name)
+	degraded := RecordLatency(pctx, prefix, probe.LatencyBudget, duration)
+	for _, probeRequest := range probe.Requests {
+		if reqDegraded, ok := pctx[fmt.Sprintf("%s.req.%s.degraded", prefix, probeRequest.Name)].(bool); ok && reqDegraded {
+			degraded = true
+		}
+	}
+	status := StatusUp
+	switch {
+	case err != nil:
+		status = StatusDown
+		degraded = false
+	case degraded:
+		status = StatusDegraded
+	}
+	pctx[fmt.Sprintf("%s.degraded", prefix)] = degraded
+	pctx[fmt.Sprintf("%s.status", prefix)] = status
+	return status
+}
+
+// ContextStatus returns the status of the probe recorded in the probe context. Contexts without status, recorded
+// before latency budgets existed, are UP or DOWN by their success flag.
+func ContextStatus(pctx internal.ProbeContext, probeName string) string {
+	if status, ok := pctx[fmt.Sprintf("probe.%s.status", probeName)].(string); ok && len(status) > 0 {
+		return status
+	}
+	if success, ok := pctx[fmt.Sprintf("probe.%s.success", probeName)].(bool); ok && success {
+		return StatusUp
+	}
+	return StatusDown
+}
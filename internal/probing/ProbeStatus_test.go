@@ -0,0 +1,83 @@
+package probing
+
+import (
+	"context"
+	"errors"
+	"github.com/newm4n/mihp/internal"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbe_LatencyBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		resp.Header().Add("Content-Type", "text/plain")
+		resp.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name: "Budget",
+		ID:   "1015",
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:          "Fast",
+				PathExpr:      `"/fast"`,
+				MethodExpr:    `"GET"`,
+				LatencyBudget: 5 * time.Second,
+				SuccessIfExpr: `this.resp.code == 200`,
+			},
+			{
+				Name:          "Slow",
+				PathExpr:      `"/slow"`,
+				MethodExpr:    `"GET"`,
+				LatencyBudget: 100 * time.Millisecond,
+			},
+		},
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, true, pCtx["probe.Budget.success"])
+	assert.Equal(t, StatusUp, pCtx["probe.Budget.req.Fast.status"])
+	assert.Equal(t, false, pCtx["probe.Budget.req.Fast.latency.exceeded"])
+	assert.Equal(t, true, pCtx["probe.Budget.req.Slow.latency.exceeded"])
+	assert.Equal(t, 100*time.Millisecond, pCtx["probe.Budget.req.Slow.latency.budget"])
+	assert.Equal(t, StatusDegraded, pCtx["probe.Budget.req.Slow.status"])
+	assert.Equal(t, true, pCtx["probe.Budget.degraded"])
+	assert.Equal(t, StatusDegraded, pCtx["probe.Budget.status"])
+
+	probe.Requests[1].LatencyBudget = 0
+	probe.Requests[1].DegradedIfExpr = `this.resp.body != "ok"`
+	pCtx = internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, StatusUp, pCtx["probe.Budget.status"])
+
+	probe.LatencyBudget = 100 * time.Millisecond
+	pCtx = internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, true, pCtx["probe.Budget.latency.exceeded"])
+	assert.Equal(t, StatusUp, pCtx["probe.Budget.req.Slow.status"])
+	assert.Equal(t, StatusDegraded, pCtx["probe.Budget.status"])
+
+	probe.LatencyBudget = 0
+	probe.Requests[1].DegradedIfExpr = `this.timing.ttfb > duration("100ms")`
+	pCtx = internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, true, true))
+	assert.Equal(t, StatusDegraded, pCtx["probe.Budget.status"])
+
+	probe.Requests[0].SuccessIfExpr = `this.resp.code == 500`
+	pCtx = internal.NewProbeContext()
+	err := ExecuteProbe(context.Background(), probe, pCtx, 10, true, true)
+	assert.True(t, errors.Is(err, mihperrors.ErrSuccessIfIsFalse))
+	assert.Equal(t, StatusDown, pCtx["probe.Budget.req.Fast.status"])
+	assert.Equal(t, StatusDown, pCtx["probe.Budget.status"])
+}
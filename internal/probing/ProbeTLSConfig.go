@@ -0,0 +1,113 @@
+package probing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/errors"
+	"golang.org/x/crypto/pkcs12"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// ParseTLSVersion parse version string such as "1.2" or "TLS1.2" into tls.VersionTLSxx constant.
+// Empty string yields 0 which let the crypto/tls package to choose.
+func ParseTLSVersion(version string) (uint16, error) {
+	v := strings.TrimSpace(strings.ToUpper(version))
+	if len(v) == 0 {
+		return 0, nil
+	}
+	v = strings.TrimSpace(strings.TrimPrefix(v, "TLS"))
+	v = strings.TrimPrefix(v, "V")
+	if ver, ok := tlsVersions[v]; ok {
+		return ver, nil
+	}
+	return 0, fmt.Errorf("%w : unknown tls version %s", errors.ErrTLSConfig, version)
+}
+
+// NewTLSConfig creates the tls.Config for a probe. With nil probeTLS, only ignoreTLS is applied.
+func NewTLSConfig(probeTLS *internal.ProbeTLSConfig, ignoreTLS bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: ignoreTLS}
+	if probeTLS == nil {
+		return config, nil
+	}
+	config.InsecureSkipVerify = ignoreTLS || probeTLS.InsecureSkipVerify
+	config.ServerName = probeTLS.ServerName
+
+	var err error
+	if config.MinVersion, err = ParseTLSVersion(probeTLS.MinVersion); err != nil {
+		return nil, err
+	}
+	if config.MaxVersion, err = ParseTLSVersion(probeTLS.MaxVersion); err != nil {
+		return nil, err
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return nil, fmt.Errorf("%w : min version %s is above max version %s", errors.ErrTLSConfig, probeTLS.MinVersion, probeTLS.MaxVersion)
+	}
+
+	if len(probeTLS.RootCAFile) > 0 {
+		pemBytes, err := ioutil.ReadFile(probeTLS.RootCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w : can not read root ca file %s. got %s", errors.ErrTLSConfig, probeTLS.RootCAFile, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("%w : root ca file %s contains no PEM certificate", errors.ErrTLSConfig, probeTLS.RootCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(probeTLS.PKCS12File) > 0 {
+		cert, err := loadPKCS12(probeTLS.PKCS12File, probeTLS.PKCS12Password)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if len(probeTLS.ClientCertFile) > 0 || len(probeTLS.ClientKeyFile) > 0 {
+		if len(probeTLS.ClientCertFile) == 0 || len(probeTLS.ClientKeyFile) == 0 {
+			return nil, fmt.Errorf("%w : both client certificate and key file must be specified", errors.ErrTLSConfig)
+		}
+		cert, err := tls.LoadX509KeyPair(probeTLS.ClientCertFile, probeTLS.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w : can not load client certificate %s. got %s", errors.ErrTLSConfig, probeTLS.ClientCertFile, err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadPKCS12(path, password string) (tls.Certificate, error) {
+	p12Bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w : can not read pkcs12 file %s. got %s", errors.ErrTLSConfig, path, err.Error())
+	}
+	blocks, err := pkcs12.ToPEM(p12Bytes, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w : can not decode pkcs12 file %s. got %s", errors.ErrTLSConfig, path, err.Error())
+	}
+	var certPEM, keyPEM []byte
+	for _, block := range blocks {
+		pemBytes := pem.EncodeToMemory(block)
+		if block.Type == "CERTIFICATE" {
+			certPEM = append(certPEM, pemBytes...)
+		} else if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			keyPEM = append(keyPEM, pemBytes...)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w : pkcs12 file %s contains no usable key pair. got %s", errors.ErrTLSConfig, path, err.Error())
+	}
+	return cert, nil
+}
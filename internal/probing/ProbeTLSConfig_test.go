@@ -0,0 +1,155 @@
+package probing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/newm4n/mihp/internal"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := ParseTLSVersion("1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v)
+	v, err = ParseTLSVersion("TLS1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+	v, err = ParseTLSVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), v)
+	_, err = ParseTLSVersion("1.4")
+	assert.Error(t, err)
+}
+
+func TestProbe_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)
+	ca := newTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "MIHP Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil)
+	server := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "probe.internal"},
+		DNSNames:     []string{"probe.internal"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := newTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "mihp-minion"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	assert.NoError(t, ioutil.WriteFile(caFile, ca.certPEM, 0600))
+	assert.NoError(t, ioutil.WriteFile(certFile, client.certPEM, 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, client.keyPEM, 0600))
+
+	serverKeyPair, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	assert.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Content-Type", "text/plain")
+		resp.WriteHeader(http.StatusOK)
+		resp.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	probe := &internal.Probe{
+		Name:    "MTLS",
+		ID:      "1007",
+		BaseURL: srv.URL,
+		Cron:    "* * * * * * *",
+		TLS: &internal.ProbeTLSConfig{
+			ClientCertFile: certFile,
+			ClientKeyFile:  keyFile,
+			RootCAFile:     caFile,
+			ServerName:     "probe.internal",
+			MinVersion:     "1.2",
+		},
+		Requests: []*internal.ProbeRequest{
+			{
+				Name:          "Home",
+				PathExpr:      `"/"`,
+				MethodExpr:    `"GET"`,
+				SuccessIfExpr: `probe.MTLS.req.Home.resp.body == "mihp-minion"`,
+			},
+		},
+	}
+
+	pCtx := internal.NewProbeContext()
+	assert.NoError(t, ExecuteProbe(context.Background(), probe, pCtx, 10, false, true))
+
+	// without client certificate, the server refuse the handshake
+	probe.TLS.ClientCertFile = ""
+	probe.TLS.ClientKeyFile = ""
+	pCtx = internal.NewProbeContext()
+	assert.Error(t, ExecuteProbe(context.Background(), probe, pCtx, 10, false, true))
+
+	// strict validation by default, the server certificate is not trusted by system roots
+	probe.TLS = nil
+	pCtx = internal.NewProbeContext()
+	assert.Error(t, ExecuteProbe(context.Background(), probe, pCtx, 10, false, true))
+}
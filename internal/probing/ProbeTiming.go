@@ -0,0 +1,140 @@
+package probing
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/newm4n/mihp/internal"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HttpTiming collects the timestamps of each phase of a single HTTP call through net/http/httptrace.
+type HttpTiming struct {
+	mutex sync.Mutex
+
+	Start         time.Time
+	DNSStart      time.Time
+	DNSDone       time.Time
+	ConnectStart  time.Time
+	ConnectDone   time.Time
+	TLSStart      time.Time
+	TLSDone       time.Time
+	WroteRequest  time.Time
+	FirstByte     time.Time
+	TransferDone  time.Time
+	ConnectReused bool
+}
+
+// NewHttpTiming creates a new HttpTiming with the start time set to now.
+func NewHttpTiming() *HttpTiming {
+	return &HttpTiming{Start: time.Now()}
+}
+
+func (ht *HttpTiming) mark(t *time.Time) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+	*t = time.Now()
+}
+
+// ClientTrace returns the httptrace.ClientTrace hooks that record into this timing.
+func (ht *HttpTiming) ClientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			ht.mutex.Lock()
+			defer ht.mutex.Unlock()
+			ht.ConnectReused = info.Reused
+		},
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			ht.mark(&ht.DNSStart)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			ht.mark(&ht.DNSDone)
+		},
+		ConnectStart: func(network, addr string) {
+			ht.mutex.Lock()
+			defer ht.mutex.Unlock()
+			// on dual stack, multiple connection can be attempted. only the first one counts.
+			if ht.ConnectStart.IsZero() {
+				ht.ConnectStart = time.Now()
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ht.mark(&ht.ConnectDone)
+		},
+		TLSHandshakeStart: func() {
+			ht.mark(&ht.TLSStart)
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			ht.mark(&ht.TLSDone)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			ht.mark(&ht.WroteRequest)
+		},
+		GotFirstResponseByte: func() {
+			ht.mark(&ht.FirstByte)
+		},
+	}
+}
+
+// Done marks the end of the response body transfer.
+func (ht *HttpTiming) Done() {
+	ht.mark(&ht.TransferDone)
+}
+
+func span(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+// DNS is the duration of the DNS lookup. zero if no lookup were made (eg. reused connection or IP address)
+func (ht *HttpTiming) DNS() time.Duration {
+	return span(ht.DNSStart, ht.DNSDone)
+}
+
+// Connect is the duration to establish the TCP connection.
+func (ht *HttpTiming) Connect() time.Duration {
+	return span(ht.ConnectStart, ht.ConnectDone)
+}
+
+// TLS is the duration of the TLS handshake.
+func (ht *HttpTiming) TLS() time.Duration {
+	return span(ht.TLSStart, ht.TLSDone)
+}
+
+// Server is the duration from the request fully written until the first response byte arrived.
+func (ht *HttpTiming) Server() time.Duration {
+	return span(ht.WroteRequest, ht.FirstByte)
+}
+
+// TTFB is the duration from the start of the call until the first response byte arrived.
+func (ht *HttpTiming) TTFB() time.Duration {
+	return span(ht.Start, ht.FirstByte)
+}
+
+// Transfer is the duration to read the response body, from the first byte to the last.
+func (ht *HttpTiming) Transfer() time.Duration {
+	return span(ht.FirstByte, ht.TransferDone)
+}
+
+// Total is the duration from the start of the call until the response body has been read.
+func (ht *HttpTiming) Total() time.Duration {
+	return span(ht.Start, ht.TransferDone)
+}
+
+// Record stores all phase durations into the probe context under "probe.<probe>.req.<request>.timing.*"
+func (ht *HttpTiming) Record(pctx internal.ProbeContext, probeName, requestName string) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+	prefix := fmt.Sprintf("probe.%s.req.%s.timing", probeName, requestName)
+	pctx[fmt.Sprintf("%s.dns", prefix)] = ht.DNS()
+	pctx[fmt.Sprintf("%s.connect", prefix)] = ht.Connect()
+	pctx[fmt.Sprintf("%s.tls", prefix)] = ht.TLS()
+	pctx[fmt.Sprintf("%s.server", prefix)] = ht.Server()
+	pctx[fmt.Sprintf("%s.ttfb", prefix)] = ht.TTFB()
+	pctx[fmt.Sprintf("%s.transfer", prefix)] = ht.Transfer()
+	pctx[fmt.Sprintf("%s.total", prefix)] = ht.Total()
+	pctx[fmt.Sprintf("%s.reused", prefix)] = ht.ConnectReused
+}
@@ -0,0 +1,747 @@
+package probing
+
+import (
+	"fmt"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/operators"
+	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/pkg/helper/cron"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationIssue is a problem found in a configuration by ValidateConfig
+type ValidationIssue struct {
+	Severity string
+	File     string
+	Probe    string
+	Request  string
+	Field    string
+	Message  string
+}
+
+func (vi *ValidationIssue) String() string {
+	location := make([]string, 0, 4)
+	if len(vi.File) > 0 {
+		location = append(location, vi.File)
+	}
+	if len(vi.Probe) > 0 {
+		location = append(location, fmt.Sprintf("probe %q", vi.Probe))
+	}
+	if len(vi.Request) > 0 {
+		location = append(location, fmt.Sprintf("request %q", vi.Request))
+	}
+	if len(vi.Field) > 0 {
+		location = append(location, vi.Field)
+	}
+	return fmt.Sprintf("%s: %s: %s", vi.Severity, strings.Join(location, " "), vi.Message)
+}
+
+// ValidationReport list all issues found in a configuration
+type ValidationReport struct {
+	File   string
+	Issues []*ValidationIssue
+}
+
+// Count returns the number of issues of the severity.
+func (vr *ValidationReport) Count(severity string) int {
+	count := 0
+	for _, issue := range vr.Issues {
+		if issue.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// HasError tells if at least one issue is an error.
+func (vr *ValidationReport) HasError() bool {
+	return vr.Count(SeverityError) > 0
+}
+
+func (vr *ValidationReport) String() string {
+	var sb strings.Builder
+	for _, issue := range vr.Issues {
+		sb.WriteString(issue.String())
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fmt.Sprintf("%d error(s), %d warning(s)\n", vr.Count(SeverityError), vr.Count(SeverityWarning)))
+	return sb.String()
+}
+
+func (vr *ValidationReport) add(severity, probe, request, field, format string, args ...interface{}) {
+	vr.Issues = append(vr.Issues, &ValidationIssue{
+		Severity: severity,
+		File:     vr.File,
+		Probe:    probe,
+		Request:  request,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// knownKeys is the set of probe context keys available at some point of a probe execution.
+type knownKeys struct {
+	exact    map[string]bool
+	prefixes []string
+}
+
+func newKnownKeys() *knownKeys {
+	return &knownKeys{exact: make(map[string]bool), prefixes: make([]string, 0)}
+}
+
+func (kk *knownKeys) add(keys ...string) {
+	for _, key := range keys {
+		kk.exact[key] = true
+	}
+}
+
+// addPrefix adds the keys whose last segments are only known at runtime, such as response header names.
+func (kk *knownKeys) addPrefix(prefixes ...string) {
+	kk.prefixes = append(kk.prefixes, prefixes...)
+}
+
+func (kk *knownKeys) addAll(other *knownKeys) {
+	for key := range other.exact {
+		kk.exact[key] = true
+	}
+	kk.prefixes = append(kk.prefixes, other.prefixes...)
+}
+
+func (kk *knownKeys) has(key string) bool {
+	if kk.exact[key] {
+		return true
+	}
+	for _, prefix := range kk.prefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUnder checks if there is any key under the prefix, as the scope aliases can refer to a group of keys such as this.resp
+func (kk *knownKeys) hasUnder(prefix string) bool {
+	prefix = prefix + "."
+	for key := range kk.exact {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, keyPrefix := range kk.prefixes {
+		if strings.HasPrefix(keyPrefix, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// request execution stages, each stage can use the keys produced by the previous ones.
+const (
+	stageStart = iota
+	stagePath
+	stageMethod
+	stageBody
+	stageHeaders
+	stageAuth
+	stageCertificate
+	stageExtract
+	stageResult
+	stageDegraded
+	stageDone
+)
+
+var (
+	responseKeys = []string{
+		"duration", "attempts", "error", "outcome",
+		"timing.dns", "timing.connect", "timing.tls", "timing.server", "timing.ttfb", "timing.transfer", "timing.total", "timing.reused",
+		"resp.code", "resp.url", "resp.header",
+		"resp.body", "resp.body.size", "resp.body.truncated", "resp.body.sha256", "resp.body.mediatype", "resp.body.encoding", "resp.body.base64",
+		"redirect.count", "redirect.location", "redirect.code",
+		"tls.enabled", "tls.version", "tls.cipher", "tls.servername",
+		"tls.chain.length", "tls.chain.subject", "tls.chain.issuer", "tls.chain.daystoexpire",
+		"tls.cert.subject", "tls.cert.subject.cn", "tls.cert.issuer", "tls.cert.issuer.cn", "tls.cert.san",
+		"tls.cert.notbefore", "tls.cert.notafter", "tls.cert.daystoexpire", "tls.cert.serial", "tls.cert.algorithm",
+	}
+	responseKeyPrefixes = []string{"resp.header.", "redirect.", "attempt."}
+
+	// requestKeyTypes are the types of the request keys, expressions using them are type checked against these.
+	requestKeyTypes = map[string]*exprpb.Type{
+		"sequence": decls.Int, "canstart": decls.Bool, "url": decls.String, "method": decls.String, "body": decls.String,
+		"starttime": decls.Timestamp, "proxy": decls.String, "duration": decls.Duration, "attempts": decls.Int, "outcome": decls.String,
+		"auth.type": decls.String, "auth.date": decls.String, "auth.token": decls.String, "auth.cached": decls.Bool,
+		"auth.expiry": decls.Timestamp, "auth.canonical": decls.String,
+		"timing.dns": decls.Duration, "timing.connect": decls.Duration, "timing.tls": decls.Duration, "timing.server": decls.Duration,
+		"timing.ttfb": decls.Duration, "timing.transfer": decls.Duration, "timing.total": decls.Duration, "timing.reused": decls.Bool,
+		"resp.code": decls.Int, "resp.url": decls.String,
+		"resp.body": decls.String, "resp.body.size": decls.Int, "resp.body.truncated": decls.Bool, "resp.body.sha256": decls.String,
+		"resp.body.mediatype": decls.String, "resp.body.encoding": decls.String, "resp.body.base64": decls.String,
+		"resp.schema.valid": decls.Bool, "resp.schema.errors": decls.NewListType(decls.String),
+		"redirect.count": decls.Int, "redirect.location": decls.NewListType(decls.String), "redirect.code": decls.NewListType(decls.Int),
+		"tls.check": decls.Bool, "tls.enabled": decls.Bool, "tls.version": decls.String, "tls.cipher": decls.String, "tls.servername": decls.String,
+		"tls.chain.length": decls.Int, "tls.chain.subject": decls.NewListType(decls.String), "tls.chain.issuer": decls.NewListType(decls.String),
+		"tls.chain.daystoexpire": decls.Int, "tls.cert.subject": decls.String, "tls.cert.subject.cn": decls.String,
+		"tls.cert.issuer": decls.String, "tls.cert.issuer.cn": decls.String, "tls.cert.san": decls.NewListType(decls.String),
+		"tls.cert.notbefore": decls.Timestamp, "tls.cert.notafter": decls.Timestamp, "tls.cert.daystoexpire": decls.Int,
+		"tls.cert.serial": decls.String, "tls.cert.algorithm": decls.String,
+		"success": decls.Bool, "fail": decls.Bool, "degraded": decls.Bool, "status": decls.String,
+		"latency.budget": decls.Duration, "latency.exceeded": decls.Bool,
+	}
+)
+
+// requestKeys returns the keys of a request produced before the stage.
+func requestKeys(probe *internal.Probe, request *internal.ProbeRequest, stage int) *knownKeys {
+	kk := newKnownKeys()
+	prefix := fmt.Sprintf("probe.%s.req.%s.", probe.Name, request.Name)
+	add := func(keys ...string) {
+		for _, key := range keys {
+			kk.add(prefix + key)
+		}
+	}
+	add("sequence")
+	if stage > stageStart {
+		add("canstart")
+	}
+	if stage > stagePath {
+		add("url")
+	}
+	if stage > stageMethod {
+		add("method")
+	}
+	if stage > stageBody && len(request.BodyExpr) > 0 {
+		add("body")
+	}
+	auth := EffectiveAuth(probe, request)
+	if stage > stageHeaders {
+		add("header")
+		kk.addPrefix(prefix + "header.")
+		if auth != nil {
+			add("auth.type")
+			if auth.Type == internal.AuthHMAC {
+				add("auth.date")
+			}
+		}
+	}
+	if stage > stageAuth {
+		add("starttime", "proxy")
+		add(responseKeys...)
+		if auth != nil {
+			switch auth.Type {
+			case internal.AuthBearer, internal.AuthHMAC:
+				add("auth.token")
+			case internal.AuthOAuth2:
+				add("auth.token", "auth.cached", "auth.expiry")
+			}
+			if auth.Type == internal.AuthHMAC {
+				add("auth.canonical")
+			}
+		}
+		for _, keyPrefix := range responseKeyPrefixes {
+			kk.addPrefix(prefix + keyPrefix)
+		}
+	}
+	if stage > stageCertificate && len(request.CertificateCheckExpr) > 0 {
+		add("tls.check")
+	}
+	if stage > stageCertificate && len(request.ResponseSchema) > 0 {
+		add("resp.schema.valid", "resp.schema.errors")
+	}
+	if stage > stageExtract {
+		for _, name := range request.ExtractNames() {
+			kk.add(VariableKey(name))
+		}
+	}
+	if stage > stageResult {
+		add("success", "fail", "latency.exceeded")
+		if request.LatencyBudget > 0 {
+			add("latency.budget")
+		}
+	}
+	if stage > stageDegraded {
+		add("degraded", "status")
+	}
+	return kk
+}
+
+// probeKeys returns the keys of a probe available before any of its request runs.
+func probeKeys(probe *internal.Probe) *knownKeys {
+	kk := newKnownKeys()
+	kk.add("probe")
+	for _, key := range []string{"id", "starttime", "maintenance", "maintenance.window", "req", "current", "previous", "proxy", "cookie"} {
+		kk.add(fmt.Sprintf("probe.%s.%s", probe.Name, key))
+	}
+	if probe.Deadline > 0 {
+		kk.add(fmt.Sprintf("probe.%s.deadline", probe.Name))
+	}
+	kk.addPrefix(fmt.Sprintf("probe.%s.cookie.", probe.Name))
+	return kk
+}
+
+var (
+	celTypeNames = map[string]bool{
+		"int": true, "uint": true, "double": true, "bool": true, "string": true, "bytes": true,
+		"list": true, "map": true, "null_type": true, "type": true,
+	}
+)
+
+// keyReference is a probe context key used by an expression.
+type keyReference struct {
+	key string
+	// guard is true when the expression only checks the key existence with IsDefined
+	guard bool
+}
+
+// expressionReferences collects the probe context keys used by an expression, either as variables
+// or as literal argument of the context functions.
+func expressionReferences(expr *exprpb.Expr) []keyReference {
+	references := make([]keyReference, 0)
+	localVars := make(map[string]bool)
+	var walk func(e *exprpb.Expr)
+	walk = func(e *exprpb.Expr) {
+		if e == nil {
+			return
+		}
+		switch kind := e.ExprKind.(type) {
+		case *exprpb.Expr_IdentExpr:
+			references = append(references, keyReference{key: kind.IdentExpr.Name})
+		case *exprpb.Expr_SelectExpr:
+			if kind.SelectExpr.TestOnly {
+				walk(kind.SelectExpr.Operand)
+				return
+			}
+			if name, ok := qualifiedName(e); ok {
+				references = append(references, keyReference{key: name})
+				return
+			}
+			walk(kind.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			call := kind.CallExpr
+			if call.Function == operators.Index {
+				if name, ok := qualifiedName(e); ok {
+					references = append(references, keyReference{key: name})
+					return
+				}
+			}
+			if _, ok := contextFunctionArity[call.Function]; ok && len(call.Args) > 1 {
+				if key, ok := call.Args[1].ExprKind.(*exprpb.Expr_ConstExpr); ok {
+					if str, ok := key.ConstExpr.ConstantKind.(*exprpb.Constant_StringValue); ok {
+						references = append(references, keyReference{key: str.StringValue, guard: call.Function == "IsDefined"})
+					}
+				}
+				for _, arg := range call.Args[2:] {
+					walk(arg)
+				}
+				return
+			}
+			walk(call.Target)
+			for _, arg := range call.Args {
+				walk(arg)
+			}
+		case *exprpb.Expr_ListExpr:
+			for _, elem := range kind.ListExpr.Elements {
+				walk(elem)
+			}
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range kind.StructExpr.Entries {
+				walk(entry.GetMapKey())
+				walk(entry.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			comp := kind.ComprehensionExpr
+			localVars[comp.IterVar] = true
+			localVars[comp.AccuVar] = true
+			walk(comp.IterRange)
+			walk(comp.AccuInit)
+			walk(comp.LoopCondition)
+			walk(comp.LoopStep)
+			walk(comp.Result)
+		}
+	}
+	walk(expr)
+
+	filtered := make([]keyReference, 0, len(references))
+	for _, ref := range references {
+		root := strings.Split(ref.key, ".")[0]
+		if ref.key == contextVariable || localVars[root] || celTypeNames[ref.key] {
+			continue
+		}
+		filtered = append(filtered, ref)
+	}
+	return filtered
+}
+
+// qualifiedName returns the dotted name of a select chain such as probe.x.req.y.resp.code, string constant
+// indexes are taken as a select so req["y"].resp.code is req.y.resp.code
+func qualifiedName(e *exprpb.Expr) (string, bool) {
+	switch kind := e.ExprKind.(type) {
+	case *exprpb.Expr_IdentExpr:
+		return kind.IdentExpr.Name, true
+	case *exprpb.Expr_CallExpr:
+		call := kind.CallExpr
+		if call.Function != operators.Index || len(call.Args) != 2 {
+			return "", false
+		}
+		index, ok := call.Args[1].ExprKind.(*exprpb.Expr_ConstExpr)
+		if !ok {
+			return "", false
+		}
+		str, ok := index.ConstExpr.ConstantKind.(*exprpb.Constant_StringValue)
+		if !ok {
+			return "", false
+		}
+		operand, ok := qualifiedName(call.Args[0])
+		if !ok {
+			return "", false
+		}
+		return operand + "." + str.StringValue, true
+	case *exprpb.Expr_SelectExpr:
+		if kind.SelectExpr.TestOnly {
+			return "", false
+		}
+		operand, ok := qualifiedName(kind.SelectExpr.Operand)
+		if !ok {
+			return "", false
+		}
+		return operand + "." + kind.SelectExpr.Field, true
+	default:
+		return "", false
+	}
+}
+
+// typedKeys declares the available request keys of known type, also under the scope aliases, so the expressions
+// comparing them with a value of another type are reported.
+func typedKeys(probe *internal.Probe, available *knownKeys, aliases map[string]string) []*exprpb.Decl {
+	declarations := make([]*exprpb.Decl, 0)
+	for _, request := range probe.Requests {
+		prefix := fmt.Sprintf("probe.%s.req.%s", probe.Name, request.Name)
+		for suffix, typ := range requestKeyTypes {
+			key := prefix + "." + suffix
+			if !available.exact[key] {
+				continue
+			}
+			declarations = append(declarations, decls.NewVar(key, typ))
+			for alias, aliasPrefix := range aliases {
+				if strings.HasPrefix(key, aliasPrefix+".") {
+					declarations = append(declarations, decls.NewVar(alias+key[len(aliasPrefix):], typ))
+				}
+			}
+		}
+	}
+	return declarations
+}
+
+// validateExpression checks the expression syntax and that all keys it uses are available at that point.
+func validateExpression(report *ValidationReport, probe *internal.Probe, request *internal.ProbeRequest, field, expression string,
+	available, all *knownKeys, aliases map[string]string) {
+	if len(strings.TrimSpace(expression)) == 0 {
+		return
+	}
+	env, err := CelEnvironment()
+	if err != nil {
+		report.add(SeverityError, probe.Name, request.Name, field, "can not create expression environment. got %s", err.Error())
+		return
+	}
+	ast, issues := env.Parse(expression)
+	if issues != nil && issues.Err() != nil {
+		report.add(SeverityError, probe.Name, request.Name, field, "invalid expression [%s]. got %s", expression, strings.TrimSpace(issues.String()))
+		return
+	}
+	if _, err := CheckExpression(ast, typedKeys(probe, available, aliases)...); err != nil {
+		report.add(SeverityError, probe.Name, request.Name, field, "invalid expression [%s]. got %s", expression, strings.TrimSpace(err.Error()))
+		return
+	}
+	for _, ref := range expressionReferences(ast.Expr()) {
+		scoped := false
+		segments := strings.SplitN(ref.key, ".", 2)
+		switch segments[0] {
+		case ScopeThis, ScopePrev, ScopeReq:
+			prefix, ok := aliases[segments[0]]
+			if !ok {
+				report.add(SeverityError, probe.Name, request.Name, field, "%s is not available, there is no request before this one", ref.key)
+				continue
+			}
+			scoped = true
+			ref.key = prefix
+			if len(segments) > 1 {
+				ref.key = prefix + "." + segments[1]
+			}
+		}
+		switch {
+		case available.has(ref.key), scoped && available.hasUnder(ref.key):
+		case (all.has(ref.key) || scoped && all.hasUnder(ref.key)) && ref.guard:
+			report.add(SeverityError, probe.Name, request.Name, field, "IsDefined(%q) is always false, the key is only produced later in the chain", ref.key)
+		case all.has(ref.key), scoped && all.hasUnder(ref.key):
+			report.add(SeverityError, probe.Name, request.Name, field, "%s is not available yet, it is only produced later in the chain", ref.key)
+		case ref.guard:
+			report.add(SeverityWarning, probe.Name, request.Name, field, "IsDefined(%q) refers to a key the probe never produces", ref.key)
+		default:
+			report.add(SeverityError, probe.Name, request.Name, field, "unknown key %s", ref.key)
+		}
+	}
+}
+
+// ValidateConfig checks every probe of the configuration without running them: names, cron, urls, mailboxes,
+// and every expression against the keys produced so far in the request chain.
+func ValidateConfig(file string, config *internal.MIHPConfig) *ValidationReport {
+	report := &ValidationReport{File: file, Issues: make([]*ValidationIssue, 0)}
+	if config == nil || len(config.ProbePool) == 0 {
+		report.add(SeverityWarning, "", "", "probe_pool", "configuration contains no probe")
+		return report
+	}
+
+	names := make(map[string]bool)
+	ids := make(map[string]bool)
+	for _, probe := range config.ProbePool {
+		if len(probe.Name) == 0 {
+			report.add(SeverityError, probe.Name, "", "name", "probe name must not be empty")
+		} else if names[probe.Name] {
+			report.add(SeverityError, probe.Name, "", "name", "duplicate probe name")
+		} else if !internal.IsValidVariableName(probe.Name) {
+			report.add(SeverityWarning, probe.Name, "", "name", "probe name is not an identifier, its keys can only be read with the Get functions")
+		}
+		names[probe.Name] = true
+		if len(probe.ID) == 0 {
+			report.add(SeverityError, probe.Name, "", "id", "probe id must not be empty")
+		} else if ids[probe.ID] {
+			report.add(SeverityError, probe.Name, "", "id", "duplicate probe id %s", probe.ID)
+		}
+		ids[probe.ID] = true
+		validateProbe(report, probe)
+	}
+	for idx, window := range config.Maintenance {
+		field := fmt.Sprintf("maintenance[%d]", idx)
+		if err := CheckMaintenanceWindow(window); err != nil {
+			report.add(SeverityError, "", "", field, "%s", err.Error())
+		}
+		for _, p := range window.Probes {
+			if !names[p] && !ids[p] {
+				report.add(SeverityWarning, "", "", field, "window %s lists unknown probe %s", window.ID, p)
+			}
+		}
+	}
+	if config.Minion != nil && config.Minion.Proxy != nil {
+		if _, err := config.Minion.Proxy.ProxyURL(); err != nil {
+			report.add(SeverityError, "", "", "minion.proxy", "%s", err.Error())
+		}
+	}
+	return report
+}
+
+func validateProbe(report *ValidationReport, probe *internal.Probe) {
+	if _, err := cron.NewSchedule(probe.Cron); err != nil {
+		report.add(SeverityError, probe.Name, "", "cron", "invalid cron [%s]. got %s", probe.Cron, err.Error())
+	}
+	if u, err := url.Parse(probe.BaseURL); err != nil {
+		report.add(SeverityError, probe.Name, "", "base_url", "invalid url [%s]. got %s", probe.BaseURL, err.Error())
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		report.add(SeverityError, probe.Name, "", "base_url", "url [%s] must use http or https", probe.BaseURL)
+	}
+	if probe.UpThreshold < 0 || probe.DownThreshold < 0 {
+		report.add(SeverityError, probe.Name, "", "threshold", "up and down threshold must not be negative")
+	}
+	if probe.FlapWindow < 0 || probe.FlapWindow > HistorySize {
+		report.add(SeverityError, probe.Name, "", "flap_window", "flap window must be between 0 and %d", HistorySize)
+	} else if probe.FlapThreshold < 0 {
+		report.add(SeverityError, probe.Name, "", "flap_threshold", "flap threshold must not be negative")
+	} else if tracker := NewProbeEventTracker(probe.Name, probe.ID, probe); tracker.FlapThreshold >= tracker.FlapWindow {
+		report.add(SeverityWarning, probe.Name, "", "flap_threshold", "flap threshold %d is never reached within a window of %d results", tracker.FlapThreshold, tracker.FlapWindow)
+	}
+	if probe.Proxy != nil {
+		if _, err := probe.Proxy.ProxyURL(); err != nil {
+			report.add(SeverityError, probe.Name, "", "proxy", "%s", err.Error())
+		}
+	}
+	if probe.Auth != nil && probe.Auth.Type != internal.AuthNone {
+		validateAuth(report, probe.Name, "", probe.Auth)
+	}
+	if smtp := probe.SMTPNotification; smtp != nil {
+		mailboxes := map[string][]*internal.Mailbox{"SMTP_notification.to": smtp.To, "SMTP_notification.cc": smtp.Cc, "SMTP_notification.bcc": smtp.Bcc}
+		if smtp.From != nil {
+			mailboxes["SMTP_notification.from"] = []*internal.Mailbox{smtp.From}
+		} else {
+			report.add(SeverityError, probe.Name, "", "SMTP_notification.from", "sender mailbox is missing")
+		}
+		if len(smtp.To) == 0 {
+			report.add(SeverityError, probe.Name, "", "SMTP_notification.to", "no recipient mailbox")
+		}
+		fields := make([]string, 0, len(mailboxes))
+		for field := range mailboxes {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			for _, mailbox := range mailboxes[field] {
+				if mailbox == nil {
+					continue
+				}
+				if _, err := mail.ParseAddress(mailbox.Email); err != nil {
+					report.add(SeverityError, probe.Name, "", field, "invalid mailbox [%s]. got %s", mailbox.Email, err.Error())
+				}
+			}
+		}
+	}
+	if probe.LatencyBudget < 0 {
+		report.add(SeverityError, probe.Name, "", "latency_budget", "latency budget must not be negative")
+	}
+	if policy := probe.Escalation; policy != nil {
+		if policy.RenotifyEvery < 0 || policy.EscalateAfter < 0 {
+			report.add(SeverityError, probe.Name, "", "escalation", "renotify_every and escalate_after must not be negative")
+		}
+		if policy.EscalateAfter > 0 && len(policy.EscalateTo) == 0 && len(policy.EscalateCall) == 0 {
+			report.add(SeverityWarning, probe.Name, "", "escalation", "escalate_after is set but there is no escalate_to nor escalate_call")
+		}
+		for _, mailbox := range policy.EscalateTo {
+			if mailbox == nil {
+				continue
+			}
+			if _, err := mail.ParseAddress(mailbox.Email); err != nil {
+				report.add(SeverityError, probe.Name, "", "escalation.escalate_to", "invalid mailbox [%s]. got %s", mailbox.Email, err.Error())
+			}
+		}
+		if len(policy.EscalateCall) > 0 {
+			if u, err := url.Parse(policy.EscalateCall); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				report.add(SeverityError, probe.Name, "", "escalation.escalate_call", "invalid url [%s]", policy.EscalateCall)
+			}
+		}
+	}
+	for idx, window := range probe.Maintenance {
+		if err := CheckMaintenanceWindow(window); err != nil {
+			report.add(SeverityError, probe.Name, "", fmt.Sprintf("maintenance[%d]", idx), "%s", err.Error())
+		}
+	}
+	if len(probe.Requests) == 0 {
+		report.add(SeverityWarning, probe.Name, "", "requests", "probe has no request")
+		return
+	}
+
+	all := probeKeys(probe)
+	for _, request := range probe.Requests {
+		all.addAll(requestKeys(probe, request, stageDone))
+	}
+
+	done := probeKeys(probe)
+	requestNames := make(map[string]bool)
+	for seq, request := range probe.Requests {
+		if len(request.Name) == 0 {
+			report.add(SeverityError, probe.Name, request.Name, "name", "request name must not be empty")
+		} else if requestNames[request.Name] {
+			report.add(SeverityError, probe.Name, request.Name, "name", "duplicate request name")
+		} else if !internal.IsValidVariableName(request.Name) {
+			report.add(SeverityWarning, probe.Name, request.Name, "name", "request name is not an identifier, its keys can only be read with the Get functions")
+		}
+		requestNames[request.Name] = true
+		if len(strings.TrimSpace(request.PathExpr)) == 0 {
+			report.add(SeverityError, probe.Name, request.Name, "path_expr", "path expression must not be empty")
+		}
+		if len(strings.TrimSpace(request.MethodExpr)) == 0 {
+			report.add(SeverityError, probe.Name, request.Name, "method_expr", "method expression must not be empty")
+		}
+		if len(request.ResponseSchema) > 0 {
+			if _, err := LoadSchema(request.ResponseSchema); err != nil {
+				report.add(SeverityError, probe.Name, request.Name, "response_schema", "%s", err.Error())
+			}
+		}
+		if request.LatencyBudget < 0 {
+			report.add(SeverityError, probe.Name, request.Name, "latency_budget", "latency budget must not be negative")
+		}
+		if len(request.SuccessIfExpr) > 0 && len(request.FailIfExpr) > 0 {
+			report.add(SeverityWarning, probe.Name, request.Name, "fail_if_expr", "fail if expression is ignored because success if expression is set")
+		}
+
+		aliases := map[string]string{
+			ScopeThis: fmt.Sprintf("probe.%s.req.%s", probe.Name, request.Name),
+			ScopeReq:  fmt.Sprintf("probe.%s.req", probe.Name),
+		}
+		if seq > 0 {
+			aliases[ScopePrev] = fmt.Sprintf("probe.%s.req.%s", probe.Name, probe.Requests[seq-1].Name)
+		}
+		at := func(stage int) *knownKeys {
+			kk := newKnownKeys()
+			kk.addAll(done)
+			kk.addAll(requestKeys(probe, request, stage))
+			return kk
+		}
+		validateExpression(report, probe, request, "start_request_if_expr", request.StartRequestIfExpr, at(stageStart), all, aliases)
+		validateExpression(report, probe, request, "path_expr", request.PathExpr, at(stagePath), all, aliases)
+		validateExpression(report, probe, request, "method_expr", request.MethodExpr, at(stageMethod), all, aliases)
+		validateExpression(report, probe, request, "body_expr", request.BodyExpr, at(stageBody), all, aliases)
+		headerKeys := make([]string, 0, len(request.HeadersExpr))
+		for header := range request.HeadersExpr {
+			headerKeys = append(headerKeys, header)
+		}
+		sort.Strings(headerKeys)
+		for _, header := range headerKeys {
+			for _, expr := range request.HeadersExpr[header] {
+				validateExpression(report, probe, request, fmt.Sprintf("headers_expr.%s", header), expr, at(stageHeaders), all, aliases)
+			}
+		}
+		if request.Auth != nil && request.Auth.Type != internal.AuthNone {
+			validateAuth(report, probe.Name, request.Name, request.Auth)
+		}
+		if auth := EffectiveAuth(probe, request); auth != nil {
+			validateExpression(report, probe, request, "auth.token_expr", auth.TokenExpr, at(stageAuth), all, aliases)
+			validateExpression(report, probe, request, "auth.canonical_expr", auth.CanonicalExpr, at(stageAuth), all, aliases)
+		}
+		validateExpression(report, probe, request, "certificate_check_expr", request.CertificateCheckExpr, at(stageCertificate), all, aliases)
+		extracted := at(stageExtract)
+		for _, name := range request.ExtractNames() {
+			if !internal.IsValidVariableName(name) {
+				report.add(SeverityError, probe.Name, request.Name, fmt.Sprintf("extract.%s", name), "variable name is not a valid identifier")
+			}
+			validateExpression(report, probe, request, fmt.Sprintf("extract.%s", name), request.Extract[name], extracted, all, aliases)
+			extracted.add(VariableKey(name))
+		}
+		validateExpression(report, probe, request, "success_if_expr", request.SuccessIfExpr, at(stageResult), all, aliases)
+		validateExpression(report, probe, request, "fail_if_expr", request.FailIfExpr, at(stageResult), all, aliases)
+		validateExpression(report, probe, request, "degraded_if_expr", request.DegradedIfExpr, at(stageDegraded), all, aliases)
+
+		done.addAll(requestKeys(probe, request, stageDone))
+	}
+}
+
+// validateAuth checks the auth configuration has what its type needs.
+func validateAuth(report *ValidationReport, probeName, requestName string, auth *internal.AuthConfig) {
+	switch auth.Type {
+	case internal.AuthBasic:
+		if len(auth.Username) == 0 {
+			report.add(SeverityError, probeName, requestName, "auth.username", "basic auth needs a username")
+		}
+	case internal.AuthBearer:
+		if len(strings.TrimSpace(auth.TokenExpr)) == 0 {
+			report.add(SeverityError, probeName, requestName, "auth.token_expr", "bearer auth needs a token expression")
+		}
+	case internal.AuthOAuth2:
+		if u, err := url.Parse(auth.TokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			report.add(SeverityError, probeName, requestName, "auth.token_url", "invalid token url [%s]", auth.TokenURL)
+		}
+		if len(auth.ClientID) == 0 {
+			report.add(SeverityError, probeName, requestName, "auth.client_id", "oauth2 auth needs a client id")
+		}
+		switch auth.GrantType() {
+		case internal.GrantClientCredentials:
+		case internal.GrantPassword:
+			if len(auth.Username) == 0 {
+				report.add(SeverityError, probeName, requestName, "auth.username", "oauth2 password grant needs a username")
+			}
+		default:
+			report.add(SeverityError, probeName, requestName, "auth.grant", "unknown grant %s, must be %s or %s", auth.Grant, internal.GrantClientCredentials, internal.GrantPassword)
+		}
+	case internal.AuthHMAC:
+		if len(auth.Secret) == 0 {
+			report.add(SeverityError, probeName, requestName, "auth.secret", "hmac auth needs a secret")
+		}
+		if _, ok := hmacAlgorithms[strings.ToLower(auth.Algorithm)]; !ok && len(auth.Algorithm) > 0 {
+			report.add(SeverityError, probeName, requestName, "auth.algorithm", "unknown hmac algorithm %s", auth.Algorithm)
+		}
+	default:
+		report.add(SeverityError, probeName, requestName, "auth.type", "unknown auth type %s", auth.Type)
+	}
+}
@@ -0,0 +1,270 @@
+package probing
+
+import (
+	"github.com/newm4n/mihp/internal"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func issuesOf(report *ValidationReport, severity, field string) []string {
+	messages := make([]string, 0)
+	for _, issue := range report.Issues {
+		if issue.Severity == severity && issue.Field == field {
+			messages = append(messages, issue.Message)
+		}
+	}
+	return messages
+}
+
+func TestValidateConfig_Valid(t *testing.T) {
+	config := &internal.MIHPConfig{
+		ProbePool: internal.ProbePool{
+			{
+				Name:    "Shop",
+				ID:      "1",
+				BaseURL: "https://shop.example.com",
+				Cron:    "0 */5 * * * * *",
+				Requests: []*internal.ProbeRequest{
+					{
+						Name:          "Login",
+						PathExpr:      `"/login"`,
+						MethodExpr:    `"POST"`,
+						BodyExpr:      `"user=probe"`,
+						Extract:       map[string]string{"token": `GetJsonStringValue(probe.Shop.req.Login.resp.body, "token")`},
+						SuccessIfExpr: `probe.Shop.req.Login.resp.code == 200 && vars.token != ""`,
+					},
+					{
+						Name:               "Cart",
+						StartRequestIfExpr: `probe.Shop.req.Login.success`,
+						PathExpr:           `"/cart?items=" + string(GetLength("probe.Shop.req.Login.redirect.location"))`,
+						MethodExpr:         `"GET"`,
+						HeadersExpr:        map[string][]string{"Authorization": {`"Bearer " + vars.token`}},
+						SuccessIfExpr:      `probe.Shop.req.Cart.resp.code == 200 && GetStringElem("probe.Shop.req.Cart.resp.header.Content-Type", 0) != "" && [1, 2].all(x, x > 0)`,
+					},
+				},
+			},
+		},
+	}
+	report := ValidateConfig("config.yaml", config)
+	assert.False(t, report.HasError(), report.String())
+	assert.Equal(t, 0, len(report.Issues), report.String())
+}
+
+func TestValidateConfig_Problems(t *testing.T) {
+	config := &internal.MIHPConfig{
+		ProbePool: internal.ProbePool{
+			{
+				Name:          "Shop",
+				ID:            "1",
+				BaseURL:       "ftp://shop.example.com",
+				Cron:          "every minute",
+				FlapWindow:    5,
+				FlapThreshold: 5,
+				Maintenance:   []*internal.MaintenanceWindow{{ID: "deploy", Cron: "0 0 2 * 0 * *"}},
+				Escalation:    &internal.EscalationPolicy{EscalateAfter: time.Hour, EscalateTo: []*internal.Mailbox{{Email: "oncall"}}},
+				SMTPNotification: &internal.SMTPNotificationTarget{
+					From: &internal.Mailbox{Email: "probe@example.com"},
+					To:   []*internal.Mailbox{{Email: "not a mailbox"}},
+				},
+				Requests: []*internal.ProbeRequest{
+					{
+						Name:               "Login",
+						StartRequestIfExpr: `IsDefined("probe.Shop.req.Cart.success")`,
+						PathExpr:           `"/login" + probe.Shop.req.Login.resp.body`,
+						MethodExpr:         `"GET`,
+						SuccessIfExpr:      `probe.Shop.req.Login.resp.cod == 200`,
+						FailIfExpr:         `false`,
+					},
+					{
+						Name:       "Login",
+						PathExpr:   `"/cart"`,
+						MethodExpr: `"GET"`,
+					},
+					{
+						Name:           "Cart",
+						PathExpr:       `"/cart/" + vars.cart`,
+						MethodExpr:     `"GET"`,
+						Extract:        map[string]string{"cart": `"1"`},
+						LatencyBudget:  -time.Second,
+						DegradedIfExpr: `this.latency.exceeded || this.status == "DOWN"`,
+					},
+				},
+			},
+			{
+				Name:    "Shop",
+				ID:      "1",
+				BaseURL: "https://shop.example.com",
+				Cron:    "* * * * * * *",
+			},
+		},
+		Maintenance: []*internal.MaintenanceWindow{{ID: "weekly", Cron: "0 0 2 * 0 * *", Duration: time.Hour, Probes: []string{"Shop", "Blog"}}},
+	}
+	report := ValidateConfig("config.yaml", config)
+	assert.True(t, report.HasError())
+
+	assert.Len(t, issuesOf(report, SeverityError, "cron"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "base_url"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "SMTP_notification.to"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "name"), 2)
+	assert.Len(t, issuesOf(report, SeverityError, "id"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "method_expr"), 1)
+	assert.Len(t, issuesOf(report, SeverityWarning, "fail_if_expr"), 1)
+
+	startIssues := issuesOf(report, SeverityError, "start_request_if_expr")
+	assert.Len(t, startIssues, 1)
+	assert.Contains(t, startIssues[0], "always false")
+
+	pathIssues := issuesOf(report, SeverityError, "path_expr")
+	assert.Len(t, pathIssues, 2)
+	assert.Contains(t, pathIssues[0], "probe.Shop.req.Login.resp.body is not available yet")
+	assert.Contains(t, pathIssues[1], "vars.cart is not available yet")
+
+	successIssues := issuesOf(report, SeverityError, "success_if_expr")
+	assert.Len(t, successIssues, 1)
+	assert.Contains(t, successIssues[0], "unknown key probe.Shop.req.Login.resp.cod")
+
+	assert.Len(t, issuesOf(report, SeverityError, "latency_budget"), 1)
+	assert.Len(t, issuesOf(report, SeverityWarning, "flap_threshold"), 1)
+	maintenanceIssues := issuesOf(report, SeverityError, "maintenance[0]")
+	assert.Len(t, maintenanceIssues, 1)
+	assert.Contains(t, maintenanceIssues[0], "window deploy with cron must have a positive duration")
+	assert.Len(t, issuesOf(report, SeverityError, "escalation.escalate_to"), 1)
+	globalIssues := issuesOf(report, SeverityWarning, "maintenance[0]")
+	assert.Len(t, globalIssues, 1)
+	assert.Contains(t, globalIssues[0], "window weekly lists unknown probe Blog")
+	degradedIssues := issuesOf(report, SeverityError, "degraded_if_expr")
+	assert.Len(t, degradedIssues, 1)
+	assert.Contains(t, degradedIssues[0], "probe.Shop.req.Cart.status is not available yet")
+
+	assert.True(t, strings.Contains(report.String(), `error: config.yaml probe "Shop" request "Login" success_if_expr: unknown key`))
+}
+
+func TestValidateConfig_ScopeAliases(t *testing.T) {
+	config := &internal.MIHPConfig{
+		ProbePool: internal.ProbePool{
+			{
+				Name:    "Shop",
+				ID:      "1",
+				BaseURL: "https://shop.example.com",
+				Cron:    "0 */5 * * * * *",
+				Requests: []*internal.ProbeRequest{
+					{
+						Name:          "Login",
+						PathExpr:      `"/login" + prev.resp.body`,
+						MethodExpr:    `"POST"`,
+						SuccessIfExpr: `this.resp.code == 200 && size(this.resp.header) > 0 && this.resp.cod == 1`,
+					},
+					{
+						Name:          "Cart",
+						PathExpr:      `"/cart" + req.Cart.resp.body`,
+						MethodExpr:    `"GET"`,
+						HeadersExpr:   map[string][]string{"Cookie": {`req["Login"].resp.header["Set-Cookie"][0]`}},
+						SuccessIfExpr: `this.resp.code == prev.resp.code && "code" in req.Login.resp`,
+					},
+				},
+			},
+		},
+	}
+	report := ValidateConfig("config.yaml", config)
+
+	loginPath := issuesOf(report, SeverityError, "path_expr")
+	assert.Len(t, loginPath, 2)
+	assert.Contains(t, loginPath[0], "prev.resp.body is not available, there is no request before this one")
+	assert.Contains(t, loginPath[1], "probe.Shop.req.Cart.resp.body is not available yet")
+
+	successIssues := issuesOf(report, SeverityError, "success_if_expr")
+	assert.Len(t, successIssues, 1)
+	assert.Contains(t, successIssues[0], "unknown key probe.Shop.req.Login.resp.cod")
+
+	assert.Len(t, issuesOf(report, SeverityError, "headers_expr.Cookie"), 0)
+}
+
+func TestValidateConfig_Types(t *testing.T) {
+	config := &internal.MIHPConfig{
+		ProbePool: internal.ProbePool{
+			{
+				Name:    "Shop",
+				ID:      "1",
+				BaseURL: "https://shop.example.com",
+				Cron:    "0 */5 * * * * *",
+				Requests: []*internal.ProbeRequest{
+					{
+						Name:          "Login",
+						PathExpr:      `"/login"`,
+						MethodExpr:    `"POST"`,
+						SuccessIfExpr: `this.resp.code == 200 && this.duration < duration("1s") && this.resp.body.size > 0`,
+					},
+					{
+						Name:               "Cart",
+						StartRequestIfExpr: `GetStrng("probe.Shop.req.Login.resp.body") == "x"`,
+						PathExpr:           `"/cart/" + string(GetInt("probe.Shop.req.Login.resp.code", 3) == 200)`,
+						MethodExpr:         `"GET"`,
+						SuccessIfExpr:      `probe.Shop.req.Login.resp.code == "200"`,
+						FailIfExpr:         `prev.success == "true"`,
+					},
+				},
+			},
+		},
+	}
+	report := ValidateConfig("config.yaml", config)
+
+	for _, field := range []string{"start_request_if_expr", "path_expr", "success_if_expr", "fail_if_expr"} {
+		issues := issuesOf(report, SeverityError, field)
+		if assert.Len(t, issues, 1, field) {
+			assert.Contains(t, issues[0], "invalid expression")
+		}
+	}
+	assert.Contains(t, issuesOf(report, SeverityError, "start_request_if_expr")[0], "undeclared reference to 'GetStrng'")
+	assert.Contains(t, issuesOf(report, SeverityError, "path_expr")[0], "found no matching overload for 'GetInt'")
+	assert.Contains(t, issuesOf(report, SeverityError, "success_if_expr")[0], "found no matching overload for '_==_'")
+}
+
+func TestValidateConfig_Auth(t *testing.T) {
+	config := &internal.MIHPConfig{
+		ProbePool: internal.ProbePool{
+			{
+				Name:    "Api",
+				ID:      "1",
+				BaseURL: "https://api.example.com",
+				Cron:    "0 */5 * * * * *",
+				Auth:    &internal.AuthConfig{Type: internal.AuthOAuth2, TokenURL: "auth.example.com/token", Grant: "implicit"},
+				Requests: []*internal.ProbeRequest{
+					{
+						Name:          "Orders",
+						PathExpr:      `"/orders"`,
+						MethodExpr:    `"GET"`,
+						SuccessIfExpr: `this.auth.cached || this.auth.expiry > Now()`,
+					},
+					{
+						Name:          "Signed",
+						PathExpr:      `"/signed"`,
+						MethodExpr:    `"GET"`,
+						Auth:          &internal.AuthConfig{Type: internal.AuthHMAC, Algorithm: "md5", CanonicalExpr: `this.method + this.auth.date + this.resp.body`},
+						SuccessIfExpr: `this.auth.token != ""`,
+					},
+					{
+						Name:       "Bearer",
+						PathExpr:   `"/bearer"`,
+						MethodExpr: `"GET"`,
+						Auth:       &internal.AuthConfig{Type: "digest"},
+					},
+				},
+			},
+		},
+	}
+	report := ValidateConfig("config.yaml", config)
+
+	assert.Len(t, issuesOf(report, SeverityError, "auth.token_url"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "auth.client_id"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "auth.grant"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "auth.secret"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "auth.algorithm"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "auth.type"), 1)
+	assert.Len(t, issuesOf(report, SeverityError, "success_if_expr"), 0)
+
+	canonicalIssues := issuesOf(report, SeverityError, "auth.canonical_expr")
+	assert.Len(t, canonicalIssues, 1)
+	assert.Contains(t, canonicalIssues[0], "probe.Api.req.Signed.resp.body is not available yet")
+}
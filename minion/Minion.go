@@ -3,15 +3,21 @@ package minion
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/newm4n/mihp/internal"
+	"github.com/newm4n/mihp/internal/notification"
 	"github.com/newm4n/mihp/internal/probing"
 	"github.com/newm4n/mihp/minion/com"
+	mihperrors "github.com/newm4n/mihp/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -37,6 +43,11 @@ var (
 	PingTickDuration     = 30 * time.Second
 	MinionGroupList      = make(map[string]*PingPong)
 	CanPing              = true
+
+	// MaintenanceTickDuration is how often the maintenance windows are fetched from the central.
+	MaintenanceTickDuration = time.Minute
+	// EscalationTickDuration is how often the probes staying DOWN are checked for re-notification and escalation.
+	EscalationTickDuration = time.Minute
 )
 
 func init() {
@@ -48,6 +59,12 @@ func init() {
 
 func Initialize(MIHPConfig *internal.MIHPConfig) {
 	Config = MIHPConfig
+	if Config.Minion != nil {
+		probing.DefaultProxy = Config.Minion.Proxy
+	}
+	if err := probing.Maintenance.Replace(Config.Maintenance); err != nil {
+		logrus.Errorf("invalid maintenance window in configuration. got %s", err.Error())
+	}
 	if Config.ProbePool != nil {
 		for _, probe := range Config.ProbePool {
 			AcceptProbe(probe)
@@ -75,11 +92,101 @@ func Initialize(MIHPConfig *internal.MIHPConfig) {
 
 func AcceptProbe(probe *internal.Probe) {
 	if probe.SMTPNotification != nil {
-		EmailNotifChannel[probe.ID] = probing.NewProbeEventProcessor(probing.LogTrigger)
+		proc := probing.NewProbeEventProcessor(nil, probe)
+		proc.Trigger = notification.NewSMTPTrigger(probe.SMTPNotification, proc)
+		proc.Escalation = notification.NewSMTPEscalationTrigger(probe.SMTPNotification, probe.Escalation)
+		restoreState(proc, probe, "email")
+		EmailNotifChannel[probe.ID] = proc
+	}
+	if probe.CallbackNotification != nil {
+		proc := probing.NewProbeEventProcessor(notification.NewCallbackTrigger(probe.CallbackNotification), probe)
+		proc.Escalation = notification.NewCallbackEscalationTrigger(probe.CallbackNotification, probe.Escalation)
+		restoreState(proc, probe, "callback")
+		CallbackNotifChannel[probe.ID] = proc
 	}
 	// todo finish this MINION
 }
 
+// restoreState makes the processor save its trackers in the state directory, and restores them if saved before.
+func restoreState(proc *probing.ProbeEventProcessor, probe *internal.Probe, channel string) {
+	if Config.Minion != nil && len(Config.Minion.StateDir) > 0 {
+		proc.StateFile = filepath.Join(Config.Minion.StateDir, fmt.Sprintf("%s-%s.json", channel, probe.ID))
+		if err := proc.RestoreState(); err != nil {
+			logrus.Errorf("probe %s starts with a fresh state. got %s", probe.Name, err.Error())
+		}
+	}
+}
+
+// fetchCentral gets the data of a central api response.
+func fetchCentral(ctx context.Context, path string, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(Config.Minion.CentralBaseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("central responded %s", resp.Status)
+	}
+	body := &struct {
+		Data interface{} `json:"data"`
+	}{Data: data}
+	return json.NewDecoder(resp.Body).Decode(body)
+}
+
+// SyncMaintenance replaces the maintenance windows by the ones of the configuration and the ones added
+// to the central.
+func SyncMaintenance(ctx context.Context) error {
+	windows := make([]*internal.MaintenanceWindow, 0)
+	if err := fetchCentral(ctx, "/maintenance", &windows); err != nil {
+		return err
+	}
+	return probing.Maintenance.Replace(append(append([]*internal.MaintenanceWindow{}, Config.Maintenance...), windows...))
+}
+
+// SyncAcknowledgements applies the outage acknowledgements made on the central to the probe processors.
+func SyncAcknowledgements(ctx context.Context) error {
+	acks := make([]*probing.Acknowledgement, 0)
+	if err := fetchCentral(ctx, "/acknowledgements", &acks); err != nil {
+		return err
+	}
+	for _, ack := range acks {
+		for _, channel := range []map[string]*probing.ProbeEventProcessor{EmailNotifChannel, CallbackNotifChannel, LogNotifChannel} {
+			proc, ok := channel[ack.ProbeID]
+			if !ok {
+				continue
+			}
+			for name, probe := range proc.Probes {
+				if probe.ID != ack.ProbeID {
+					continue
+				}
+				if err := proc.Acknowledge(name, ack.By, ack.At); err != nil && !errors.Is(err, mihperrors.ErrProbeNotDown) {
+					logrus.Errorf("can not acknowledge probe %s. got %s", name, err.Error())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckEscalation re-notifies and escalates the probes staying DOWN, once the central acknowledgements are applied.
+func CheckEscalation(ctx context.Context) {
+	if Config.Minion != nil && len(Config.Minion.CentralBaseURL) > 0 {
+		if err := SyncAcknowledgements(ctx); err != nil {
+			logrus.Errorf("error while fetching acknowledgements from central. got %s", err.Error())
+		}
+	}
+	for _, channel := range []map[string]*probing.ProbeEventProcessor{EmailNotifChannel, CallbackNotifChannel, LogNotifChannel} {
+		for _, proc := range channel {
+			proc.CheckEscalation()
+		}
+	}
+}
+
 func MinionDaemonHandler(message *com.UDPMessage) {
 	go func() {
 		fromIP := com.ParseIP(message.FromAddr.IP.String())
@@ -221,6 +328,38 @@ func Start(ctx context.Context, config *internal.MIHPConfig) {
 		}
 	}()
 
+	// without a central, only the maintenance windows of the configuration apply
+	var maintenanceTicker *time.Ticker
+	stopMaintenanceTicker := make(chan bool)
+	if len(Config.Minion.CentralBaseURL) > 0 {
+		maintenanceTicker = time.NewTicker(MaintenanceTickDuration)
+		go func() {
+			for {
+				select {
+				case <-stopMaintenanceTicker:
+					return
+				case <-maintenanceTicker.C:
+					if err := SyncMaintenance(ctx); err != nil {
+						logrus.Errorf("error while fetching maintenance windows from central. got %s", err.Error())
+					}
+				}
+			}
+		}()
+	}
+
+	escalationTicker := time.NewTicker(EscalationTickDuration)
+	stopEscalationTicker := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stopEscalationTicker:
+				return
+			case <-escalationTicker.C:
+				CheckEscalation(ctx)
+			}
+		}
+	}()
+
 	gracefulStop := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
 	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
@@ -239,6 +378,14 @@ func Start(ctx context.Context, config *internal.MIHPConfig) {
 
 		pingTicker.Stop()
 		stopPingTicker <- true
+
+		if maintenanceTicker != nil {
+			maintenanceTicker.Stop()
+			stopMaintenanceTicker <- true
+		}
+
+		escalationTicker.Stop()
+		stopEscalationTicker <- true
 	}()
 
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
@@ -13,13 +13,25 @@ var (
 	ErrEvalError             = fmt.Errorf("error during cel-go evaluation")
 	ErrEvalReturnInvalid     = fmt.Errorf("%w : expression evaluation return is invalid", ErrEvalError)
 	ErrContextError          = fmt.Errorf("context error")
+	ErrProbeTimeout          = fmt.Errorf("%w : probe deadline exceeded", ErrContextError)
 	ErrStartRequestIfIsFalse = fmt.Errorf("probe request canStart is false")
 	ErrCreateHttpClient      = fmt.Errorf("error while creating http client")
+	ErrTLSConfig             = fmt.Errorf("invalid tls configuration")
 	ErrCreateHttpRequest     = fmt.Errorf("error while creating http request")
 	ErrHttpCallError         = fmt.Errorf("error while making http call")
 	ErrHttpBodyReadError     = fmt.Errorf("error while reading http response body")
+	ErrTooManyRedirects      = fmt.Errorf("too many http redirects")
+	ErrExtractError          = fmt.Errorf("error while extracting variable")
+	ErrSchemaError           = fmt.Errorf("error while loading json schema")
+	ErrAuthError             = fmt.Errorf("error while authenticating http request")
 	ErrSuccessIfIsFalse      = fmt.Errorf("probe result SuccessIfExpr false")
 	ErrFailIfIsTrue          = fmt.Errorf("probe result FailIfExpr true")
+	ErrCertificateCheckFalse = fmt.Errorf("probe result CertificateCheckExpr false")
+	ErrCertificateCheckNoTLS = fmt.Errorf("probe response CertificateCheckExpr without tls")
 
 	ErrConfigFileNotFound = fmt.Errorf("can not find default config file. please create one")
+	ErrSecretNotResolved  = fmt.Errorf("can not resolve secret reference")
+	ErrStateCorrupt       = fmt.Errorf("event tracker state is corrupt")
+	ErrInvalidMaintenance = fmt.Errorf("invalid maintenance window")
+	ErrProbeNotDown       = fmt.Errorf("probe is not down")
 )
@@ -1,6 +1,9 @@
 package helper
 
-import "reflect"
+import (
+	"reflect"
+	"time"
+)
 
 const (
 	BaseKindInt BaseKind = iota
@@ -17,7 +20,14 @@ const (
 
 type BaseKind int
 
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
 func GetBaseKindOfType(typ reflect.Type) BaseKind {
+	if typ == durationType {
+		return BaseKindDuration
+	}
 	switch typ.Kind() {
 	case reflect.Bool:
 		return BaseKindBool
@@ -0,0 +1,121 @@
+package helper
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// histogramSubBits gives 128 sub-buckets per power of two, keeping every recorded value within 1% of its bucket.
+	histogramSubBits  = 7
+	histogramSubCount = 1 << histogramSubBits
+	histogramHalf     = histogramSubCount / 2
+)
+
+// NewHistogram creates an empty histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{Counts: make(map[int]int64)}
+}
+
+// Histogram is a sparse HDR style histogram of non negative values. Values below 128 are counted exactly,
+// larger ones in log-linear buckets of at most 1% relative width.
+type Histogram struct {
+	Counts map[int]int64 `json:"counts"`
+	Count  int64         `json:"count"`
+	Sum    int64         `json:"sum"`
+	Min    int64         `json:"min"`
+	Max    int64         `json:"max"`
+}
+
+// histogramIndex returns the bucket of the value.
+func histogramIndex(value int64) int {
+	if value < histogramSubCount {
+		return int(value)
+	}
+	shift := bits.Len64(uint64(value)) - histogramSubBits
+	return histogramSubCount + (shift-1)*histogramHalf + int(value>>shift) - histogramHalf
+}
+
+// histogramHighest returns the highest value counted in the bucket.
+func histogramHighest(index int) int64 {
+	if index < histogramSubCount {
+		return int64(index)
+	}
+	shift := (index-histogramSubCount)/histogramHalf + 1
+	mantissa := int64((index-histogramSubCount)%histogramHalf + histogramHalf)
+	return (mantissa+1)<<shift - 1
+}
+
+// Record counts the value, negative values are counted as zero.
+func (h *Histogram) Record(value int64) {
+	if value < 0 {
+		value = 0
+	}
+	if h.Counts == nil {
+		h.Counts = make(map[int]int64)
+	}
+	h.Counts[histogramIndex(value)]++
+	if h.Count == 0 || value < h.Min {
+		h.Min = value
+	}
+	if value > h.Max {
+		h.Max = value
+	}
+	h.Count++
+	h.Sum += value
+}
+
+// Merge adds the values of that histogram to this one.
+func (h *Histogram) Merge(that *Histogram) {
+	if that == nil || that.Count == 0 {
+		return
+	}
+	if h.Counts == nil {
+		h.Counts = make(map[int]int64)
+	}
+	for index, count := range that.Counts {
+		h.Counts[index] += count
+	}
+	if h.Count == 0 || that.Min < h.Min {
+		h.Min = that.Min
+	}
+	if that.Max > h.Max {
+		h.Max = that.Max
+	}
+	h.Count += that.Count
+	h.Sum += that.Sum
+}
+
+// Mean returns the average of the recorded values, zero if there is none.
+func (h *Histogram) Mean() int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / h.Count
+}
+
+// Percentile returns the value below or at which the percentage of the recorded values are, such as 99 for p99.
+// Zero if there is no value.
+func (h *Histogram) Percentile(percentile float64) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(percentile / 100 * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	indexes := make([]int, 0, len(h.Counts))
+	for index := range h.Counts {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	var seen int64
+	for _, index := range indexes {
+		seen += h.Counts[index]
+		if seen >= rank {
+			return minint(maxint(histogramHighest(index), h.Min), h.Max)
+		}
+	}
+	return h.Max
+}
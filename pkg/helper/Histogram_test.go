@@ -0,0 +1,49 @@
+package helper
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestHistogramIndex(t *testing.T) {
+	for _, value := range []int64{0, 1, 127, 128, 129, 255, 256, 1000, 123456, 9876543210} {
+		index := histogramIndex(value)
+		assert.GreaterOrEqual(t, histogramHighest(index), value)
+		if index > 0 {
+			assert.Less(t, histogramHighest(index-1), value)
+		}
+	}
+}
+
+func TestHistogram_Percentile(t *testing.T) {
+	h := NewHistogram()
+	assert.Equal(t, int64(0), h.Percentile(99))
+
+	values := make([]int64, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		value := rand.Int63n(1000000000)
+		if i%100 == 0 {
+			value = 5000000000 + rand.Int63n(1000000000)
+		}
+		values = append(values, value)
+		h.Record(value)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, p := range []float64{50, 90, 99} {
+		exact := values[int(p/100*float64(len(values)))-1]
+		assert.InEpsilon(t, exact, h.Percentile(p), 0.01)
+	}
+	assert.Equal(t, values[len(values)-1], h.Percentile(100))
+	assert.Equal(t, values[0], h.Min)
+	assert.Equal(t, int64(10000), h.Count)
+
+	merged := NewHistogram()
+	merged.Record(7)
+	merged.Merge(h)
+	assert.Equal(t, int64(10001), merged.Count)
+	assert.Equal(t, int64(7), merged.Percentile(0))
+	assert.Equal(t, h.Max, merged.Percentile(100))
+}
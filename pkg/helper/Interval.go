@@ -116,6 +116,29 @@ func (i *Interval) AddRange(a, b int64) {
 	i.Ranges = nRange
 }
 
+// CountIn counts the values of the ranges between from and to, both inclusive. Steps are not counted.
+func (i *Interval) CountIn(from, to int64) int64 {
+	var count int64
+	for _, r := range i.Ranges {
+		if low, high := maxint(r.From, from), minint(r.To, to); low <= high {
+			count += high - low + 1
+		}
+	}
+	return count
+}
+
+// RemoveBefore drops the values of the ranges below val.
+func (i *Interval) RemoveBefore(val int64) {
+	nRange := make([]*Range, 0, len(i.Ranges))
+	for _, r := range i.Ranges {
+		if r.To < val {
+			continue
+		}
+		nRange = append(nRange, NewRange(maxint(r.From, val), r.To))
+	}
+	i.Ranges = nRange
+}
+
 func StringToInterval(seg string) (*Interval, error) {
 	itrv := &Interval{Ranges: make([]*Range, 0)}
 	if strings.ContainsAny(seg, " \t\n\r") {
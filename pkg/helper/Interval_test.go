@@ -79,6 +79,20 @@ func TestInterval_Add(t *testing.T) {
 	assert.False(t, inter.IsIn(9))
 }
 
+func TestInterval_CountIn(t *testing.T) {
+	inter := &Interval{Ranges: make([]*Range, 0)}
+	inter.AddRange(10, 19)
+	inter.AddRange(30, 39)
+	assert.Equal(t, int64(20), inter.CountIn(0, 100))
+	assert.Equal(t, int64(6), inter.CountIn(15, 30))
+	assert.Equal(t, int64(0), inter.CountIn(20, 29))
+
+	inter.RemoveBefore(15)
+	assert.Equal(t, int64(15), inter.CountIn(0, 100))
+	inter.RemoveBefore(25)
+	assert.Equal(t, 1, len(inter.Ranges))
+}
+
 func TestStringToInterval(t *testing.T) {
 	itv, err := StringToInterval("1,3,5")
 	assert.NoError(t, err)
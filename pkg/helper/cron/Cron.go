@@ -128,6 +128,28 @@ func (c *Schedule) IsIn(t time.Time) bool {
 	return true
 }
 
+// LastIn returns the latest second between from and to, both inclusive, that is in the schedule.
+// Days, hours and minutes not in the schedule are skipped entirely. Returns false if there is none.
+func (c *Schedule) LastIn(from, to time.Time) (time.Time, bool) {
+	t := to.Truncate(time.Second)
+	for !t.Before(from) {
+		y, mo, d := t.Date()
+		switch {
+		case !c.yearInterval.IsIn(int64(y)) || !c.monthInterval.IsIn(int64(mo)) || !c.dayInterval.IsIn(int64(d)) || !c.dayOfWeekInterval.IsIn(int64(t.Weekday())):
+			t = time.Date(y, mo, d, 0, 0, 0, 0, t.Location()).Add(-time.Second)
+		case !c.hourInterval.IsIn(int64(t.Hour())):
+			t = time.Date(y, mo, d, t.Hour(), 0, 0, 0, t.Location()).Add(-time.Second)
+		case !c.minuteInterval.IsIn(int64(t.Minute())):
+			t = time.Date(y, mo, d, t.Hour(), t.Minute(), 0, 0, t.Location()).Add(-time.Second)
+		case !c.secondInterval.IsIn(int64(t.Second())):
+			t = t.Add(-time.Second)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 var (
 	jobs       = make(map[string]*Job)
 	cronTicker *time.Ticker
@@ -158,12 +180,22 @@ func tickerEvent(t time.Time) {
 	for n, j := range jobs {
 		if j.Cron.IsIn(t) {
 			cronLogger.Debugf("executing job %s with cron cronSyntax %s at %s. Deadline for %s", n, j.Cron.cronSyntax, j.Deadline, t)
-			ctx, _ := context.WithTimeout(context.Background(), j.Deadline)
-			go j.JobFunc(ctx)
+			go runJob(j)
 		}
 	}
 }
 
+// runJob invokes the job function with a context that is cancelled once the job's Deadline passes,
+// or when the job function returns.
+func runJob(j *Job) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if j.Deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, j.Deadline)
+	}
+	defer cancel()
+	j.JobFunc(ctx)
+}
+
 // Start the scheduler server
 func Start() {
 	cronLogger.Info("Starting module")
@@ -55,3 +55,21 @@ func TestCronStruct_IsIn(t *testing.T) {
 	assert.True(t, cron.IsIn(time.Date(2020, time.April, 14, 15, 20, 18, 0, time.Local)))
 	assert.False(t, cron.IsIn(time.Date(2020, time.April, 14, 15, 20, 19, 0, time.Local)))
 }
+
+func TestSchedule_LastIn(t *testing.T) {
+	// every sunday at 02:00:00
+	cron, err := NewSchedule("0 0 2 * 0 * *")
+	assert.NoError(t, err)
+
+	at := time.Date(2021, 10, 17, 3, 30, 0, 0, time.UTC)
+	last, ok := cron.LastIn(at.Add(-2*time.Hour), at)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 10, 17, 2, 0, 0, 0, time.UTC), last)
+
+	_, ok = cron.LastIn(at.Add(-time.Hour), at)
+	assert.False(t, ok)
+
+	last, ok = cron.LastIn(at.Add(-8*24*time.Hour), at.Add(-24*time.Hour))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2021, 10, 10, 2, 0, 0, 0, time.UTC), last)
+}
@@ -18,8 +18,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"strconv"
-	"strings"
 )
 
 // NewJSONData will create a new instance of JSONData
@@ -38,41 +36,54 @@ type JSONNode struct {
 	interf interface{}
 }
 
+// typeName returns the go type name of the node value, or an empty string for a json null
+func (n *JSONNode) typeName() string {
+	if n.interf == nil {
+		return ""
+	}
+	return reflect.TypeOf(n.interf).String()
+}
+
+// IsNull checks if this node represent a json null
+func (n *JSONNode) IsNull() bool {
+	return n.interf == nil
+}
+
 // IsArray will check if this node represent an array
 func (n *JSONNode) IsArray() bool {
-	return reflect.TypeOf(n.interf).String() == "[]interface {}"
+	return n.typeName() == "[]interface {}"
 }
 
 // IsMap will check if this node represent a Map
 func (n *JSONNode) IsMap() bool {
-	return reflect.TypeOf(n.interf).String() == "map[string]interface {}"
+	return n.typeName() == "map[string]interface {}"
 }
 
 // IsString check if this node represent a string
 func (n *JSONNode) IsString() bool {
-	return reflect.TypeOf(n.interf).String() == "string"
+	return n.typeName() == "string"
 }
 
 // IsBool check if this node represent a boolean
 func (n *JSONNode) IsBool() bool {
-	return reflect.TypeOf(n.interf).String() == "bool"
+	return n.typeName() == "bool"
 }
 
 // IsFloat check if this node represent a float
 func (n *JSONNode) IsFloat() bool {
-	return reflect.TypeOf(n.interf).String() == "float64"
+	return n.typeName() == "float64"
 }
 
 // IsInt checks if this node represent an int
 func (n *JSONNode) IsInt() bool {
-	if reflect.TypeOf(n.interf).String() == "float64" {
+	if n.typeName() == "float64" {
 		v := reflect.ValueOf(n.interf)
 		f := v.Float()
 		i := int64(f)
 		f2 := float64(i)
 		return f == f2
 	}
-	return true
+	return false
 }
 
 // Len return length of element in this array. Will panic if this node is not an array
@@ -201,133 +212,55 @@ func (jo *JSONData) GetRootNode() *JSONNode {
 	return &JSONNode{interf: jo.jsonRoot}
 }
 
-// IsValidPath will check if the provided path is valid
+// IsValidPath will check if the provided path is valid and selects a single node
 func (jo *JSONData) IsValidPath(path string) bool {
+	_, err := jo.Find(path)
+	return err == nil
+}
+
+// Query returns the values selected by a JSONPath such as "items[*].id" or "$..price". Error returned if the path
+// can not be parsed, or if a definite path does not exist.
+func (jo *JSONData) Query(path string) ([]interface{}, error) {
 	if len(path) == 0 {
-		return true
-	}
-	pathArr := strings.Split(path, ".")
-	node := jo.GetRootNode()
-	return jo.validPathCheck(pathArr, node)
-}
-
-// validPathCheck is recursion function to traverse the json tree for checking valid path
-func (jo *JSONData) validPathCheck(pathArr []string, node *JSONNode) bool {
-	if len(pathArr) == 0 && (node.IsString() || node.IsInt() || node.IsFloat() || node.IsBool()) {
-		return true
-	}
-	p := pathArr[0]
-	if len(p) == 0 {
-		return false
-	}
-	if p[:1] == "[" && p[len(p)-1:] == "]" {
-		if node.IsArray() {
-			pn := p[1 : len(p)-1]
-			if len(pn) == 0 {
-				return false
-			}
-			n, err := strconv.Atoi(pn)
-			if err != nil {
-				return false
-			}
-			if n < 0 || n >= node.Len() {
-				return false
-			}
-			nNode := node.GetNodeAt(n)
-			nPathArr := pathArr[1:]
-			return jo.validPathCheck(nPathArr, nNode)
-		}
-		return false
-	}
-	if node.IsMap() {
-		if strings.Contains(p, "[") {
-			k := p[:strings.Index(p, "[")]
-			if !node.HaveKey(k) {
-				return false
-			}
-			nNode := node.Get(k)
-			nPathArr := []string{p[strings.Index(p, "["):]}
-			nPathArr = append(nPathArr, pathArr[1:]...)
-			return jo.validPathCheck(nPathArr, nNode)
-		}
-		if node.HaveKey(p) {
-			nNode := node.Get(p)
-			nPathArr := pathArr[1:]
-			return jo.validPathCheck(nPathArr, nNode)
-		}
-		return false
+		return []interface{}{jo.jsonRoot}, nil
 	}
-	return false
+	jsonPath, err := CompileJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return jsonPath.Evaluate(jo.jsonRoot)
 }
 
-// Get will retrieve the json node indicated by a path
+// Find will retrieve the single json node indicated by a path. Error returned if the path does not select exactly one node.
+func (jo *JSONData) Find(path string) (*JSONNode, error) {
+	values, err := jo.Query(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("%s is not a valid path - it selects %d nodes", path, len(values))
+	}
+	return &JSONNode{interf: values[0]}, nil
+}
+
+// Get will retrieve the json node indicated by a path, or nil if the path is not valid
 func (jo *JSONData) Get(path string) *JSONNode {
-	if len(path) == 0 {
-		return jo.GetRootNode()
-	}
-	pathArr := strings.Split(path, ".")
-	return jo.getByPath(pathArr, jo.GetRootNode())
-}
-
-// getByPath is recursion function to traverse the json tree for retrieving node at specified path
-func (jo *JSONData) getByPath(pathArr []string, node *JSONNode) *JSONNode {
-	if len(pathArr) == 0 && (node.IsString() || node.IsInt() || node.IsFloat() || node.IsBool()) {
-		return node
-	}
-	p := pathArr[0]
-	if len(p) == 0 {
-		panic("Not a valid path")
-	}
-	if p[:1] == "[" && p[len(p)-1:] == "]" {
-		if node.IsArray() {
-			pn := p[1 : len(p)-1]
-			if len(pn) == 0 {
-				panic("Not a valid path - array do not contain offset number")
-			}
-			n, err := strconv.Atoi(pn)
-			if err != nil {
-				panic("Not a valid path - array offset not number")
-			}
-			if n < 0 || n >= node.Len() {
-				panic("Not a valid path - array offset < 0 or >= length")
-			}
-			nNode := node.GetNodeAt(n)
-			nPathArr := pathArr[1:]
-			return jo.getByPath(nPathArr, nNode)
-		}
-		panic("Not a valid path - not an array")
-	}
-	if node.IsMap() {
-		if strings.Contains(p, "[") {
-			k := p[:strings.Index(p, "[")]
-			if !node.HaveKey(k) {
-				panic("Not a valid path - key not exist")
-			}
-			nNode := node.Get(k)
-			nPathArr := []string{p[strings.Index(p, "["):]}
-			nPathArr = append(nPathArr, pathArr[1:]...)
-			return jo.getByPath(nPathArr, nNode)
-		}
-		if node.HaveKey(p) {
-			nNode := node.Get(p)
-			nPathArr := pathArr[1:]
-			return jo.getByPath(nPathArr, nNode)
-		}
-		panic("Not a valid path - key not exist")
-	}
-	panic("Not a valid path")
+	node, err := jo.Find(path)
+	if err != nil {
+		return nil
+	}
+	return node
 }
 
 // GetString will get the string value from a json indicated by specified path. Error returned if path is not valid.
 func (jo *JSONData) GetString(path string) (string, error) {
-	b, err := jo.IsString(path)
+	node, err := jo.Find(path)
 	if err != nil {
 		return "", err
 	}
-	if !b {
+	if !node.IsString() {
 		return "", fmt.Errorf("%s is not a string", path)
 	}
-	node := jo.Get(path)
 	return node.GetString(), nil
 }
 
@@ -339,14 +272,13 @@ func (jo *JSONData) SetString(path, value string) error {
 
 // GetBool will get the bool value from a json indicated by specified path. Error returned if path is not valid.
 func (jo *JSONData) GetBool(path string) (bool, error) {
-	b, err := jo.IsBool(path)
+	node, err := jo.Find(path)
 	if err != nil {
 		return false, err
 	}
-	if !b {
+	if !node.IsBool() {
 		return false, fmt.Errorf("%s is not a boolean", path)
 	}
-	node := jo.Get(path)
 	return node.GetBool(), nil
 }
 
@@ -358,14 +290,13 @@ func (jo *JSONData) SetBool(path string, value bool) error {
 
 // GetFloat will get the float value from a json indicated by specified path. Error returned if path is not valid.
 func (jo *JSONData) GetFloat(path string) (float64, error) {
-	b, err := jo.IsFloat(path)
+	node, err := jo.Find(path)
 	if err != nil {
 		return 0, err
 	}
-	if !b {
+	if !node.IsFloat() {
 		return 0, fmt.Errorf("%s is not a float", path)
 	}
-	node := jo.Get(path)
 	return node.GetFloat(), nil
 }
 
@@ -377,14 +308,13 @@ func (jo *JSONData) SetFloat(path string, value float64) error {
 
 // GetInt will get the int value from a json indicated by specified path. Error returned if path is not valid.
 func (jo *JSONData) GetInt(path string) (int, error) {
-	b, err := jo.IsInt(path)
+	node, err := jo.Find(path)
 	if err != nil {
 		return 0, err
 	}
-	if !b {
+	if !node.IsInt() {
 		return 0, fmt.Errorf("%s is not an int", path)
 	}
-	node := jo.Get(path)
 	return node.GetInt(), nil
 }
 
@@ -396,48 +326,54 @@ func (jo *JSONData) SetInt(path string, value int) error {
 
 // IsArray will check if the node indicated by specified path is an Array node
 func (jo *JSONData) IsArray(path string) (bool, error) {
-	if !jo.IsValidPath(path) {
-		return false, fmt.Errorf("%s is not a valid path", path)
+	node, err := jo.Find(path)
+	if err != nil {
+		return false, err
 	}
-	return jo.Get(path).IsArray(), nil
+	return node.IsArray(), nil
 }
 
 // IsMap will check if the node indicated by specified path is a map node
 func (jo *JSONData) IsMap(path string) (bool, error) {
-	if !jo.IsValidPath(path) {
-		return false, fmt.Errorf("%s is not a valid path", path)
+	node, err := jo.Find(path)
+	if err != nil {
+		return false, err
 	}
-	return jo.Get(path).IsMap(), nil
+	return node.IsMap(), nil
 }
 
 // IsString will check if the node indicated by specified path is a string node
 func (jo *JSONData) IsString(path string) (bool, error) {
-	if !jo.IsValidPath(path) {
-		return false, fmt.Errorf("%s is not a valid path", path)
+	node, err := jo.Find(path)
+	if err != nil {
+		return false, err
 	}
-	return jo.Get(path).IsString(), nil
+	return node.IsString(), nil
 }
 
 // IsBool will check if the node indicated by specified path is a bool node
 func (jo *JSONData) IsBool(path string) (bool, error) {
-	if !jo.IsValidPath(path) {
-		return false, fmt.Errorf("%s is not a valid path", path)
+	node, err := jo.Find(path)
+	if err != nil {
+		return false, err
 	}
-	return jo.Get(path).IsBool(), nil
+	return node.IsBool(), nil
 }
 
 // IsFloat will check if the node indicated by specified path is a float node
 func (jo *JSONData) IsFloat(path string) (bool, error) {
-	if !jo.IsValidPath(path) {
-		return false, fmt.Errorf("%s is not a valid path", path)
+	node, err := jo.Find(path)
+	if err != nil {
+		return false, err
 	}
-	return jo.Get(path).IsFloat(), nil
+	return node.IsFloat(), nil
 }
 
 // IsInt will check if the node indicated by specified path is an int node
 func (jo *JSONData) IsInt(path string) (bool, error) {
-	if !jo.IsValidPath(path) {
-		return false, fmt.Errorf("%s is not a valid path", path)
+	node, err := jo.Find(path)
+	if err != nil {
+		return false, err
 	}
-	return jo.Get(path).IsInt(), nil
+	return node.IsInt(), nil
 }
@@ -0,0 +1,752 @@
+package jsontool
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type segmentKind int
+
+const (
+	segmentName segmentKind = iota
+	segmentWildcard
+	segmentIndex
+	segmentSlice
+	segmentFilter
+	segmentLength
+)
+
+// pathSegment is a single step of a JSONPath, such as .name, [0], [*], [1:3] or [?(@.status=='ok')]
+type pathSegment struct {
+	kind      segmentKind
+	recursive bool
+	names     []string
+	indexes   []int
+	slice     [3]*int
+	filter    filterExpr
+	text      string
+}
+
+// definite checks if the segment selects at most one node.
+func (s *pathSegment) definite() bool {
+	switch s.kind {
+	case segmentName:
+		return !s.recursive && len(s.names) == 1
+	case segmentIndex:
+		return !s.recursive && len(s.indexes) == 1
+	case segmentLength:
+		return !s.recursive
+	}
+	return false
+}
+
+// JSONPath is a compiled JSONPath expression. Both "$.items[0].id" and the legacy "items[0].id" notations are accepted.
+//
+// Supported are child names (.name or ['name']), wildcards (.* or [*]), recursive descent (..name), indexes
+// including negative ones ([0], [-1], [0,2]), slices ([1:3], [::2]), filters ([?(@.status=='ok' && @.price > 10)])
+// and the length() function returning the size of an array, object or string.
+type JSONPath struct {
+	path     string
+	segments []*pathSegment
+}
+
+// CompileJSONPath parses a JSONPath expression.
+func CompileJSONPath(path string) (*JSONPath, error) {
+	p := &pathParser{text: path}
+	segments, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid path - %s", path, err.Error())
+	}
+	return &JSONPath{path: path, segments: segments}, nil
+}
+
+// Definite checks if the path selects at most one node, ie. it has no wildcard, recursive descent, slice, filter or union.
+func (jp *JSONPath) Definite() bool {
+	for _, segment := range jp.segments {
+		if !segment.definite() {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the path as written.
+func (jp *JSONPath) String() string {
+	return jp.path
+}
+
+// Evaluate returns the values selected by the path in the json document. For a definite path, an error is returned
+// if the node does not exist, while an indefinite path simply selects no value.
+func (jp *JSONPath) Evaluate(root interface{}) ([]interface{}, error) {
+	return jp.evaluate(root, root)
+}
+
+// evaluate applies the path from the node, the root is the document's root used by the $ paths of filters.
+func (jp *JSONPath) evaluate(node, root interface{}) ([]interface{}, error) {
+	current := []interface{}{node}
+	definite := jp.Definite()
+	for _, segment := range jp.segments {
+		next := make([]interface{}, 0, len(current))
+		for _, value := range current {
+			candidates := []interface{}{value}
+			if segment.recursive {
+				candidates = descendants(value, make([]interface{}, 0))
+			}
+			for _, candidate := range candidates {
+				selected, err := segment.selectFrom(candidate, root)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, selected...)
+			}
+		}
+		if definite && len(next) == 0 {
+			return nil, fmt.Errorf("%s is not a valid path - %s not exist", jp.path, segment.text)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// selectFrom applies the segment to a single node.
+func (s *pathSegment) selectFrom(node, root interface{}) ([]interface{}, error) {
+	switch s.kind {
+	case segmentName:
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		ret := make([]interface{}, 0, len(s.names))
+		for _, name := range s.names {
+			if child, ok := obj[name]; ok {
+				ret = append(ret, child)
+			}
+		}
+		return ret, nil
+	case segmentWildcard:
+		return children(node), nil
+	case segmentIndex:
+		arr, ok := node.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		ret := make([]interface{}, 0, len(s.indexes))
+		for _, index := range s.indexes {
+			if index < 0 {
+				index += len(arr)
+			}
+			if index >= 0 && index < len(arr) {
+				ret = append(ret, arr[index])
+			}
+		}
+		return ret, nil
+	case segmentSlice:
+		arr, ok := node.([]interface{})
+		if !ok {
+			return nil, nil
+		}
+		return sliceOf(arr, s.slice), nil
+	case segmentFilter:
+		ret := make([]interface{}, 0)
+		for _, child := range children(node) {
+			ok, err := s.filter.test(child, root)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				ret = append(ret, child)
+			}
+		}
+		return ret, nil
+	case segmentLength:
+		switch v := node.(type) {
+		case []interface{}:
+			return []interface{}{float64(len(v))}, nil
+		case map[string]interface{}:
+			return []interface{}{float64(len(v))}, nil
+		case string:
+			return []interface{}{float64(utf8.RuneCountInString(v))}, nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown path segment %s", s.text)
+}
+
+// children returns the elements of an array, or the values of an object ordered by key.
+func children(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		ret := make([]interface{}, 0, len(v))
+		for _, key := range keys {
+			ret = append(ret, v[key])
+		}
+		return ret
+	}
+	return nil
+}
+
+// descendants returns the node and all the nodes under it, depth first.
+func descendants(node interface{}, out []interface{}) []interface{} {
+	out = append(out, node)
+	for _, child := range children(node) {
+		out = descendants(child, out)
+	}
+	return out
+}
+
+// sliceOf returns the elements of arr[start:end:step], negative start and end count from the end of the array.
+func sliceOf(arr []interface{}, slice [3]*int) []interface{} {
+	length := len(arr)
+	step := 1
+	if slice[2] != nil {
+		step = *slice[2]
+	}
+	ret := make([]interface{}, 0)
+	if step == 0 {
+		return ret
+	}
+	bound := func(i *int, def int) int {
+		if i == nil {
+			return def
+		}
+		v := *i
+		if v < 0 {
+			v += length
+		}
+		if step > 0 {
+			return int(math.Max(0, math.Min(float64(v), float64(length))))
+		}
+		return int(math.Max(-1, math.Min(float64(v), float64(length-1))))
+	}
+	if step > 0 {
+		for i := bound(slice[0], 0); i < bound(slice[1], length); i += step {
+			ret = append(ret, arr[i])
+		}
+	} else {
+		for i := bound(slice[0], length-1); i > bound(slice[1], -1); i += step {
+			ret = append(ret, arr[i])
+		}
+	}
+	return ret
+}
+
+// pathParser parses the JSONPath segments.
+type pathParser struct {
+	text string
+	pos  int
+}
+
+func (p *pathParser) parse() ([]*pathSegment, error) {
+	if strings.HasPrefix(p.text, "$") {
+		p.pos = 1
+	}
+	segments := make([]*pathSegment, 0)
+	for p.pos < len(p.text) {
+		start := p.pos
+		var segment *pathSegment
+		var err error
+		switch {
+		case strings.HasPrefix(p.text[p.pos:], ".."):
+			p.pos += 2
+			if p.pos < len(p.text) && p.text[p.pos] == '[' {
+				segment, err = p.parseBracket()
+			} else {
+				segment, err = p.parseDotted()
+			}
+			if segment != nil {
+				segment.recursive = true
+			}
+		case p.text[p.pos] == '.':
+			p.pos++
+			segment, err = p.parseDotted()
+		case p.text[p.pos] == '[':
+			segment, err = p.parseBracket()
+		case p.pos == 0:
+			segment, err = p.parseDotted()
+		default:
+			err = fmt.Errorf("unexpected %q at %d", p.text[p.pos], p.pos)
+		}
+		if err != nil {
+			return nil, err
+		}
+		segment.text = p.text[start:p.pos]
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// parseDotted parses the segment after a dot: a name, a wildcard or length()
+func (p *pathParser) parseDotted() (*pathSegment, error) {
+	if p.pos < len(p.text) && p.text[p.pos] == '*' {
+		p.pos++
+		return &pathSegment{kind: segmentWildcard}, nil
+	}
+	end := p.pos
+	for end < len(p.text) && p.text[end] != '.' && p.text[end] != '[' {
+		end++
+	}
+	name := p.text[p.pos:end]
+	if len(name) == 0 {
+		return nil, fmt.Errorf("empty name at %d", p.pos)
+	}
+	p.pos = end
+	if name == "length()" {
+		return &pathSegment{kind: segmentLength}, nil
+	}
+	return &pathSegment{kind: segmentName, names: []string{name}}, nil
+}
+
+// parseBracket parses a bracketed segment: names, indexes, wildcard, slice or filter
+func (p *pathParser) parseBracket() (*pathSegment, error) {
+	end, err := closingBracket(p.text, p.pos)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.TrimSpace(p.text[p.pos+1 : end])
+	p.pos = end + 1
+	switch {
+	case len(content) == 0:
+		return nil, fmt.Errorf("empty brackets")
+	case content == "*":
+		return &pathSegment{kind: segmentWildcard}, nil
+	case strings.HasPrefix(content, "?"):
+		expr := strings.TrimSpace(content[1:])
+		if !strings.HasPrefix(expr, "(") || !strings.HasSuffix(expr, ")") {
+			return nil, fmt.Errorf("filter %s must be enclosed in parentheses", content)
+		}
+		filter, err := parseFilter(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &pathSegment{kind: segmentFilter, filter: filter}, nil
+	case content[0] == '\'' || content[0] == '"':
+		names := make([]string, 0)
+		for _, part := range splitOutsideQuotes(content, ',') {
+			name, err := unquote(strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+		return &pathSegment{kind: segmentName, names: names}, nil
+	case strings.Contains(content, ":"):
+		parts := strings.Split(content, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid slice [%s]", content)
+		}
+		segment := &pathSegment{kind: segmentSlice}
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			if len(part) == 0 {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid slice [%s]", content)
+			}
+			segment.slice[i] = &n
+		}
+		return segment, nil
+	default:
+		indexes := make([]int, 0)
+		for _, part := range strings.Split(content, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("array offset %s is not a number", part)
+			}
+			indexes = append(indexes, n)
+		}
+		return &pathSegment{kind: segmentIndex, indexes: indexes}, nil
+	}
+}
+
+// closingBracket returns the position of the bracket closing the one at start, skipping quoted strings and nested brackets.
+func closingBracket(text string, start int) (int, error) {
+	depth := 0
+	var quote byte
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+			if depth == 0 {
+				if c != ']' {
+					return 0, fmt.Errorf("unbalanced parentheses at %d", i)
+				}
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("missing closing bracket for %d", start)
+}
+
+func splitOutsideQuotes(text string, sep byte) []string {
+	parts := make([]string, 0)
+	var quote byte
+	last := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == sep:
+			parts = append(parts, text[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, text[last:])
+}
+
+func unquote(text string) (string, error) {
+	if len(text) < 2 || (text[0] != '\'' && text[0] != '"') || text[len(text)-1] != text[0] {
+		return "", fmt.Errorf("invalid quoted name %s", text)
+	}
+	var sb strings.Builder
+	for i := 1; i < len(text)-1; i++ {
+		if text[i] == '\\' && i+1 < len(text)-1 {
+			i++
+		}
+		sb.WriteByte(text[i])
+	}
+	return sb.String(), nil
+}
+
+// filterExpr is a filter predicate evaluated against each array element, or object value.
+type filterExpr interface {
+	test(current, root interface{}) (bool, error)
+}
+
+type filterOr struct{ left, right filterExpr }
+
+func (f *filterOr) test(current, root interface{}) (bool, error) {
+	ok, err := f.left.test(current, root)
+	if err != nil || ok {
+		return ok, err
+	}
+	return f.right.test(current, root)
+}
+
+type filterAnd struct{ left, right filterExpr }
+
+func (f *filterAnd) test(current, root interface{}) (bool, error) {
+	ok, err := f.left.test(current, root)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return f.right.test(current, root)
+}
+
+type filterNot struct{ expr filterExpr }
+
+func (f *filterNot) test(current, root interface{}) (bool, error) {
+	ok, err := f.expr.test(current, root)
+	return !ok, err
+}
+
+// filterOperand is either a literal or a path relative to the current node (@) or to the root ($).
+type filterOperand struct {
+	path    *JSONPath
+	rooted  bool
+	literal interface{}
+	regex   *regexp.Regexp
+}
+
+// resolve returns the operand value and if it exists.
+func (o *filterOperand) resolve(current, root interface{}) (interface{}, bool) {
+	if o.path == nil {
+		return o.literal, true
+	}
+	if o.rooted {
+		current = root
+	}
+	values, err := o.path.evaluate(current, root)
+	if err != nil || len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+// filterExists is true if the operand path exists, such as [?(@.discount)]
+type filterExists struct{ operand *filterOperand }
+
+func (f *filterExists) test(current, root interface{}) (bool, error) {
+	_, ok := f.operand.resolve(current, root)
+	return ok, nil
+}
+
+type filterCompare struct {
+	left, right *filterOperand
+	operator    string
+}
+
+func (f *filterCompare) test(current, root interface{}) (bool, error) {
+	left, ok := f.left.resolve(current, root)
+	if !ok {
+		return false, nil
+	}
+	if f.operator == "=~" {
+		str, ok := left.(string)
+		return ok && f.right.regex.MatchString(str), nil
+	}
+	right, ok := f.right.resolve(current, root)
+	if !ok {
+		return false, nil
+	}
+	switch f.operator {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	}
+	if l, ok := left.(float64); ok {
+		if r, ok := right.(float64); ok {
+			return compareOrdered(f.operator, l < r, l == r), nil
+		}
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			return compareOrdered(f.operator, l < r, l == r), nil
+		}
+	}
+	return false, nil
+}
+
+func compareOrdered(operator string, less, equal bool) bool {
+	switch operator {
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	}
+	return false
+}
+
+// filterParser parses filter expressions: operands compared with ==, !=, <, <=, >, >= or =~ /regex/,
+// combined with &&, || and !, grouped with parentheses.
+type filterParser struct {
+	text string
+	pos  int
+}
+
+func parseFilter(text string) (filterExpr, error) {
+	p := &filterParser{text: text}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.text) {
+		return nil, fmt.Errorf("unexpected %q in filter %s", p.text[p.pos:], text)
+	}
+	return expr, nil
+}
+
+func (p *filterParser) skipSpaces() {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *filterParser) consume(token string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.text[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.consume("!") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{expr: expr}, nil
+	}
+	if p.consume("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, fmt.Errorf("missing closing parenthesis in filter %s", p.text)
+		}
+		return expr, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if !p.consume(operator) {
+			continue
+		}
+		if operator == "=~" {
+			regex, err := p.parseRegex()
+			if err != nil {
+				return nil, err
+			}
+			return &filterCompare{left: left, right: &filterOperand{regex: regex}, operator: operator}, nil
+		}
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return &filterCompare{left: left, right: right, operator: operator}, nil
+	}
+	if left.path == nil {
+		return nil, fmt.Errorf("literal %v is not a condition in filter %s", left.literal, p.text)
+	}
+	return &filterExists{operand: left}, nil
+}
+
+func (p *filterParser) parseOperand() (*filterOperand, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.text) {
+		return nil, fmt.Errorf("missing operand in filter %s", p.text)
+	}
+	start := p.pos
+	switch c := p.text[p.pos]; {
+	case c == '@' || c == '$':
+		p.pos++
+		for p.pos < len(p.text) && !strings.ContainsRune(" =!<>&|)", rune(p.text[p.pos])) {
+			if p.text[p.pos] == '[' {
+				end, err := closingBracket(p.text, p.pos)
+				if err != nil {
+					return nil, err
+				}
+				p.pos = end
+			}
+			p.pos++
+		}
+		path, err := CompileJSONPath("$" + p.text[start+1:p.pos])
+		if err != nil {
+			return nil, err
+		}
+		return &filterOperand{path: path, rooted: c == '$'}, nil
+	case c == '\'' || c == '"':
+		p.pos++
+		for p.pos < len(p.text) && p.text[p.pos] != c {
+			if p.text[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.text) {
+			return nil, fmt.Errorf("unterminated string in filter %s", p.text)
+		}
+		p.pos++
+		str, err := unquote(p.text[start:p.pos])
+		if err != nil {
+			return nil, err
+		}
+		return &filterOperand{literal: str}, nil
+	default:
+		for p.pos < len(p.text) && !strings.ContainsRune(" =!<>&|)", rune(p.text[p.pos])) {
+			p.pos++
+		}
+		word := p.text[start:p.pos]
+		switch word {
+		case "true":
+			return &filterOperand{literal: true}, nil
+		case "false":
+			return &filterOperand{literal: false}, nil
+		case "null":
+			return &filterOperand{literal: nil}, nil
+		}
+		f, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid operand %q in filter %s", word, p.text)
+		}
+		return &filterOperand{literal: f}, nil
+	}
+}
+
+// parseRegex parses a /pattern/ literal, with an optional i flag for case insensitive matching.
+func (p *filterParser) parseRegex() (*regexp.Regexp, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.text) || p.text[p.pos] != '/' {
+		return nil, fmt.Errorf("=~ expects a /regex/ in filter %s", p.text)
+	}
+	end := p.pos + 1
+	for end < len(p.text) && p.text[end] != '/' {
+		if p.text[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.text) {
+		return nil, fmt.Errorf("unterminated regex in filter %s", p.text)
+	}
+	pattern := p.text[p.pos+1 : end]
+	p.pos = end + 1
+	if p.pos < len(p.text) && p.text[p.pos] == 'i' {
+		pattern = "(?i)" + pattern
+		p.pos++
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,111 @@
+package jsontool
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const storeJSON = `
+{
+	"store": {
+		"name": "Corner Shop",
+		"items": [
+			{"id": 1, "name": "apple", "price": 0.5, "status": "ok", "tags": ["fruit", "red"]},
+			{"id": 2, "name": "bread", "price": 2.25, "status": "out", "discount": 0.25},
+			{"id": 3, "name": "cheese", "price": 7, "status": "ok", "tags": []},
+			{"id": 4, "name": "dates", "price": 12.5, "status": "ok"}
+		],
+		"owner": {"name": "Jane", "address": {"city": "Metro City"}},
+		"limit": 5
+	}
+}`
+
+func TestJSONPath_Query(t *testing.T) {
+	jdata, err := NewJSONData([]byte(storeJSON))
+	assert.NoError(t, err)
+
+	testData := []struct {
+		path   string
+		expect []interface{}
+	}{
+		{"store.name", []interface{}{"Corner Shop"}},
+		{"$.store.name", []interface{}{"Corner Shop"}},
+		{"$['store']['owner'].name", []interface{}{"Jane"}},
+		{"store.items[1].name", []interface{}{"bread"}},
+		{"store.items[-1].name", []interface{}{"dates"}},
+		{"store.items[0,2].id", []interface{}{float64(1), float64(3)}},
+		{"store.items[*].id", []interface{}{float64(1), float64(2), float64(3), float64(4)}},
+		{"store.items.*.id", []interface{}{float64(1), float64(2), float64(3), float64(4)}},
+		{"store.items[1:3].id", []interface{}{float64(2), float64(3)}},
+		{"store.items[:2].id", []interface{}{float64(1), float64(2)}},
+		{"store.items[-2:].id", []interface{}{float64(3), float64(4)}},
+		{"store.items[::2].id", []interface{}{float64(1), float64(3)}},
+		{"store.items[::-1].id", []interface{}{float64(4), float64(3), float64(2), float64(1)}},
+		{"$..city", []interface{}{"Metro City"}},
+		{"$..items[0].tags[0]", []interface{}{"fruit"}},
+		{"$.store.owner..name", []interface{}{"Jane"}},
+		{"store.items[?(@.status=='ok')].id", []interface{}{float64(1), float64(3), float64(4)}},
+		{"store.items[?(@.status == 'ok' && @.price > 5)].name", []interface{}{"cheese", "dates"}},
+		{"store.items[?(@.price < 1 || @.name == \"dates\")].name", []interface{}{"apple", "dates"}},
+		{"store.items[?(@.discount)].name", []interface{}{"bread"}},
+		{"store.items[?(!@.tags)].name", []interface{}{"bread", "dates"}},
+		{"store.items[?(@.price >= $.store.limit)].id", []interface{}{float64(3), float64(4)}},
+		{"store.items[?(@.name =~ /^B/i)].id", []interface{}{float64(2)}},
+		{"store.items[?(@.tags[0] == 'fruit')].name", []interface{}{"apple"}},
+		{"store.items[?(@.status != 'ok')].name", []interface{}{"bread"}},
+		{"store.items.length()", []interface{}{float64(4)}},
+		{"store.items[0].tags.length()", []interface{}{float64(2)}},
+		{"store.owner.length()", []interface{}{float64(2)}},
+		{"store.name.length()", []interface{}{float64(11)}},
+		{"store.items[*].discount", []interface{}{0.25}},
+		{"store.items[?(@.price > 100)].id", []interface{}{}},
+	}
+	for _, td := range testData {
+		values, err := jdata.Query(td.path)
+		assert.NoError(t, err, td.path)
+		assert.Equal(t, td.expect, values, td.path)
+	}
+}
+
+func TestJSONPath_Errors(t *testing.T) {
+	jdata, err := NewJSONData([]byte(storeJSON))
+	assert.NoError(t, err)
+
+	for _, path := range []string{
+		"store.nokey",
+		"store.items[10]",
+		"store.name.first",
+		"store.items[]",
+		"store.items[a]",
+		"store.",
+		"store.items[?(@.price >)]",
+		"store.items[?@.price > 1]",
+		"store.items[0",
+	} {
+		_, err := jdata.Query(path)
+		assert.Error(t, err, path)
+		assert.False(t, jdata.IsValidPath(path), path)
+		assert.Nil(t, jdata.Get(path), path)
+	}
+
+	_, err = jdata.GetString("store.items[*].name")
+	assert.Error(t, err)
+	_, err = jdata.GetInt("store.name")
+	assert.Error(t, err)
+	_, err = jdata.GetString("store.nokey")
+	assert.Error(t, err)
+
+	i, err := jdata.GetInt("store.items.length()")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, i)
+	s, err := jdata.GetString("$.store.items[?(@.id == 3)].name")
+	assert.NoError(t, err)
+	assert.Equal(t, "cheese", s)
+
+	nullData, err := NewJSONData([]byte(`{"a": null}`))
+	assert.NoError(t, err)
+	isString, err := nullData.IsString("a")
+	assert.NoError(t, err)
+	assert.False(t, isString)
+	assert.True(t, nullData.Get("a").IsNull())
+}